@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Admin API status, the published registry status plus every managed namespace secret, and the planned changes in dry run mode
+type adminStatus struct {
+	controllerStatus
+	Secrets []secretStatus  `json:"secrets"`
+	Plan    []plannedChange `json:"plan,omitempty"`
+}
+
+// Admin API renew response
+type adminRenewResponse struct {
+	Enqueued []string `json:"enqueued"`
+}
+
+// Add the admin API routes to the diagnostic HTTP server mux
+func (c *controller) addAdminRoutes(mux *http.ServeMux) {
+	mux.Handle("/status", http.HandlerFunc(c.serveAdminStatus))
+	mux.Handle("/renew", http.HandlerFunc(c.serveAdminRenew))
+	mux.Handle("/queue", http.HandlerFunc(c.serveAdminQueue))
+}
+
+// GET /status
+func (c *controller) serveAdminStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := adminStatus{controllerStatus: c.Status.snapshot(), Secrets: c.Status.secretsSnapshot()}
+	if c.DryRun != nil {
+		status.Plan = c.DryRun.plan()
+	}
+
+	writeAdminJSON(w, http.StatusOK, status)
+}
+
+// POST /renew, renews all namespaces unless a namespace or registry query parameter is used
+func (c *controller) serveAdminRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	nsName, registry := r.URL.Query().Get("namespace"), r.URL.Query().Get("registry")
+	if nsName != "" && registry != "" {
+		http.Error(w, "only one of namespace or registry can be used", http.StatusBadRequest)
+		return
+	}
+	// The namespace is used as the queue key so must not be one of the reserved keys, none of which are valid namespace names
+	if nsName != "" {
+		if msgs := validation.IsDNS1123Label(nsName); len(msgs) > 0 {
+			http.Error(w, "invalid namespace "+nsName+": "+strings.Join(msgs, ", "), http.StatusBadRequest)
+			return
+		}
+	}
+
+	keys, err := c.getRenewalKeys(nsName, registry)
+	if err != nil {
+		glog.Warningf("Admin renew failed: %s\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(keys) == 0 {
+		http.Error(w, "no namespaces request registry "+registry, http.StatusNotFound)
+		return
+	}
+
+	for _, key := range keys {
+		glog.Infof("Admin renew adding queue key [%s]\n", key)
+		c.Queue.Add(key)
+	}
+	writeAdminJSON(w, http.StatusAccepted, adminRenewResponse{Enqueued: keys})
+}
+
+// GET /queue
+func (c *controller) serveAdminQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, c.Queue.snapshot())
+}
+
+// Get the queue keys to renew, the namespace key, the keys of the namespaces requesting the registry or the all namespaces key
+// Served on the diagnostic server's go routine, the read lock is held as working out the namespaces requesting a registry uses the config and policy throughout, is a rare on-call action
+func (c *controller) getRenewalKeys(nsName, registry string) ([]string, error) {
+	c.ConfigMutex.RLock()
+	defer c.ConfigMutex.RUnlock()
+
+	if nsName != "" {
+		return []string{nsName}, nil
+	}
+	if registry == "" {
+		return []string{allNamespacesKey}, nil
+	}
+
+	inputs, err := c.getRenewalInputs(allNamespacesKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "get renewal inputs failed")
+	}
+	nss, err := c.getNamespacesToProcess(allNamespacesKey, inputs)
+	if err != nil {
+		return nil, errors.Wrap(err, "get namespaces to process failed")
+	}
+
+	keys := []string{}
+	for _, ns := range nss {
+		for _, k := range inputs.getNamespaceSecretNames(ns) {
+			if inputs.getRegistry(k) == registry {
+				keys = append(keys, ns.Name)
+				break
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+func writeAdminJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		glog.Warningf("Admin API response encoding failed: %s\n", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAPI(t *testing.T) {
+	config := getDefaultConfig()
+	config.AdminAPI = true
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true"},
+		},
+		{
+			Name:     ns2,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", ecr2: "true"},
+		},
+	})
+	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
+	prometheusRegistry := prometheus.NewRegistry()
+	ecrClient := NewFakeECRClient()
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+	assert.Nil(t, err, "New controller error")
+	err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+	assert.Nil(t, err, "Renewal error")
+
+	mux := http.NewServeMux()
+	ctrl.addAdminRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/status")
+	assert.Nil(t, err, "Get status error")
+	status := adminStatus{}
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&status), "Status decode error")
+	res.Body.Close()
+	if assert.Equal(t, 3, len(status.Secrets), "Secrets count") {
+		assert.Equal(t, ns1, status.Secrets[0].Namespace, "Secret namespace")
+		assert.Equal(t, ecr1, status.Secrets[0].Name, "Secret name")
+		assert.Equal(t, []string{ecr1}, status.Secrets[0].Registries, "Secret registries")
+		assert.NotNil(t, status.Secrets[0].IssuedAt, "Secret issued at")
+		assert.NotNil(t, status.Secrets[0].Expiry, "Secret expiry")
+		assert.Empty(t, status.Secrets[0].LastError, "Secret last error")
+		assert.Equal(t, ecr2, status.Secrets[2].Name, "Failed secret name")
+		assert.Nil(t, status.Secrets[2].IssuedAt, "Failed secret issued at")
+		assert.NotEmpty(t, status.Secrets[2].LastError, "Failed secret last error")
+	}
+	assert.Equal(t, 2, len(status.Registries), "Registries count")
+
+	for _, tc := range []struct {
+		Name               string   // Test case name
+		Method             string   // Request method
+		Query              string   // Request query
+		ExpectedStatusCode int      // Expected response status code
+		ExpectedEnqueued   []string // Expected enqueued keys
+	}{
+		{
+			Name:               "Renew all namespaces",
+			Method:             http.MethodPost,
+			ExpectedStatusCode: http.StatusAccepted,
+			ExpectedEnqueued:   []string{allNamespacesKey},
+		},
+		{
+			Name:               "Renew namespace",
+			Method:             http.MethodPost,
+			Query:              "?namespace=" + ns1,
+			ExpectedStatusCode: http.StatusAccepted,
+			ExpectedEnqueued:   []string{ns1},
+		},
+		{
+			Name:               "Renew registry",
+			Method:             http.MethodPost,
+			Query:              "?registry=" + ecr2,
+			ExpectedStatusCode: http.StatusAccepted,
+			ExpectedEnqueued:   []string{ns2},
+		},
+		{
+			Name:               "Renew registry with no namespaces",
+			Method:             http.MethodPost,
+			Query:              "?registry=" + ecr3,
+			ExpectedStatusCode: http.StatusNotFound,
+		},
+		{
+			Name:               "Renew namespace and registry",
+			Method:             http.MethodPost,
+			Query:              "?namespace=" + ns1 + "&registry=" + ecr1,
+			ExpectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			Name:               "Renew all namespaces key as namespace",
+			Method:             http.MethodPost,
+			Query:              "?namespace=" + url.QueryEscape(allNamespacesKey),
+			ExpectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			Name:               "Renew config reload key as namespace",
+			Method:             http.MethodPost,
+			Query:              "?namespace=" + url.QueryEscape(configReloadKey),
+			ExpectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			Name:               "Renew image pull credential key as namespace",
+			Method:             http.MethodPost,
+			Query:              "?namespace=" + url.QueryEscape(imagePullCredentialKeyPrefix+"x"),
+			ExpectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			Name:               "Renew with get",
+			Method:             http.MethodGet,
+			ExpectedStatusCode: http.StatusMethodNotAllowed,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.Method, srv.URL+"/renew"+tc.Query, nil)
+			res, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err, "Renew error")
+			defer res.Body.Close()
+			assert.Equal(t, tc.ExpectedStatusCode, res.StatusCode, "Renew status code")
+			if tc.ExpectedEnqueued != nil {
+				renewRes := adminRenewResponse{}
+				assert.Nil(t, json.NewDecoder(res.Body).Decode(&renewRes), "Renew decode error")
+				assert.Equal(t, tc.ExpectedEnqueued, renewRes.Enqueued, "Renew enqueued")
+			}
+		})
+	}
+
+	res, err = http.Get(srv.URL + "/queue")
+	assert.Nil(t, err, "Get queue error")
+	queue := queueSnapshot{}
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&queue), "Queue decode error")
+	res.Body.Close()
+	assert.Equal(t, []string{allNamespacesKey, ns1, ns2}, queue.Pending, "Queue pending keys")
+	assert.Empty(t, queue.Processing, "Queue processing keys")
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	defaultCommandName = "run" // Used if no command is given, so existing deployments that only pass flags continue to work
+)
+
+// CLI command, commands share the config flags, see getConfig, args[0] is the program and command name
+type command struct {
+	Name        string
+	Description string
+	Run         func(args []string) error
+}
+
+func getCommands() []command {
+	return []command{
+		{Name: "credentials", Description: "Add, list, remove or rotate the host namespace AWS credentials secrets", Run: runCredentialsCommand},
+		{Name: "doctor", Description: "Check the config, the RBAC permissions the enabled features need and the AWS credentials secrets, optionally fetching a token with each", Run: runDoctorCommand},
+		{Name: "manifests", Description: "Render the namespace, service account, RBAC, deployment and optional pod disruption budget manifests for the config", Run: runManifestsCommand},
+		{Name: "namespace", Description: "Enable, disable or list the registries namespaces request", Run: runNamespaceCommand},
+		{Name: "renew", Description: "Renew all namespaces, a single namespace or the namespaces requesting a registry, then print a summary", Run: runRenewCommand},
+		{Name: "run", Description: "Run the controller, the default if no command is given", Run: runMain},
+		{Name: "status", Description: "Print the managed secrets and their expiries, and the published registry status, read from the cluster", Run: runStatusCommand},
+		{Name: "uninstall", Description: "Delete the managed secrets, remove them from service accounts and optionally strip the namespace labels, prompts for confirmation", Run: runUninstallCommand},
+		{Name: "validate", Description: "Validate the config and the AWS credentials secrets the labelled namespaces need", Run: runValidateCommand},
+		{Name: "version", Description: "Print the version", Run: runVersionCommand},
+	}
+}
+
+// Run the command named by the first arg, defaults to run if the first arg is a flag or there are no args
+func runCommand(args []string) error {
+	return dispatchCommand(args, getCommands(), defaultCommandName)
+}
+
+// Run the command named by the first arg, commands with subcommands also use this, with no default so a subcommand is required
+func dispatchCommand(args []string, commands []command, defaultName string) error {
+	name, cmdArgs := defaultName, args
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		name, cmdArgs = args[1], append([]string{args[0] + " " + args[1]}, args[2:]...)
+	}
+
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd.Run(cmdArgs)
+		}
+	}
+
+	writeUsage(os.Stderr, args[0], commands)
+	if name == "" {
+		return errors.New("a command is required")
+	}
+	return errors.Errorf("unknown command [%s]", name)
+}
+
+func writeUsage(w io.Writer, program string, commands []command) {
+	fmt.Fprintf(w, "Usage: %s [command] [flags]\n\nCommands:\n", program)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.Name, cmd.Description)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nUse %s [command] -h to see the flags\n", program)
+}
+
+// New up a controller for a command, no informers are used as commands make a single pass using the k8s client, so can be run from a laptop with a kube config
+func newCommandController(config config) (*controller, error) {
+	glog.Infoln("Newing up k8s client")
+	k8sClient, err := newK8sClient(config.KubeConfigFilePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "newK8sClient failed")
+	}
+
+	glog.Infoln("Newing up controller for a command")
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), newECRClient())
+	if err != nil {
+		return nil, errors.Wrap(err, "newController failure")
+	}
+
+	return ctrl, nil
+}
+
+// Renew command, renews all namespaces unless the namespace or registry flag is used
+func runRenewCommand(args []string) error {
+	var nsName, registry string
+	config, err := getConfig(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&nsName, "namespace", "", "Namespace to renew, optional, all namespaces are renewed if neither namespace or registry is set")
+		fs.StringVar(&registry, "registry", "", "Registry to renew, optional, the namespaces requesting the registry are renewed")
+	})
+	if err != nil {
+		return errors.Wrap(err, "getConfig failed")
+	}
+	if nsName != "" && registry != "" {
+		return errors.New("only one of namespace or registry can be used")
+	}
+
+	ctrl, err := newCommandController(config)
+	if err != nil {
+		return err
+	}
+
+	keys, err := ctrl.getRenewalKeys(nsName, registry)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.Errorf("no namespaces request registry [%s]", registry)
+	}
+
+	return ctrl.renewKeys(os.Stdout, keys)
+}
+
+// Status command
+func runStatusCommand(args []string) error {
+	config, err := getConfig(args, nil)
+	if err != nil {
+		return errors.Wrap(err, "getConfig failed")
+	}
+
+	ctrl, err := newCommandController(config)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.writeClusterStatus(os.Stdout, time.Now())
+}
+
+// Validate command, exits non zero if any check fails
+func runValidateCommand(args []string) error {
+	config, err := getConfig(args, nil)
+	if err != nil {
+		return errors.Wrap(err, "getConfig failed")
+	}
+
+	results := getConfigChecks(config)
+	if failed := writeCheckResults(os.Stdout, results); failed > 0 {
+		return errors.Errorf("%d config checks failed", failed)
+	}
+
+	ctrl, err := newCommandController(config)
+	if err != nil {
+		return err
+	}
+
+	results, err = ctrl.getCredentialChecks(false)
+	if err != nil {
+		return err
+	}
+	if failed := writeCheckResults(os.Stdout, results); failed > 0 {
+		return errors.Errorf("%d credentials checks failed", failed)
+	}
+
+	return nil
+}
+
+// Version command
+func runVersionCommand(args []string) error {
+	fmt.Fprintf(os.Stdout, "Version=%s Branch=%s RepoVersion=%s golang=%s\n", version, repoBranch, repoVersion, runtime.Version())
+	return nil
+}
+
+// Write the managed secrets and their expiries read from the cluster, followed by the published registry status if there is one
+func (c *controller) writeClusterStatus(w io.Writer, now time.Time) error {
+	nss, err := c.K8S.GetNamespaces()
+	if err != nil {
+		return errors.Wrap(err, "get namespaces failed")
+	}
+	sort.Slice(nss.Items, func(i, j int) bool { return nss.Items[i].Name < nss.Items[j].Name })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tSECRET\tTYPE\tEXPIRES AT\tEXPIRES IN")
+	for _, ns := range nss.Items {
+		secrets, err := c.K8S.GetSecrets(ns.Name)
+		if err != nil {
+			return errors.Wrapf(err, "get namespace [%s] secrets failed", ns.Name)
+		}
+		sort.Slice(secrets.Items, func(i, j int) bool { return secrets.Items[i].Name < secrets.Items[j].Name })
+
+		for _, sec := range secrets.Items {
+			if sec.Labels[managedByLabelKey] != managedByLabelValue {
+				continue
+			}
+			expiresAt, expiresIn := "-", "-"
+			if value, ok := sec.Annotations[expiresAtAnnotationKey]; ok {
+				expiresAt = value
+				if t, err := time.Parse(time.RFC3339, value); err == nil {
+					expiresIn = "expired"
+					if t.After(now) {
+						expiresIn = t.Sub(now).Round(time.Minute).String()
+					}
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", ns.Name, sec.Name, sec.Type, expiresAt, expiresIn)
+		}
+	}
+	tw.Flush()
+
+	if c.Config.StatusConfigMapName == "" {
+		return nil
+	}
+	cm, err := c.K8S.GetConfigMap(c.Config.HostNamespace, c.Config.StatusConfigMapName)
+	if err != nil {
+		if k8serr.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "get namespace [%s] status config map [%s] failed", c.Config.HostNamespace, c.Config.StatusConfigMapName)
+	}
+	status := controllerStatus{}
+	if err := json.Unmarshal([]byte(cm.Data[statusConfigMapDataKey]), &status); err != nil {
+		return errors.Wrapf(err, "unmarshal namespace [%s] status config map [%s] failed", c.Config.HostNamespace, c.Config.StatusConfigMapName)
+	}
+
+	fmt.Fprintf(w, "\nRegistry status updated at %s\n", status.UpdatedAt.Format(time.RFC3339))
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REGISTRY\tNAMESPACES\tLAST FETCH\tTOKEN EXPIRY\tERROR")
+	for _, r := range status.Registries {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", r.Registry, r.NamespacesServed, formatSummaryTime(r.LastSuccessfulFetch), formatSummaryTime(r.TokenExpiry), r.LastFetchError)
+	}
+	tw.Flush()
+
+	return nil
+}
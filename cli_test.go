@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCommand(t *testing.T) {
+	assert.Nil(t, runCommand([]string{"eatr", "version"}), "Version command error")
+	assert.NotNil(t, runCommand([]string{"eatr", "unknown"}), "Unknown command error")
+}
+
+func TestClusterStatus(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true"},
+			Secrets:  []string{"unmanaged"},
+		},
+	})
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+	err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+	assert.Nil(t, err, "Renewal error")
+
+	out := &bytes.Buffer{}
+	err = ctrl.writeClusterStatus(out, time.Now())
+	assert.Nil(t, err, "Cluster status error")
+	lines := strings.Split(out.String(), "\n")
+	assert.True(t, strings.HasPrefix(lines[0], "NAMESPACE"), "Secrets header")
+	assert.True(t, strings.HasPrefix(lines[1], ns1), "Managed secret namespace")
+	assert.Contains(t, lines[1], ecr1, "Managed secret name")
+	assert.Contains(t, lines[1], "12h0m0s", "Managed secret expires in")
+	assert.NotContains(t, out.String(), "unmanaged", "Unmanaged secret")
+	assert.Contains(t, out.String(), "Registry status updated at", "Registry status")
+
+	out.Reset()
+	err = ctrl.writeClusterStatus(out, time.Now().Add(13*time.Hour))
+	assert.Nil(t, err, "Cluster status error")
+	assert.Contains(t, out.String(), "expired", "Expired secret")
+}
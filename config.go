@@ -1,71 +1,264 @@
 package main
 
 import (
+	"bytes"
 	"flag"
+	"io"
+	"io/ioutil"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/yaml"
 )
 
 const (
+	configEnvVarPrefix       = "EATR_"
+	settingsFilePathFlagName = "settings-file-path"
+
 	defaultAuthenticationTokenRenewalInterval = 6 * time.Hour
 	defaultAWSCredentialsSecretPrefix         = "eatr-aws-credentials"
+	defaultConfigReloadInterval               = 30 * time.Second
 	defaultHostNamespace                      = "ci-cd"
 	defaultInformersResyncInterval            = 5 * time.Minute
 	defaultLoggingVerbosityLevel              = 0
 	defaultPort                               = 5000
+	defaultServiceAccountNames                = "default"
 	defaultShutdownGracePeriod                = 3 * time.Second
+	defaultStatusConfigMapName                = "eatr-status"
 )
 
 type config struct {
+	AdminAPI                           bool
 	AuthenticationTokenRenewalInterval time.Duration
 	AWSCredentialsSecretPrefix         string
+	ConfigReloadInterval               time.Duration
+	DeleteImagePullFailurePods         bool
+	DiagnosticAuth                     bool
+	DiagnosticTLSCertFilePath          string
+	DiagnosticTLSKeyFilePath           string
+	DiscoveryIncludeWorkloads          bool
+	DiscoveryMode                      bool
+	DiscoveryNamespaces                string
+	DryRun                             bool
 	HostNamespace                      string
+	ImagePullCredentials               bool
 	InformersResyncInterval            time.Duration
 	KubeConfigFilePath                 string
 	LoggingVerbosityLevel              int
+	MergedSecretName                   string
+	Namespaces                         string
+	PatchServiceAccounts               bool
+	PolicyFilePath                     string
 	Port                               int
+	ReactToImagePullFailures           bool
+	RenewalStalenessWindow             time.Duration
+	RenewOnce                          bool
+	ServiceAccountNames                string
+	SettingsFilePath                   string
 	ShutdownGracePeriod                time.Duration
+	StatusConfigMapName                string
+	WebhookPort                        int
+	WebhookTLSCertFilePath             string
+	WebhookTLSKeyFilePath              string
 }
 
-func getConfig(args []string) (config, error) {
+// Get config from the shared flags, commands can add their own flags with addFlags, which can be nil
+func getConfig(args []string, addFlags func(fs *flag.FlagSet)) (config, error) {
+	config, err := parseConfig(args, addFlags, os.LookupEnv)
+	if err != nil {
+		return config, err
+	}
+	configureGLog(config.LoggingVerbosityLevel)
+
+	return config, nil
+}
+
+// Parse the config, precedence is the defaults, then the settings file, then the EATR_* environment variables and finally the command line flags
+// Environment variables are looked up with lookupEnv so tests do not need to change the process environment
+func parseConfig(args []string, addFlags func(fs *flag.FlagSet), lookupEnv func(key string) (string, bool)) (config, error) {
 	config := getDefaultConfig()
 
 	// Using an explicit flagset so we do not mix the glog flags via the client-go package
 	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+	addConfigFlags(fs, &config)
+	configFlagNames := sets.NewString()
+	fs.VisitAll(func(f *flag.Flag) { configFlagNames.Insert(f.Name) })
+	if addFlags != nil {
+		addFlags(fs)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return config, err
+	}
+
+	// Command line flags are re-applied last, so need to be captured before the other sources are applied
+	flagSettings := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		if configFlagNames.Has(f.Name) {
+			flagSettings[f.Name] = f.Value.String()
+		}
+	})
+	envSettings := getEnvConfigSettings(configFlagNames, lookupEnv)
+
+	settingsFilePath := envSettings[settingsFilePathFlagName]
+	if value, ok := flagSettings[settingsFilePathFlagName]; ok {
+		settingsFilePath = value
+	}
+	fileSettings := map[string]string{}
+	if settingsFilePath != "" {
+		data, err := ioutil.ReadFile(settingsFilePath)
+		if err != nil {
+			return config, errors.Wrapf(err, "read settings file [%s] failed", settingsFilePath)
+		}
+		if fileSettings, err = parseConfigSettings(data, configFlagNames); err != nil {
+			return config, errors.Wrapf(err, "settings file [%s] is invalid", settingsFilePath)
+		}
+	}
+
+	config = getDefaultConfig()
+	errs := []error{}
+	errs = append(errs, applyConfigSettings(fs, fileSettings, func(name string) string { return "settings file key [" + name + "]" })...)
+	errs = append(errs, applyConfigSettings(fs, envSettings, func(name string) string { return "environment variable [" + getConfigEnvVarName(name) + "]" })...)
+	errs = append(errs, applyConfigSettings(fs, flagSettings, func(name string) string { return "flag [" + name + "]" })...)
+
+	return config, utilerrors.NewAggregate(errs)
+}
+
+// Limited glog config, glog registers its flags with the global flag set and complains if it has not been parsed
+// Parsing an empty argument list marks it as parsed without defining our flags on the global flag set or seeing the process args
+func configureGLog(loggingVerbosityLevel int) {
+	flag.Set("logtostderr", "true")
+	flag.Set("v", strconv.Itoa(loggingVerbosityLevel))
+	flag.CommandLine.Parse([]string{})
+}
+
+// Get the config settings from the EATR_* environment variables, keyed by flag name, i.e. EATR_HOST_NAMESPACE for host-namespace
+func getEnvConfigSettings(configFlagNames sets.String, lookupEnv func(key string) (string, bool)) map[string]string {
+	res := map[string]string{}
+	for _, name := range configFlagNames.List() {
+		if value, ok := lookupEnv(getConfigEnvVarName(name)); ok {
+			res[name] = value
+		}
+	}
+
+	return res
+}
+
+func getConfigEnvVarName(flagName string) string {
+	return configEnvVarPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// Parse YAML or JSON config settings, keys are the flag names and values are scalars, unknown keys are rejected so typos are not silently ignored
+func parseConfigSettings(data []byte, configFlagNames sets.String) (map[string]string, error) {
+	raw := map[string]interface{}{}
+	if err := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096).Decode(&raw); err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "decode failed")
+	}
+
+	res := map[string]string{}
+	errs := []error{}
+	for key, value := range raw {
+		if !configFlagNames.Has(key) || key == settingsFilePathFlagName {
+			errs = append(errs, errors.Errorf("key [%s] is not a known setting", key))
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			res[key] = v
+		case bool:
+			res[key] = strconv.FormatBool(v)
+		case float64:
+			res[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			errs = append(errs, errors.Errorf("key [%s] must be a string, number or boolean", key))
+		}
+	}
+
+	return res, utilerrors.NewAggregate(errs)
+}
+
+// Apply the settings to the flags, describe names the setting's source in errors, returns all the failures rather than just the first
+func applyConfigSettings(fs *flag.FlagSet, settings map[string]string, describe func(name string) string) []error {
+	names := []string{}
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := []error{}
+	for _, name := range names {
+		if err := fs.Set(name, settings[name]); err != nil {
+			errs = append(errs, errors.Wrapf(err, "%s value [%s] is invalid", describe(name), settings[name]))
+		}
+	}
+
+	return errs
+}
+
+// Add the config flags, shared by getConfig and the manifests command, which renders the flags that differ from the defaults
+func addConfigFlags(fs *flag.FlagSet, config *config) {
+	fs.BoolVar(&config.AdminAPI, "admin-api", config.AdminAPI, "Admin API - If set the diagnostics port also surfaces GET /status, POST /renew and GET /queue, so a renewal can be forced without restarting or relabelling")
 	fs.DurationVar(&config.AuthenticationTokenRenewalInterval, "auth-token-renewal-interval", config.AuthenticationTokenRenewalInterval, "Authentication token renewal interval - ECR tokens expire after 12 hours so should be less")
-	fs.StringVar(&config.AWSCredentialsSecretPrefix, "aws-credentials-secret-prefix", config.AWSCredentialsSecretPrefix, "AWS credentials secret prefix - Prefix for host namespace AWS credentials secret names, these secrets will be used to store the AWS credentials used to connect to create ECR auth tokens needed for image pulling, will take the form [Prefix]-[ECRDNS]")
+	fs.StringVar(&config.AWSCredentialsSecretPrefix, "aws-credentials-secret-prefix", config.AWSCredentialsSecretPrefix, "AWS credentials secret prefix - Prefix for host namespace AWS credentials secret names, these secrets will be used to store the AWS credentials used to connect to create ECR auth tokens needed for image pulling, will take the form [Prefix]-[ECRDNS], or [Prefix]-[CredentialSet]-[ECRDNS] for namespaces labelled with eatr.io/credential-set")
+	fs.DurationVar(&config.ConfigReloadInterval, "config-reload-interval", config.ConfigReloadInterval, "Config reload interval - How often the settings and policy files are checked for changes, which are applied without a restart, invalid changes and changes to settings that need a restart are rejected and the last good config kept, reloading is disabled if set to 0")
+	fs.BoolVar(&config.DeleteImagePullFailurePods, "delete-image-pull-failure-pods", config.DeleteImagePullFailurePods, "Delete image pull failure pods - If set pods failing to pull ECR images are deleted after the namespace secrets are renewed, so their controller recreates them, only pods with an owner are deleted, needs react-to-image-pull-failures")
+	fs.BoolVar(&config.DiagnosticAuth, "diagnostic-auth", config.DiagnosticAuth, "Diagnostic auth - If set the pprof and admin API routes require a bearer token, authenticated with a TokenReview and authorised with a SubjectAccessReview for the request path and verb, metrics and health checks remain open")
+	fs.StringVar(&config.DiagnosticTLSCertFilePath, "diagnostic-tls-cert-file-path", config.DiagnosticTLSCertFilePath, "Diagnostic HTTP server TLS cert file path, optional, the diagnostic server is only served over TLS if set, needs the TLS key file path, the cert and key are reloaded when the files change")
+	fs.StringVar(&config.DiagnosticTLSKeyFilePath, "diagnostic-tls-key-file-path", config.DiagnosticTLSKeyFilePath, "Diagnostic HTTP server TLS key file path")
+	fs.BoolVar(&config.DiscoveryIncludeWorkloads, "discovery-include-workloads", config.DiscoveryIncludeWorkloads, "Discovery include workloads - If set discovery mode also examines deployments, stateful sets and cron jobs, not just pods")
+	fs.BoolVar(&config.DiscoveryMode, "discovery-mode", config.DiscoveryMode, "Discovery mode - If set the ECR registries a namespace needs are also inferred from pod image references, in addition to the namespace labels")
+	fs.StringVar(&config.DiscoveryNamespaces, "discovery-namespaces", config.DiscoveryNamespaces, "Discovery namespaces - Comma separated allowlist of namespaces eligible for discovery, can use patterns i.e. team-*, all namespaces are eligible if not set")
+	fs.BoolVar(&config.DryRun, "dry-run", config.DryRun, "Dry run - If set the full renewal logic runs but secrets, service accounts and pods are not created, updated or deleted, the planned changes are logged, printed by the renew command and included in the admin API status, events and the status config map are not written")
 	fs.StringVar(&config.HostNamespace, "host-namespace", config.HostNamespace, "Host namespace")
+	fs.BoolVar(&config.ImagePullCredentials, "image-pull-credentials", config.ImagePullCredentials, "Image pull credentials - If set ImagePullCredential resources are used to configure registries, in addition to the namespace labels, needs the k8s/imagepullcredential-crd.yaml custom resource definition")
 	fs.DurationVar(&config.InformersResyncInterval, "informers-resync-interval", config.InformersResyncInterval, "Shared informers resync interval")
 	fs.StringVar(&config.KubeConfigFilePath, "config-file-path", config.KubeConfigFilePath, "Kube config file path, optional, only used for testing outside the cluster, can also set the KUBECONFIG env var")
 	fs.IntVar(&config.LoggingVerbosityLevel, "logging-verbosity-level", config.LoggingVerbosityLevel, "Logging verbosity level, can set to 6 or higher to get debug level logs, will also see client-go logs")
+	fs.StringVar(&config.MergedSecretName, "merged-secret-name", config.MergedSecretName, "Merged secret name - If set a single docker config json secret with this name is created in each namespace containing all the registries the namespace is labelled for, rather than a secret per registry")
+	fs.StringVar(&config.Namespaces, "namespaces", config.Namespaces, "Namespaces - Comma separated namespaces the controller manages image pull secrets in, other namespaces are ignored, the host namespace is always included, all namespaces if not set, the manifests command grants the namespaced permissions with a role per namespace if set")
+	fs.BoolVar(&config.PatchServiceAccounts, "patch-service-accounts", config.PatchServiceAccounts, "Patch service accounts - If set the managed secret names are added to the namespace service accounts imagePullSecrets")
+	fs.StringVar(&config.PolicyFilePath, "policy-file-path", config.PolicyFilePath, "Policy file path - YAML or JSON file, which can be a mounted config map, with rules restricting which namespaces may request which registries, all requests are allowed if not set")
 	fs.IntVar(&config.Port, "port", config.Port, "Port to surface diagnostics on")
+	fs.BoolVar(&config.ReactToImagePullFailures, "react-to-image-pull-failures", config.ReactToImagePullFailures, "React to image pull failures - If set pods failing to pull ECR images trigger an immediate renewal for the namespace")
+	fs.BoolVar(&config.RenewOnce, "renew-once", config.RenewOnce, "Renew once - If set a single all namespaces renewal is made, a summary is printed and the process exits, non zero if any registry or namespace failed, no informers or diagnostic HTTP server are started, for running as a cron job")
+	fs.DurationVar(&config.RenewalStalenessWindow, "renewal-staleness-window", config.RenewalStalenessWindow, "Renewal staleness window - Readiness fails if the last all namespaces renewal is older than this or a registry has been failing for longer than this, liveness fails if a single renewal takes longer than this, defaults to twice the auth token renewal interval if not set")
+	fs.StringVar(&config.ServiceAccountNames, "service-account-names", config.ServiceAccountNames, "Service account names - Comma separated names of the service accounts to patch, can be overridden per namespace with the eatr.io/service-accounts annotation")
+	fs.StringVar(&config.SettingsFilePath, settingsFilePathFlagName, config.SettingsFilePath, "Settings file path - YAML or JSON file, which can be a mounted config map, with the flag names as keys, i.e. host-namespace: ci-cd, environment variables (EATR_ and the upper case flag name with underscores, i.e. EATR_HOST_NAMESPACE) override the file and flags override both")
 	fs.DurationVar(&config.ShutdownGracePeriod, "shutdown-grace-period", config.ShutdownGracePeriod, "Shutdown grace period")
-	if err := fs.Parse(args[1:]); err != nil {
-		return config, err
-	}
-
-	// Limited glog config
-	// See https://stackoverflow.com/questions/28207226/how-do-i-set-the-log-directory-of-glog-from-cod://stackoverflow.com/questions/28207226/how-do-i-set-the-log-directory-of-glog-from-code
-	// Simulate global flags so we can configure some of the glog flags
-	// Need to add global flags as the default is to exit on error - i.e. Unknown flags which is how our flags above will be seen
-	fs.VisitAll(func(f *flag.Flag) { _ = flag.String((*f).Name, "", "") })
-	flag.Lookup("logtostderr").Value.Set("true")
-	flag.Lookup("v").Value.Set(strconv.Itoa(config.LoggingVerbosityLevel))
-	flag.Parse()
-
-	return config, nil
+	fs.StringVar(&config.StatusConfigMapName, "status-config-map-name", config.StatusConfigMapName, "Status config map name - Name of the host namespace config map the per registry renewal status is published to, status is not published if set to empty")
+	fs.IntVar(&config.WebhookPort, "webhook-port", config.WebhookPort, "Port to surface the mutating admission webhook on, optional, webhook is only enabled if set, needs the TLS cert and key file paths")
+	fs.StringVar(&config.WebhookTLSCertFilePath, "webhook-tls-cert-file-path", config.WebhookTLSCertFilePath, "Mutating admission webhook TLS cert file path")
+	fs.StringVar(&config.WebhookTLSKeyFilePath, "webhook-tls-key-file-path", config.WebhookTLSKeyFilePath, "Mutating admission webhook TLS key file path")
 }
 
 func getDefaultConfig() config {
 	return config{
 		AuthenticationTokenRenewalInterval: defaultAuthenticationTokenRenewalInterval,
 		AWSCredentialsSecretPrefix:         defaultAWSCredentialsSecretPrefix,
+		ConfigReloadInterval:               defaultConfigReloadInterval,
 		HostNamespace:                      defaultHostNamespace,
 		InformersResyncInterval:            defaultInformersResyncInterval,
 		KubeConfigFilePath:                 os.Getenv("KUBECONFIG"),
 		LoggingVerbosityLevel:              defaultLoggingVerbosityLevel,
-		Port:                defaultPort,
-		ShutdownGracePeriod: defaultShutdownGracePeriod,
+		Port:                               defaultPort,
+		ServiceAccountNames:                defaultServiceAccountNames,
+		ShutdownGracePeriod:                defaultShutdownGracePeriod,
+		StatusConfigMapName:                defaultStatusConfigMapName,
+	}
+}
+
+// Get the namespaces the controller is scoped to in name order, including the host namespace, empty if not scoped
+func getScopedNamespaces(config config) []string {
+	names := splitNames(config.Namespaces)
+	if len(names) == 0 {
+		return names
 	}
+
+	return sets.NewString(names...).Insert(config.HostNamespace).List()
 }
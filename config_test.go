@@ -1,37 +1,130 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-// PENDING: This test is disabled for now, if i enable will set a global glog value that results in the log messages being written to stdout
-// Test was only for error condition tests
-func TestnewConfig(t *testing.T) {
+func writeTestSettingsFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "eatr-settings")
+	assert.Nil(t, err, "Create settings file error")
+	defer file.Close()
+	_, err = file.WriteString(content)
+	assert.Nil(t, err, "Write settings file error")
+
+	return file.Name()
+}
+
+func TestParseConfig(t *testing.T) {
+	yamlSettingsFilePath := writeTestSettingsFile(t, "auth-token-renewal-interval: 2h\nhost-namespace: from-file\npatch-service-accounts: true\nport: 6000\n")
+	jsonSettingsFilePath := writeTestSettingsFile(t, `{ "host-namespace": "from-json-file", "webhook-port": 8443 }`)
+	invalidSettingsFilePath := writeTestSettingsFile(t, "hostnamespace: typo\nport: [1, 2]\n")
+	defer os.Remove(yamlSettingsFilePath)
+	defer os.Remove(jsonSettingsFilePath)
+	defer os.Remove(invalidSettingsFilePath)
+
 	for _, tc := range []struct {
-		Name        string
-		Args        []string
-		ExpectError bool
+		Name           string               // Test case name
+		Args           []string             // Command line args, excluding the program name
+		Env            map[string]string    // Environment variables
+		ExpectedErrors []string             // Expected error message fragments
+		Mutate         func(config *config) // Change to make to the default config to get the expected config
 	}{
 		{
-			Name: "Case1",
+			Name:   "Defaults",
+			Mutate: func(config *config) {},
+		},
+		{
+			Name: "Flags",
 			Args: []string{
 				"-auth-token-renewal-interval", "2s",
 				"-aws-credentials-secret-prefix", "aprefix-",
 				"-host-namespace", "abc",
 				"-informers-resync-interval", "10m",
 				"-config-file-path", "/here.config",
-				"-logging-verbosity-level", "0",
+				"-logging-verbosity-level", "3",
 				"-port", "1200",
-				"-shutdown-grace-period", "1H"},
-			ExpectError: false,
+				"-shutdown-grace-period", "1h"},
+			Mutate: func(config *config) {
+				config.AuthenticationTokenRenewalInterval = 2 * time.Second
+				config.AWSCredentialsSecretPrefix = "aprefix-"
+				config.HostNamespace = "abc"
+				config.InformersResyncInterval = 10 * time.Minute
+				config.KubeConfigFilePath = "/here.config"
+				config.LoggingVerbosityLevel = 3
+				config.Port = 1200
+				config.ShutdownGracePeriod = time.Hour
+			},
+		},
+		{
+			Name: "YAML settings file",
+			Args: []string{"-settings-file-path", yamlSettingsFilePath},
+			Mutate: func(config *config) {
+				config.AuthenticationTokenRenewalInterval = 2 * time.Hour
+				config.HostNamespace = "from-file"
+				config.PatchServiceAccounts = true
+				config.Port = 6000
+				config.SettingsFilePath = yamlSettingsFilePath
+			},
+		},
+		{
+			Name: "JSON settings file from an environment variable",
+			Env:  map[string]string{"EATR_SETTINGS_FILE_PATH": jsonSettingsFilePath},
+			Mutate: func(config *config) {
+				config.HostNamespace = "from-json-file"
+				config.SettingsFilePath = jsonSettingsFilePath
+				config.WebhookPort = 8443
+			},
+		},
+		{
+			Name: "Environment variables override the settings file and flags override both",
+			Args: []string{"-settings-file-path", yamlSettingsFilePath, "-port", "7000"},
+			Env:  map[string]string{"EATR_HOST_NAMESPACE": "from-env", "EATR_PORT": "6500", "EATR_PATCH_SERVICE_ACCOUNTS": "false"},
+			Mutate: func(config *config) {
+				config.AuthenticationTokenRenewalInterval = 2 * time.Hour
+				config.HostNamespace = "from-env"
+				config.Port = 7000
+				config.SettingsFilePath = yamlSettingsFilePath
+			},
+		},
+		{
+			Name:           "Invalid environment variables",
+			Env:            map[string]string{"EATR_PORT": "abc", "EATR_SHUTDOWN_GRACE_PERIOD": "1H"},
+			ExpectedErrors: []string{"environment variable [EATR_PORT] value [abc] is invalid", "environment variable [EATR_SHUTDOWN_GRACE_PERIOD] value [1H] is invalid"},
+		},
+		{
+			Name:           "Invalid settings file",
+			Args:           []string{"-settings-file-path", invalidSettingsFilePath},
+			ExpectedErrors: []string{"key [hostnamespace] is not a known setting", "key [port] must be a string, number or boolean"},
+		},
+		{
+			Name:           "Missing settings file",
+			Args:           []string{"-settings-file-path", "/does/not/exist.yaml"},
+			ExpectedErrors: []string{"read settings file [/does/not/exist.yaml] failed"},
 		},
 	} {
 		t.Run(tc.Name, func(t *testing.T) {
-			_, err := getConfig(tc.Args)
+			lookupEnv := func(key string) (string, bool) {
+				value, ok := tc.Env[key]
+				return value, ok
+			}
+			config, err := parseConfig(append([]string{"eatr"}, tc.Args...), nil, lookupEnv)
 
-			assert.Equal(t, tc.ExpectError, err != nil, "Erorr")
+			if len(tc.ExpectedErrors) > 0 {
+				assert.NotNil(t, err, "Error")
+				for _, expected := range tc.ExpectedErrors {
+					assert.Contains(t, err.Error(), expected, "Error message")
+				}
+				return
+			}
+			assert.Nil(t, err, "Error")
+			expected := getDefaultConfig()
+			tc.Mutate(&expected)
+			assert.Equal(t, expected, config, "Config")
 		})
 	}
 }
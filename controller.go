@@ -1,19 +1,28 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"regexp"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	k8serr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
@@ -23,8 +32,11 @@ const (
 	allNamespacesKey               = "**all-ns**" // Is not a valid namespace name so cannot clash with an existing namespace
 	awsECRDNSPattern               = `(?P<AccountId>\d{12})\.dkr\.ecr\.(?P<Region>\w{2}-\w+-\d)\.amazonaws\.com`
 	detailiedGLogLevel             = 6
+	expiresAtAnnotationKey         = "eatr.io/expires-at"           // Annotation we apply to secrets we create with an ECR token, the earliest token expiry, used by the status command
+	managedByLabelKey              = "app.kubernetes.io/managed-by" // Label we apply to all secrets we create, used to identify secrets we own and so can remove
+	managedByLabelValue            = "eatr"
 	namespaceSecretLabelKeyPattern = `^` + awsECRDNSPattern + `$`
-	secretDataTemplate             = `{ "auths": { "%s": { "auth": "%s" } } }` // Docker config json file format, see ~/.docker/config.json
+	replicateAnnotationKey         = "eatr.io/replicate" // Host namespace dockerconfigjson secrets with this annotation set to true are replicated verbatim
 	queueName                      = "eatr"
 )
 
@@ -37,64 +49,314 @@ type ecrInterface interface {
 }
 
 type k8sInterface interface {
+	CreateConfigMap(string, *corev1.ConfigMap) (*corev1.ConfigMap, error)
+	CreateEvent(string, *corev1.Event) (*corev1.Event, error)
 	CreateSecret(string, *corev1.Secret) (*corev1.Secret, error)
+	CreateSelfSubjectAccessReview(*authorizationv1.SelfSubjectAccessReview) (*authorizationv1.SelfSubjectAccessReview, error)
+	CreateSubjectAccessReview(*authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error)
+	CreateTokenReview(*authenticationv1.TokenReview) (*authenticationv1.TokenReview, error)
+	DeleteConfigMap(string, string) error
+	DeletePod(string, string) error
+	DeleteSecret(string, string) error
+	GetConfigMap(string, string) (*corev1.ConfigMap, error)
+	GetCronJobs(string) (*batchv1beta1.CronJobList, error)
+	GetDeployments(string) (*appsv1.DeploymentList, error)
+	GetImagePullCredentials() (*imagePullCredentialList, error)
 	GetNamespace(string) (*corev1.Namespace, error)
 	GetNamespaces() (*corev1.NamespaceList, error)
+	GetPods(string) (*corev1.PodList, error)
 	GetSecret(string, string) (*corev1.Secret, error)
 	GetSecrets(string) (*corev1.SecretList, error)
+	GetServiceAccounts(string) (*corev1.ServiceAccountList, error)
+	GetStatefulSets(string) (*appsv1.StatefulSetList, error)
+	PatchEvent(string, string, []byte) (*corev1.Event, error)
+	UpdateConfigMap(string, *corev1.ConfigMap) (*corev1.ConfigMap, error)
+	UpdateEvent(string, *corev1.Event) (*corev1.Event, error)
+	UpdateNamespace(*corev1.Namespace) (*corev1.Namespace, error)
 	UpdateSecret(string, *corev1.Secret) (*corev1.Secret, error)
+	UpdateServiceAccount(string, *corev1.ServiceAccount) (*corev1.ServiceAccount, error)
+}
+
+// Informers the controller reacts to, host secret informer should be restricted to the host namespace
+// Service account informer is optional, only needed if patching service accounts
+// Pod informer is optional, only needed for discovery mode or reacting to image pull failures, workload informers are only needed for discovery mode
+// Image pull credential informer is optional, only needed if image pull credentials are enabled
+// All informers are nil when renewing once, as nothing is reacted to
+type controllerInformers struct {
+	Namespace           cache.SharedInformer
+	HostSecret          cache.SharedInformer
+	ServiceAccount      cache.SharedInformer
+	Pod                 cache.SharedInformer
+	Workloads           []cache.SharedInformer
+	ImagePullCredential cache.SharedInformer
 }
 
 type controller struct {
-	Config                config
-	K8S                   k8sInterface
-	NamespaceListerSynced cache.InformerSynced
-	Queue                 workqueue.RateLimitingInterface
-	ECR                   ecrInterface
-	SecretsCounter        *prometheus.CounterVec
-	SecretRenewalsCounter prometheus.Counter
+	Config                             config
+	ConfigMutex                        sync.RWMutex // Write locked when a reload is applied by the queue consumer, so go routines other than the consumer need the read lock to use the config or policy
+	ConfigGeneration                   int
+	ConfigGenerationGauge              prometheus.Gauge
+	ConfigReloadsCounter               *prometheus.CounterVec
+	LoadConfig                         func() (config, error) // Only set when running the controller, used to reload the config
+	K8S                                k8sInterface
+	DryRun                             *recordingK8SClient // Only set in dry run mode, in which case it is also the K8S client
+	DiscoveryWarnings                  *discoveryWarnings
+	Informers                          controllerInformers // Stores are read in preference to listing via the API, not set when renewing once
+	InformersSynced                    []cache.InformerSynced
+	Queue                              *trackingQueue
+	ECR                                ecrInterface
+	ECRErrorsCounter                   *prometheus.CounterVec
+	ECRRequestDurationHistogram        *prometheus.HistogramVec
+	Health                             *controllerHealth
+	Recorder                           *eventRecorder
+	ImagePullFailures                  *imagePullFailures
+	ImagePullFailuresDetectedCounter   *prometheus.CounterVec
+	ImagePullFailuresRemediatedCounter *prometheus.CounterVec
+	Policy                             *policy
+	PolicyDenialsCounter               *prometheus.CounterVec
+	RegistryLastRenewalGauge           *prometheus.GaugeVec
+	RenewalDurationHistogram           prometheus.Histogram
+	RenewalScheduleReset               chan struct{}
+	SecretsCounter                     *prometheus.CounterVec
+	SecretsDeletedCounter              *prometheus.CounterVec
+	SecretRenewalsCounter              prometheus.Counter
+	SecretTokenExpiryGauge             *prometheus.GaugeVec
+	ServiceAccountsPatchedCounter      *prometheus.CounterVec
+	Status                             *renewalStatus
 }
 
-func newController(config config, k8sClient k8sInterface, informer cache.SharedInformer, prometheusRegistry *prometheus.Registry, ecrClient ecrInterface) (*controller, error) {
+func newController(config config, k8sClient k8sInterface, informers controllerInformers, prometheusRegistry *prometheus.Registry, ecrClient ecrInterface) (*controller, error) {
 	secretsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "secrets_created_total",
 		Help: "Number of secrets that have been created\\updated.",
 	}, []string{"namespace", "name"})
+	secretsDeletedCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "secrets_deleted_total",
+		Help: "Number of secrets that have been deleted.",
+	}, []string{"namespace", "name"})
 	secretRenewalsCounter := prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "secret_renewals_total",
 		Help: "Number of secret renewals made.",
 	})
+	serviceAccountsPatchedCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "service_accounts_patched_total",
+		Help: "Number of service account imagePullSecrets patches made.",
+	}, []string{"namespace", "name"})
+	imagePullFailuresDetectedCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_pull_failures_detected_total",
+		Help: "Number of pod ECR image pull failures detected.",
+	}, []string{"namespace", "registry"})
+	imagePullFailuresRemediatedCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_pull_failures_remediated_total",
+		Help: "Number of pods with ECR image pull failures deleted after the namespace secrets were renewed.",
+	}, []string{"namespace"})
+	policyDenialsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "policy_denials_total",
+		Help: "Number of namespace registry requests denied by the policy.",
+	}, []string{"namespace", "registry"})
+	secretTokenExpiryGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "secret_token_expiry_timestamp_seconds",
+		Help: "Expiry time of the ECR authorization token in a managed secret, in seconds since the epoch.",
+	}, []string{"namespace", "name"})
+	registryLastRenewalGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "registry_last_successful_renewal_timestamp_seconds",
+		Help: "Time of the last successful ECR authorization token renewal for a registry, in seconds since the epoch.",
+	}, []string{"registry"})
+	ecrErrorsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecr_errors_total",
+		Help: "Number of failures to get an ECR authorization token.",
+	}, []string{"registry", "reason"})
+	ecrRequestDurationHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "ecr_request_duration_seconds",
+		Help: "ECR authorization token request latency.",
+	}, []string{"registry"})
+	renewalDurationHistogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "renewal_duration_seconds",
+		Help: "Renewal latency, for all namespaces, a single namespace or an image pull credential's namespaces.",
+	})
+	configGenerationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "config_generation",
+		Help: "Generation of the applied config, starts at 1 and is incremented by each config reload that changes the config.",
+	})
+	configReloadsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_reloads_total",
+		Help: "Number of config reloads that changed the config or were rejected.",
+	}, []string{"result"})
 	prometheusRegistry.MustRegister(secretsCounter)
+	prometheusRegistry.MustRegister(secretsDeletedCounter)
 	prometheusRegistry.MustRegister(secretRenewalsCounter)
+	prometheusRegistry.MustRegister(serviceAccountsPatchedCounter)
+	prometheusRegistry.MustRegister(imagePullFailuresDetectedCounter)
+	prometheusRegistry.MustRegister(imagePullFailuresRemediatedCounter)
+	prometheusRegistry.MustRegister(policyDenialsCounter)
+	prometheusRegistry.MustRegister(secretTokenExpiryGauge)
+	prometheusRegistry.MustRegister(registryLastRenewalGauge)
+	prometheusRegistry.MustRegister(ecrErrorsCounter)
+	prometheusRegistry.MustRegister(ecrRequestDurationHistogram)
+	prometheusRegistry.MustRegister(renewalDurationHistogram)
+	prometheusRegistry.MustRegister(configGenerationGauge)
+	prometheusRegistry.MustRegister(configReloadsCounter)
+	configGenerationGauge.Set(1)
+
+	// All writes, including events, go via the recording client in dry run mode
+	var dryRun *recordingK8SClient
+	if config.DryRun {
+		dryRun = newRecordingK8SClient(k8sClient)
+		k8sClient = dryRun
+	}
+
+	registryPolicy, err := loadPolicy(config.PolicyFilePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "load policy failed")
+	}
+
+	informersSynced := []cache.InformerSynced{}
+	if informers.Namespace != nil {
+		informersSynced = append(informersSynced, informers.Namespace.HasSynced)
+	}
+	if informers.HostSecret != nil {
+		informersSynced = append(informersSynced, informers.HostSecret.HasSynced)
+	}
+	if informers.ServiceAccount != nil {
+		informersSynced = append(informersSynced, informers.ServiceAccount.HasSynced)
+	}
+	if informers.Pod != nil {
+		informersSynced = append(informersSynced, informers.Pod.HasSynced)
+	}
+	for _, informer := range informers.Workloads {
+		informersSynced = append(informersSynced, informer.HasSynced)
+	}
+	if informers.ImagePullCredential != nil {
+		informersSynced = append(informersSynced, informers.ImagePullCredential.HasSynced)
+	}
 
 	ctrl := &controller{
-		Config: config,
-		K8S:    k8sClient,
-		NamespaceListerSynced: informer.HasSynced,
-		Queue:                 workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), queueName),
-		ECR:                   ecrClient,
-		SecretsCounter:        secretsCounter,
-		SecretRenewalsCounter: secretRenewalsCounter,
-	}
-
-	informer.AddEventHandler(
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				nsName := (obj.(*corev1.Namespace)).Name
-				glog.V(detailiedGLogLevel).Infof("Added ns [%s]\n", nsName)
-				ctrl.Queue.Add(nsName)
+		Config:                             config,
+		ConfigGeneration:                   1,
+		ConfigGenerationGauge:              configGenerationGauge,
+		ConfigReloadsCounter:               configReloadsCounter,
+		K8S:                                k8sClient,
+		DryRun:                             dryRun,
+		DiscoveryWarnings:                  newDiscoveryWarnings(),
+		Informers:                          informers,
+		InformersSynced:                    informersSynced,
+		Queue:                              newTrackingQueue(workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), queueName)),
+		ECR:                                ecrClient,
+		ECRErrorsCounter:                   ecrErrorsCounter,
+		ECRRequestDurationHistogram:        ecrRequestDurationHistogram,
+		Health:                             newControllerHealth(),
+		Recorder:                           newEventRecorder(k8sClient),
+		ImagePullFailures:                  newImagePullFailures(),
+		ImagePullFailuresDetectedCounter:   imagePullFailuresDetectedCounter,
+		ImagePullFailuresRemediatedCounter: imagePullFailuresRemediatedCounter,
+		Policy:                             registryPolicy,
+		PolicyDenialsCounter:               policyDenialsCounter,
+		RegistryLastRenewalGauge:           registryLastRenewalGauge,
+		RenewalDurationHistogram:           renewalDurationHistogram,
+		RenewalScheduleReset:               make(chan struct{}, 1),
+		SecretsCounter:                     secretsCounter,
+		SecretsDeletedCounter:              secretsDeletedCounter,
+		SecretRenewalsCounter:              secretRenewalsCounter,
+		SecretTokenExpiryGauge:             secretTokenExpiryGauge,
+		ServiceAccountsPatchedCounter:      serviceAccountsPatchedCounter,
+		Status:                             newRenewalStatus(),
+	}
+
+	// Namespaces outside the namespaces the controller is scoped to are ignored, changing the scope needs a restart
+	if informers.Namespace != nil {
+		informers.Namespace.AddEventHandler(
+			cache.FilteringResourceEventHandler{
+				FilterFunc: func(obj interface{}) bool {
+					ns, ok := obj.(*corev1.Namespace)
+					return ok && isNamespaceInScope(config, ns.Name)
+				},
+				Handler: cache.ResourceEventHandlerFuncs{
+					AddFunc: func(obj interface{}) {
+						ns := obj.(*corev1.Namespace)
+						glog.V(detailiedGLogLevel).Infof("Added ns [%s]\n", ns.Name)
+						ctrl.warnRegistryLabelProblems(nil, *ns)
+						ctrl.Queue.Add(ns.Name)
+					},
+					UpdateFunc: func(oldObj, newObj interface{}) {
+						oldNS := oldObj.(*corev1.Namespace)
+						newNS := newObj.(*corev1.Namespace)
+						if oldNS.ResourceVersion != newNS.ResourceVersion {
+							nsName := newNS.Name
+							glog.V(detailiedGLogLevel).Infof("Updated ns [%s]\n", nsName)
+							ctrl.warnRegistryLabelProblems(oldNS, *newNS)
+							ctrl.Queue.Add(nsName)
+						}
+					},
+				},
 			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldNS := oldObj.(*corev1.Namespace)
-				newNS := newObj.(*corev1.Namespace)
-				if oldNS.ResourceVersion != newNS.ResourceVersion {
-					nsName := newNS.Name
-					glog.V(detailiedGLogLevel).Infof("Updated ns [%s]\n", nsName)
-					ctrl.Queue.Add(nsName)
-				}
+		)
+	}
+
+	// Any change to a replicated host namespace secret needs to be applied to all namespaces, replicas are removed if the source secret is deleted
+	if informers.HostSecret != nil {
+		informers.HostSecret.AddEventHandler(
+			cache.FilteringResourceEventHandler{
+				FilterFunc: func(obj interface{}) bool {
+					if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+						obj = tombstone.Obj
+					}
+					sec, ok := obj.(*corev1.Secret)
+					return ok && sec.Namespace == config.HostNamespace && isReplicatedSecret(sec)
+				},
+				Handler: cache.ResourceEventHandlerFuncs{
+					AddFunc: func(obj interface{}) {
+						glog.V(detailiedGLogLevel).Infof("Added replicated secret [%s]\n", (obj.(*corev1.Secret)).Name)
+						ctrl.Queue.Add(allNamespacesKey)
+					},
+					UpdateFunc: func(oldObj, newObj interface{}) {
+						oldSec := oldObj.(*corev1.Secret)
+						newSec := newObj.(*corev1.Secret)
+						if oldSec.ResourceVersion != newSec.ResourceVersion {
+							glog.V(detailiedGLogLevel).Infof("Updated replicated secret [%s]\n", newSec.Name)
+							ctrl.Queue.Add(allNamespacesKey)
+						}
+					},
+					DeleteFunc: func(obj interface{}) {
+						glog.V(detailiedGLogLevel).Infoln("Deleted replicated secret")
+						ctrl.Queue.Add(allNamespacesKey)
+					},
+				},
 			},
-		},
-	)
+		)
+	}
+
+	// New service accounts, i.e. the default service account for a new namespace, need to be patched, we cause our own update events so ignore them
+	if informers.ServiceAccount != nil {
+		informers.ServiceAccount.AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					sa := obj.(*corev1.ServiceAccount)
+					glog.V(detailiedGLogLevel).Infof("Added ns [%s] service account [%s]\n", sa.Namespace, sa.Name)
+					ctrl.Queue.Add(sa.Namespace)
+				},
+			},
+		)
+	}
+
+	// Discovery mode, pods or workloads that use ECR registries result in the namespace being processed
+	if config.DiscoveryMode {
+		if informers.Pod != nil {
+			informers.Pod.AddEventHandler(ctrl.newDiscoveryEventHandler())
+		}
+		for _, informer := range informers.Workloads {
+			informer.AddEventHandler(ctrl.newDiscoveryEventHandler())
+		}
+	}
+
+	// Pods failing to pull ECR images result in an urgent renewal for the namespace
+	if config.ReactToImagePullFailures && informers.Pod != nil {
+		informers.Pod.AddEventHandler(ctrl.newImagePullFailureEventHandler())
+	}
+
+	// Image pull credential changes result in the namespaces they select being processed
+	if informers.ImagePullCredential != nil {
+		informers.ImagePullCredential.AddEventHandler(ctrl.newImagePullCredentialEventHandler())
+	}
 
 	return ctrl, nil
 }
@@ -104,22 +366,29 @@ func (c *controller) Run(stop <-chan struct{}) {
 
 	// PENDING: Should we fail if we can't connect to the cluster ? So subject this to a timeout
 	glog.Infoln("Waiting for cache sync")
-	if !cache.WaitForCacheSync(stop, c.NamespaceListerSynced) {
+	if !cache.WaitForCacheSync(stop, c.InformersSynced...) {
 		glog.Infoln("Timed out waiting for cache sync")
 		return
 	}
 	glog.Infoln("Caches are synced")
+	c.Health.recordCachesSynced()
 
 	glog.Infoln("Starting queue consumer loop")
 	go c.runQueueConsumerLoop()
 
-	tick := time.Tick(c.Config.AuthenticationTokenRenewalInterval)
+	ticker := time.NewTicker(c.getRenewalInterval())
+	defer func() { ticker.Stop() }()
 	for {
 		// First population will be via the Informers AddFunc
 		select {
-		case <-tick:
+		case <-ticker.C:
 			glog.Infoln("Adding queue key to renew for all namespaces")
 			c.Queue.Add(allNamespacesKey)
+		case <-c.RenewalScheduleReset:
+			interval := c.getRenewalInterval()
+			glog.Infof("Resetting renewal schedule to every %s\n", interval)
+			ticker.Stop()
+			ticker = time.NewTicker(interval)
 		case <-stop:
 			glog.Infoln("Received stop signal, exiting loop")
 			return
@@ -127,7 +396,17 @@ func (c *controller) Run(stop <-chan struct{}) {
 	}
 }
 
+func (c *controller) getRenewalInterval() time.Duration {
+	c.ConfigMutex.RLock()
+	defer c.ConfigMutex.RUnlock()
+
+	return c.Config.AuthenticationTokenRenewalInterval
+}
+
 func (c *controller) runQueueConsumerLoop() {
+	c.Health.recordConsumerRunning(true)
+	defer c.Health.recordConsumerRunning(false)
+
 	for {
 		key, quit := c.Queue.Get()
 		if quit {
@@ -137,10 +416,16 @@ func (c *controller) runQueueConsumerLoop() {
 
 		skey := key.(string)
 		glog.V(detailiedGLogLevel).Infof("Processing queue item [%s]\n", skey)
-		if err := c.renewECRImagePullSecrets(skey); err != nil {
+		c.Health.recordProcessing(time.Now())
+		if skey == configReloadKey {
+			if err := c.reloadConfig(); err != nil {
+				glog.Warningf("Config reload error: %s\n", err)
+			}
+		} else if err := c.renewECRImagePullSecrets(skey); err != nil {
 			// Not going to bother with retrying, could do with c.Queue.AddRateLimited(key)
 			glog.Warningf("Renew ECR image pull secrets error: %s\n", err)
 		}
+		c.Health.recordProcessing(time.Time{})
 
 		c.Queue.Forget(key)
 		c.Queue.Done(key)
@@ -149,67 +434,287 @@ func (c *controller) runQueueConsumerLoop() {
 
 func (c *controller) renewECRImagePullSecrets(key string) error {
 	glog.Infof("Renewing ECR image pull secrets for %s", key)
-	nss, err := c.getNamespacesToProcess(key)
+	start := time.Now()
+	defer func() { c.RenewalDurationHistogram.Observe(time.Since(start).Seconds()) }()
+
+	inputs, err := c.getRenewalInputs(key)
+	if err != nil {
+		return errors.Wrap(err, "get renewal inputs failed")
+	}
+
+	// Image pull credentials with a renewal policy interval schedule their own next renewal, the queue de-duplicates pending keys
+	if name, ok := getImagePullCredentialKeyName(key); ok {
+		if ipc := inputs.getImagePullCredential(name); ipc != nil && ipc.Spec.RenewalPolicy.Interval.Duration > 0 {
+			glog.V(detailiedGLogLevel).Infof("Scheduling image pull credential [%s] renewal in %s\n", name, ipc.Spec.RenewalPolicy.Interval.Duration)
+			c.Queue.AddAfter(key, ipc.Spec.RenewalPolicy.Interval.Duration)
+		}
+	}
+
+	nss, err := c.getNamespacesToProcess(key, inputs)
 	if err != nil {
 		return errors.Wrap(err, "get namespaces to process failed")
 	}
+	if key == allNamespacesKey {
+		nsNames := sets.NewString()
+		for _, ns := range nss {
+			nsNames.Insert(ns.Name)
+		}
+		c.Status.retainNamespaces(nsNames)
+	}
 	if len(nss) == 0 {
 		glog.V(detailiedGLogLevel).Infoln("No namespaces to process")
-		return nil
+		if key == allNamespacesKey {
+			c.Health.recordAllNamespacesRenewal()
+		}
+		return c.publishStatus()
 	}
 
-	secretNames := c.getDistinctSecretNames(nss)
-	authTokenData, err := c.createECRAuthTokenData(secretNames)
+	// Failures are collected rather than aborting the renewal, so one bad credential or namespace does not stop the others being renewed
+	errs := []error{}
+	credentialRequests := c.getDistinctCredentialRequests(nss, inputs)
+	authTokenData, authTokenFailures, err := c.createECRAuthTokenData(credentialRequests)
 	if err != nil {
-		return errors.Wrap(err, "create ECR authorization tokens failed")
-	}
-	if len(authTokenData) == 0 {
-		glog.V(detailiedGLogLevel).Infoln("No ECR authorization tokens created")
-		return nil
+		errs = append(errs, errors.Wrap(err, "create ECR authorization tokens failed"))
 	}
 
 	for _, ns := range nss {
-		for k, v := range ns.Labels {
-			if namespaceSecretLabelKeyRegEx.MatchString(k) && v == "true" {
-				if authToken, ok := authTokenData[k]; ok {
-					err = c.createNamespaceSecret(ns.Name, k, authToken)
-					if err != nil {
-						return errors.Wrapf(err, "create namespace [%s] secret [%s] failed", ns.Name, k)
-					}
-					c.SecretsCounter.WithLabelValues(ns.Name, k).Inc()
-				} else {
-					glog.V(detailiedGLogLevel).Infof("Skipping for namespace [%s] secret [%s], no ECR authorization token found\n", ns.Name, k)
-				}
-			}
+		renewedRegistries, err := c.renewNamespaceSecrets(ns, inputs, authTokenData, authTokenFailures)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "renew namespace [%s] secrets failed", ns.Name))
+			continue
 		}
+		c.remediateImagePullFailures(ns.Name, renewedRegistries)
 	}
 
 	if key == allNamespacesKey {
 		c.SecretRenewalsCounter.Inc()
+		c.Health.recordAllNamespacesRenewal()
+	}
+
+	if err = c.publishStatus(); err != nil {
+		errs = append(errs, errors.Wrap(err, "publish status failed"))
 	}
 
 	glog.V(detailiedGLogLevel).Infoln("Completed renewing secrets")
 
-	return nil
+	return utilerrors.NewAggregate(errs)
 }
 
-// Get a slice of namespaces that have a label that matches the namespace secret label key regex - special case is the all namespaces key
-func (c *controller) getNamespacesToProcess(key string) ([]corev1.Namespace, error) {
-	list := &corev1.NamespaceList{}
-	if key == allNamespacesKey {
-		glog.V(detailiedGLogLevel).Infoln("Getting namespaces")
-		nsList, err := c.K8S.GetNamespaces()
+// Renew a namespace's secrets, either a secret per requested registry or a single merged secret if configured, then removes any managed secrets no longer wanted
+// Returns the ECR registries whose secret was written, registries without a token or denied by policy are not included
+func (c *controller) renewNamespaceSecrets(ns corev1.Namespace, inputs *renewalInputs, authTokenData map[credentialRequest]*ecr.AuthorizationData, authTokenFailures map[credentialRequest]credentialRequestFailure) (sets.String, error) {
+	merge := c.Config.MergedSecretName != ""
+	merged := newDockerConfigJSON()
+	wantedSecretNames := sets.NewString()
+	renewedRegistries := sets.NewString()
+
+	allowedSecretNames, deniedSecretNames := getAllowedNamespaceSecretNames(c.Policy, ns, inputs)
+	requestedRegistries := sets.NewString()
+	for _, k := range append(append([]string{}, allowedSecretNames...), deniedSecretNames...) {
+		if _, ok := inputs.ReplicatedSecrets[k]; !ok {
+			requestedRegistries.Insert(inputs.getRegistry(k))
+		}
+	}
+	c.Status.retainNamespaceRegistries(ns.Name, requestedRegistries)
+	c.DiscoveryWarnings.retain(ns.Name, requestedRegistries)
+
+	for _, k := range deniedSecretNames {
+		glog.Warningf("Namespace [%s] request for [%s] denied by policy\n", ns.Name, k)
+		c.PolicyDenialsCounter.WithLabelValues(ns.Name, k).Inc()
+		c.Recorder.namespaceEvent(ns, corev1.EventTypeWarning, policyDeniedEventReason, fmt.Sprintf("Request for [%s] image pull secret denied by policy", k))
+		if _, ok := inputs.ReplicatedSecrets[k]; !ok {
+			c.Status.recordNamespace(inputs.getRegistry(k), ns.Name, errors.New("denied by policy"))
+		}
+	}
+
+	mergedRegistries := []string{}
+	mergedExpiresAt := time.Time{}
+
+	for _, k := range allowedSecretNames {
+		if merge {
+			wantedSecretNames.Insert(c.Config.MergedSecretName)
+		} else {
+			wantedSecretNames.Insert(k)
+		}
+
+		if sec, ok := inputs.ReplicatedSecrets[k]; ok {
+			if merge {
+				if err := merged.mergeSecretData(sec.Data[corev1.DockerConfigJsonKey]); err != nil {
+					glog.Warningf("Skipping for namespace [%s] secret [%s], replicated secret content is invalid: %s\n", ns.Name, k, err)
+				}
+				continue
+			}
+			if ns.Name == c.Config.HostNamespace {
+				// Would replicate the source secret onto itself
+				glog.V(detailiedGLogLevel).Infof("Skipping for namespace [%s] secret [%s], is the replicated source secret\n", ns.Name, k)
+				continue
+			}
+			err := c.replicateNamespaceSecret(ns.Name, sec)
+			c.Status.recordSecret(ns.Name, k, nil, time.Time{}, err)
+			if err != nil {
+				return nil, errors.Wrapf(err, "replicate namespace [%s] secret [%s] failed", ns.Name, k)
+			}
+			c.SecretsCounter.WithLabelValues(ns.Name, k).Inc()
+			continue
+		}
+
+		registry := inputs.getRegistry(k)
+		request := inputs.getCredentialRequest(ns, k)
+		authToken, ok := authTokenData[request]
+		if !ok {
+			glog.V(detailiedGLogLevel).Infof("Skipping for namespace [%s] secret [%s], no ECR authorization token found\n", ns.Name, k)
+			failure, ok := authTokenFailures[request]
+			if !ok {
+				failure = credentialRequestFailure{EventReason: ecrFailedEventReason, Err: errors.New("no ECR authorization token")}
+			}
+			c.Status.recordNamespace(registry, ns.Name, failure.Err)
+			if !merge {
+				c.Status.recordSecret(ns.Name, k, []string{registry}, time.Time{}, failure.Err)
+			}
+			// Discovered registries are requested on every renewal without the namespace asking, so only warn when the failure changes
+			if !inputs.isDiscoveredOnly(ns, k) || c.DiscoveryWarnings.changed(ns.Name, k, failure.EventReason) {
+				c.Recorder.namespaceEvent(ns, corev1.EventTypeWarning, failure.EventReason, fmt.Sprintf("No [%s] image pull secret, %s", k, failure.Err))
+			}
+			continue
+		}
+		c.DiscoveryWarnings.clear(ns.Name, k)
+		if merge {
+			merged.addAuthToken(authToken)
+			mergedRegistries = append(mergedRegistries, registry)
+			if mergedExpiresAt.IsZero() || aws.TimeValue(authToken.ExpiresAt).Before(mergedExpiresAt) {
+				mergedExpiresAt = aws.TimeValue(authToken.ExpiresAt)
+			}
+			continue
+		}
+		var err error
+		if ipc, ok := inputs.ImagePullCredentials[k]; ok && ipc.getTargetSecretType() == corev1.SecretTypeDockercfg {
+			err = c.createNamespaceDockerCfgSecret(ns.Name, k, authToken)
+		} else {
+			err = c.createNamespaceSecret(ns.Name, k, authToken)
+		}
+		c.Status.recordNamespace(registry, ns.Name, err)
+		c.Status.recordSecret(ns.Name, k, []string{registry}, aws.TimeValue(authToken.ExpiresAt), err)
 		if err != nil {
-			return nil, errors.Wrap(err, "get namespaces failed")
+			c.Recorder.namespaceEvent(ns, corev1.EventTypeWarning, secretWriteFailedEventReason, fmt.Sprintf("Write of [%s] image pull secret failed, %s", k, err))
+			return nil, errors.Wrapf(err, "create namespace [%s] secret [%s] failed", ns.Name, k)
+		}
+		renewedRegistries.Insert(registry)
+		c.SecretsCounter.WithLabelValues(ns.Name, k).Inc()
+		c.SecretTokenExpiryGauge.WithLabelValues(ns.Name, k).Set(float64(aws.TimeValue(authToken.ExpiresAt).Unix()))
+	}
+
+	if merge && len(merged.Auths) > 0 {
+		secretData, err := merged.marshal()
+		if err == nil {
+			err = c.writeNamespaceSecret(ns.Name, c.Config.MergedSecretName, corev1.SecretTypeDockerConfigJson, secretData, mergedExpiresAt)
+		}
+		for _, registry := range mergedRegistries {
+			c.Status.recordNamespace(registry, ns.Name, err)
+		}
+		c.Status.recordSecret(ns.Name, c.Config.MergedSecretName, mergedRegistries, mergedExpiresAt, err)
+		if err != nil {
+			c.Recorder.namespaceEvent(ns, corev1.EventTypeWarning, secretWriteFailedEventReason, fmt.Sprintf("Write of [%s] image pull secret failed, %s", c.Config.MergedSecretName, err))
+			return nil, errors.Wrapf(err, "create namespace [%s] merged secret [%s] failed", ns.Name, c.Config.MergedSecretName)
+		}
+		renewedRegistries.Insert(mergedRegistries...)
+		c.SecretsCounter.WithLabelValues(ns.Name, c.Config.MergedSecretName).Inc()
+		if !mergedExpiresAt.IsZero() {
+			// Earliest expiry of the merged ECR tokens
+			c.SecretTokenExpiryGauge.WithLabelValues(ns.Name, c.Config.MergedSecretName).Set(float64(mergedExpiresAt.Unix()))
+		}
+	}
+
+	if err := c.deleteUnwantedNamespaceSecrets(ns.Name, wantedSecretNames); err != nil {
+		return nil, errors.Wrapf(err, "delete unwanted namespace [%s] secrets failed", ns.Name)
+	}
+
+	if c.Config.PatchServiceAccounts {
+		if err := c.patchNamespaceServiceAccounts(ns, wantedSecretNames); err != nil {
+			return nil, errors.Wrapf(err, "patch namespace [%s] service accounts failed", ns.Name)
+		}
+	}
+
+	return renewedRegistries, nil
+}
+
+// Get the renewal inputs, these are gathered once per renewal rather than per namespace
+func (c *controller) getRenewalInputs(key string) (*renewalInputs, error) {
+	replicatedSecrets, err := c.getReplicatedSecrets(c.Config.HostNamespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "get replicated secrets failed")
+	}
+
+	imagePullCredentials, err := c.getImagePullCredentials(c.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "get image pull credentials failed")
+	}
+
+	discoveredRegistries := map[string]sets.String{}
+	if c.Config.DiscoveryMode {
+		nsName := key
+		if !isNamespaceKey(key) {
+			nsName = metav1.NamespaceAll
+		}
+		if discoveredRegistries, err = c.discoverRegistries(nsName); err != nil {
+			return nil, errors.Wrap(err, "discover registries failed")
+		}
+	}
+
+	return &renewalInputs{
+		DiscoveredRegistries: discoveredRegistries,
+		ImagePullCredentials: imagePullCredentials,
+		ReplicatedSecrets:    replicatedSecrets,
+	}, nil
+}
+
+// Get a map of host namespace docker config json secrets that are annotated for replication, keyed by secret name
+func (c *controller) getReplicatedSecrets(hostNamespace string) (map[string]*corev1.Secret, error) {
+	glog.V(detailiedGLogLevel).Infof("Getting namespace [%s] replicated secrets\n", hostNamespace)
+	list, err := c.K8S.GetSecrets(hostNamespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get namespace [%s] secrets failed", hostNamespace)
+	}
+
+	res := map[string]*corev1.Secret{}
+	for i := range list.Items {
+		if isReplicatedSecret(&list.Items[i]) {
+			res[list.Items[i].Name] = &list.Items[i]
+		}
+	}
+
+	return res, nil
+}
+
+// Get a slice of namespaces to process - special cases are the all namespaces and image pull credential keys
+// For the all namespaces key we only include namespaces that are requesting secrets, see renewalInputs.getNamespaceSecretNames
+// For an image pull credential key we only include namespaces the image pull credential selects
+// A single namespace is always included if active, so we can remove secrets where the labels have been removed
+// Namespaces outside the namespaces the controller is scoped to are never included
+func (c *controller) getNamespacesToProcess(key string, inputs *renewalInputs) ([]corev1.Namespace, error) {
+	if isNamespaceKey(key) {
+		if !isNamespaceInScope(c.Config, key) {
+			glog.V(detailiedGLogLevel).Infof("Namespace [%s] is not in scope, nothing to process\n", key)
+			return nil, nil
 		}
-		list = nsList
-	} else {
 		glog.V(detailiedGLogLevel).Infof("Getting namespace [%s]\n", key)
 		ns, err := c.K8S.GetNamespace(key)
 		if err != nil {
+			if k8serr.IsNotFound(err) {
+				glog.V(detailiedGLogLevel).Infof("Namespace [%s] no longer exists, nothing to process\n", key)
+				return nil, nil
+			}
 			return nil, errors.Wrapf(err, "get namespace [%s] failed", key)
 		}
-		list.Items = append(list.Items, *ns)
+		if ns.Status.Phase != corev1.NamespaceActive {
+			return nil, nil
+		}
+		return []corev1.Namespace{*ns}, nil
+	}
+
+	glog.V(detailiedGLogLevel).Infoln("Getting namespaces")
+	list, err := c.K8S.GetNamespaces()
+	if err != nil {
+		return nil, errors.Wrap(err, "get namespaces failed")
 	}
 
 	nss := []corev1.Namespace{}
@@ -218,45 +723,87 @@ func (c *controller) getNamespacesToProcess(key string) ([]corev1.Namespace, err
 			// If the host namespace or namespace is not active, skip
 			continue
 		}
-		for k, v := range ns.Labels {
-			if namespaceSecretLabelKeyRegEx.MatchString(k) && v == "true" {
-				nss = append(nss, ns)
-				break
-			}
+		if isNamespaceInScope(c.Config, ns.Name) && isNamespaceRequestingSecrets(key, ns, inputs) {
+			nss = append(nss, ns)
 		}
 	}
 
 	return nss, nil
 }
 
-// Get a slice of distinct secret names across all namespaces, secret name is a label key that matches a regex
-func (c *controller) getDistinctSecretNames(nss []corev1.Namespace) []string {
-	names := sets.NewString()
+// Get a slice of distinct ECR credential requests across all namespaces, secret name is a label key that matches a regex or a discovered registry, excludes registries denied by the policy
+// Namespaces using the same credential set share a request, so a single token is created per credential set and registry
+func (c *controller) getDistinctCredentialRequests(nss []corev1.Namespace, inputs *renewalInputs) []credentialRequest {
+	requests := map[credentialRequest]bool{}
 	for _, ns := range nss {
-		for k, v := range ns.Labels {
-			if namespaceSecretLabelKeyRegEx.MatchString(k) && v == "true" {
-				names.Insert(k)
+		allowedSecretNames, _ := getAllowedNamespaceSecretNames(c.Policy, ns, inputs)
+		for _, k := range allowedSecretNames {
+			if _, ok := inputs.ReplicatedSecrets[k]; !ok {
+				requests[inputs.getCredentialRequest(ns, k)] = true
 			}
 		}
 	}
 
-	return names.List()
+	res := make([]credentialRequest, 0, len(requests))
+	for request := range requests {
+		res = append(res, request)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].String() < res[j].String() })
+
+	return res
+}
+
+// Split the secret names a namespace is requesting into those the policy allows and those it denies, the policy is applied to the secret's registry
+// ECR secrets using the namespace's credential set are also denied if the policy does not allow the namespace the credential set
+func getAllowedNamespaceSecretNames(registryPolicy *policy, ns corev1.Namespace, inputs *renewalInputs) (allowed, denied []string) {
+	credentialSetAllowed := registryPolicy.allowsCredentialSet(getNamespaceCredentialSet(ns), ns)
+	for _, k := range inputs.getNamespaceSecretNames(ns) {
+		if !credentialSetAllowed && inputs.usesCredentialSet(k) {
+			denied = append(denied, k)
+			continue
+		}
+		if registryPolicy.allows(inputs.getRegistry(k), ns) {
+			allowed = append(allowed, k)
+		} else {
+			denied = append(denied, k)
+		}
+	}
+
+	return allowed, denied
 }
 
-// Create ECR auth token data map, will use secrets in the host namespace to connect to AWS ECR to get this token data, will not error if secret not found, might be there the next time we try
-func (c *controller) createECRAuthTokenData(secretNames []string) (map[string]*ecr.AuthorizationData, error) {
-	res := map[string]*ecr.AuthorizationData{}
+// Create ECR auth token data map, will use secrets in the host namespace (or the namespace an image pull credential names) to connect to AWS ECR to get this token data, will not error if secret not found, might be there the next time we try
+// Credential set requests only use the credential set's secret, there is no fallback to the default credentials so teams cannot end up sharing an identity
+// Token request failures do not stop other tokens being created, the returned maps have the tokens that were created and why the other requests failed, the error is an aggregate of the token request failures
+// Events are recorded on the AWS credentials secret when it is used and when ECR fails
+func (c *controller) createECRAuthTokenData(requests []credentialRequest) (map[credentialRequest]*ecr.AuthorizationData, map[credentialRequest]credentialRequestFailure, error) {
+	res := map[credentialRequest]*ecr.AuthorizationData{}
+	failures := map[credentialRequest]credentialRequestFailure{}
+	errs := []error{}
+
+	for _, request := range requests {
+		secretName := request.SecretName
+		if !request.isValid() {
+			glog.Warningf("Credential set [%s] is not a valid name, will skip, will not be able to satisfy label %s\n", request.CredentialSet, secretName)
+			failures[request] = credentialRequestFailure{EventReason: credentialsInvalidEventReason, Err: errors.Errorf("credential set [%s] is not a valid name", request.CredentialSet)}
+			c.ECRErrorsCounter.WithLabelValues(request.getRegistry(), credentialsInvalidEventReason).Inc()
+			continue
+		}
 
-	for _, secretName := range secretNames {
-		awsCredentialsSecretName := c.Config.AWSCredentialsSecretPrefix + "-" + secretName
-		glog.V(detailiedGLogLevel).Infof("Getting namespace [%s] AWS credentials secret [%s]\n", c.Config.HostNamespace, awsCredentialsSecretName)
-		sec, err := c.K8S.GetSecret(c.Config.HostNamespace, awsCredentialsSecretName)
+		awsCredentialsSecretNamespace := request.getAWSCredentialsSecretNamespace(c.Config.HostNamespace)
+		awsCredentialsSecretName := request.getAWSCredentialsSecretName(c.Config.AWSCredentialsSecretPrefix)
+		glog.V(detailiedGLogLevel).Infof("Getting namespace [%s] AWS credentials secret [%s]\n", awsCredentialsSecretNamespace, awsCredentialsSecretName)
+		sec, err := c.K8S.GetSecret(awsCredentialsSecretNamespace, awsCredentialsSecretName)
 		if err != nil {
 			if k8serr.IsNotFound(err) {
-				glog.Infof("Namespace [%s] AWS credentials secret [%s] was not found, will skip, will not be able to satisfy label %s\n", c.Config.HostNamespace, awsCredentialsSecretName, secretName)
+				glog.Infof("Namespace [%s] AWS credentials secret [%s] was not found, will skip, will not be able to satisfy label %s\n", awsCredentialsSecretNamespace, awsCredentialsSecretName, secretName)
+				err = errors.Errorf("namespace [%s] AWS credentials secret [%s] was not found", awsCredentialsSecretNamespace, awsCredentialsSecretName)
+				c.Status.recordFetchFailure(request.getRegistry(), err)
+				failures[request] = credentialRequestFailure{EventReason: credentialsMissingEventReason, Err: err}
+				c.ECRErrorsCounter.WithLabelValues(request.getRegistry(), credentialsMissingEventReason).Inc()
 				continue
 			}
-			return nil, errors.Wrapf(err, "get namespace [%s] AWS credentials secret [%s] failed", c.Config.HostNamespace, awsCredentialsSecretName)
+			return res, failures, errors.Wrapf(err, "get namespace [%s] AWS credentials secret [%s] failed", awsCredentialsSecretNamespace, awsCredentialsSecretName)
 		}
 
 		region := string(sec.Data["aws_region"])
@@ -265,45 +812,262 @@ func (c *controller) createECRAuthTokenData(secretNames []string) (map[string]*e
 		maskedID := id
 
 		glog.V(detailiedGLogLevel).Infof("Getting AWS ECR authorization token for region [%s] and access key id [%s]\n", region, maskedID)
+		ecrStart := time.Now()
 		authTokenData, err := c.ECR.GetAuthToken(context.Background(), region, id, secret)
+		c.ECRRequestDurationHistogram.WithLabelValues(request.getRegistry()).Observe(time.Since(ecrStart).Seconds())
 		if err != nil {
-			return nil, errors.Wrapf(err, "get ECR authorization token failed for region [%s] and access key id [%s]", region, maskedID)
+			reason := getECRFailureEventReason(err)
+			c.ECRErrorsCounter.WithLabelValues(request.getRegistry(), reason).Inc()
+			err = errors.Wrapf(err, "get ECR authorization token failed for region [%s] and access key id [%s]", region, maskedID)
+			c.Status.recordFetchFailure(request.getRegistry(), err)
+			c.Recorder.secretEvent(sec, corev1.EventTypeWarning, reason, fmt.Sprintf("Getting an ECR authorization token for [%s] failed, %s", request.getRegistry(), err))
+			failures[request] = credentialRequestFailure{EventReason: reason, Err: err}
+			errs = append(errs, err)
+			continue
 		}
+		c.Status.recordFetch(request.getRegistry(), time.Now(), aws.TimeValue(authTokenData.ExpiresAt))
+		c.RegistryLastRenewalGauge.WithLabelValues(request.getRegistry()).Set(float64(time.Now().Unix()))
 
-		res[secretName] = authTokenData
+		res[request] = authTokenData
 	}
 
-	return res, nil
+	return res, failures, utilerrors.NewAggregate(errs)
 }
 
 // Create namespace Docker json config secret, will update if it already exists
 func (c *controller) createNamespaceSecret(nsName, secretName string, authTokenData *ecr.AuthorizationData) error {
-	endpoint := *(*authTokenData).ProxyEndpoint
-	password := *(*authTokenData).AuthorizationToken
-	secretData := []byte(fmt.Sprintf(secretDataTemplate, endpoint, password))
+	config := newDockerConfigJSON()
+	config.addAuthToken(authTokenData)
+	secretData, err := config.marshal()
+	if err != nil {
+		return errors.Wrapf(err, "marshal namespace [%s] secret [%s] failed", nsName, secretName)
+	}
+
+	return c.writeNamespaceSecret(nsName, secretName, corev1.SecretTypeDockerConfigJson, secretData, aws.TimeValue(authTokenData.ExpiresAt))
+}
+
+// Create namespace legacy Docker config secret, will update if it already exists
+func (c *controller) createNamespaceDockerCfgSecret(nsName, secretName string, authTokenData *ecr.AuthorizationData) error {
+	config := newDockerConfigJSON()
+	config.addAuthToken(authTokenData)
+	secretData, err := config.marshalDockerCfg()
+	if err != nil {
+		return errors.Wrapf(err, "marshal namespace [%s] secret [%s] failed", nsName, secretName)
+	}
+
+	return c.writeNamespaceSecret(nsName, secretName, corev1.SecretTypeDockercfg, secretData, aws.TimeValue(authTokenData.ExpiresAt))
+}
 
+// Replicate a host namespace secret's docker config json verbatim into a namespace, will update if it already exists
+func (c *controller) replicateNamespaceSecret(nsName string, source *corev1.Secret) error {
+	return c.writeNamespaceSecret(nsName, source.Name, corev1.SecretTypeDockerConfigJson, source.Data[corev1.DockerConfigJsonKey], time.Time{})
+}
+
+// Write namespace Docker json config (or legacy Docker config) secret labelled as managed by us, will update if it already exists and is managed by us
+// Existing secrets we do not manage are never overwritten, i.e. a replicated source secret or a secret created by hand, apart from pre-upgrade ECR secrets which are adopted, see isPreUpgradeSecret
+// Secrets with an ECR token are annotated with the token expiry, replicated secrets pass a zero expiry
+// A Normal event is only recorded if the secret is created or its content changes
+func (c *controller) writeNamespaceSecret(nsName, secretName string, secretType corev1.SecretType, secretData []byte, expiresAt time.Time) error {
+	dataKey := corev1.DockerConfigJsonKey
+	if secretType == corev1.SecretTypeDockercfg {
+		dataKey = corev1.DockerConfigKey
+	}
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: secretName,
+			Labels: map[string]string{managedByLabelKey: managedByLabelValue},
+			Name:   secretName,
 		},
 		Data: map[string][]byte{
-			corev1.DockerConfigJsonKey: secretData,
+			dataKey: secretData,
 		},
-		Type: corev1.SecretTypeDockerConfigJson,
+		Type: secretType,
+	}
+	if !expiresAt.IsZero() {
+		secret.ObjectMeta.Annotations = map[string]string{expiresAtAnnotationKey: expiresAt.UTC().Format(time.RFC3339)}
 	}
 
-	_, err := c.K8S.GetSecret(nsName, secretName)
+	reason := secretRenewedEventReason
+	existing, err := c.K8S.GetSecret(nsName, secretName)
+	if err == nil && existing.Labels[managedByLabelKey] != managedByLabelValue {
+		if !isPreUpgradeSecret(existing) {
+			return errors.Errorf("namespace [%s] secret [%s] exists and is not managed by eatr, not overwriting", nsName, secretName)
+		}
+		glog.Infof("Adopting namespace [%s] secret [%s], was written before secrets were labelled as managed by eatr\n", nsName, secretName)
+	}
 	if err != nil {
 		glog.V(detailiedGLogLevel).Infof("Creating namespace [%s] secret [%s]\n", nsName, secretName)
-		_, err = c.K8S.CreateSecret(nsName, secret)
+		reason = secretCreatedEventReason
+		secret, err = c.K8S.CreateSecret(nsName, secret)
 	} else {
 		glog.V(detailiedGLogLevel).Infof("Updating namespace [%s] secret [%s]\n", nsName, secretName)
-		_, err = c.K8S.UpdateSecret(nsName, secret)
+		secret, err = c.K8S.UpdateSecret(nsName, secret)
 	}
 	if err != nil {
 		return errors.Wrapf(err, "create or update of namespace [%s] secret [%s] failed", nsName, secretName)
 	}
+	if reason == secretCreatedEventReason || existing.Type != secretType || !bytes.Equal(existing.Data[dataKey], secretData) {
+		c.Recorder.secretEvent(secret, corev1.EventTypeNormal, reason, fmt.Sprintf("Wrote image pull secret [%s]", secretName))
+	}
 
 	glog.Infof("Created\\Updated namespace [%s] secret [%s]\n", nsName, secretName)
 	return nil
 }
+
+// Is the secret an ECR image pull secret written before we labelled the secrets we manage, an unlabelled docker config json secret named after an ECR registry
+// Every registry a namespace labels for is an ECR registry name, so this covers all the secrets we wrote before the upgrade, they are labelled when next written
+func isPreUpgradeSecret(sec *corev1.Secret) bool {
+	return sec.Labels[managedByLabelKey] != managedByLabelValue && sec.Type == corev1.SecretTypeDockerConfigJson && namespaceSecretLabelKeyRegEx.MatchString(sec.Name)
+}
+
+// Delete secrets we manage in a namespace that are no longer wanted, i.e. the namespace label was removed or the replicated source secret no longer exists
+func (c *controller) deleteUnwantedNamespaceSecrets(nsName string, wantedSecretNames sets.String) error {
+	list, err := c.K8S.GetSecrets(nsName)
+	if err != nil {
+		return errors.Wrapf(err, "get namespace [%s] secrets failed", nsName)
+	}
+
+	for _, sec := range list.Items {
+		if sec.Labels[managedByLabelKey] != managedByLabelValue || wantedSecretNames.Has(sec.Name) {
+			continue
+		}
+
+		glog.V(detailiedGLogLevel).Infof("Deleting namespace [%s] secret [%s]\n", nsName, sec.Name)
+		if err = c.K8S.DeleteSecret(nsName, sec.Name); err != nil && !k8serr.IsNotFound(err) {
+			return errors.Wrapf(err, "delete of namespace [%s] secret [%s] failed", nsName, sec.Name)
+		}
+		c.SecretsDeletedCounter.WithLabelValues(nsName, sec.Name).Inc()
+		c.SecretTokenExpiryGauge.DeleteLabelValues(nsName, sec.Name)
+		c.Status.forgetSecret(nsName, sec.Name)
+		glog.Infof("Deleted namespace [%s] secret [%s]\n", nsName, sec.Name)
+	}
+
+	return nil
+}
+
+// Inputs gathered once per renewal that determine which secrets each namespace is requesting
+type renewalInputs struct {
+	DiscoveredRegistries map[string]sets.String          // Namespace name to ECR registry DNS names discovered from workloads, only populated in discovery mode
+	ImagePullCredentials map[string]*imagePullCredential // Valid image pull credentials keyed by target secret name, only populated if image pull credentials are enabled
+	ReplicatedSecrets    map[string]*corev1.Secret       // Host namespace replicated secrets keyed by name
+}
+
+// Get the secret names a namespace is requesting, these are label keys set to true that match the namespace secret label key regex or a replicated secret name, plus any discovered registries
+// Image pull credentials take precedence over the label convention, a registry with an image pull credential is satisfied by the image pull credential's target secret if it selects the namespace
+func (r *renewalInputs) getNamespaceSecretNames(ns corev1.Namespace) []string {
+	names := sets.NewString()
+	for k, v := range ns.Labels {
+		if v != "true" {
+			continue
+		}
+		if _, ok := r.ReplicatedSecrets[k]; ok {
+			names.Insert(k)
+		} else if namespaceSecretLabelKeyRegEx.MatchString(k) && r.getRegistryImagePullCredential(k) == nil {
+			names.Insert(k)
+		}
+	}
+	for _, registry := range r.DiscoveredRegistries[ns.Name].UnsortedList() {
+		if ipc := r.getRegistryImagePullCredential(registry); ipc != nil {
+			names.Insert(ipc.getTargetSecretName())
+			continue
+		}
+		names.Insert(registry)
+	}
+	for secretName, ipc := range r.ImagePullCredentials {
+		if ipc.selects(ns) {
+			names.Insert(secretName)
+		}
+	}
+
+	return names.List()
+}
+
+// Is the requested secret only there because its registry was discovered, i.e. the namespace has not also labelled for it
+func (r *renewalInputs) isDiscoveredOnly(ns corev1.Namespace, secretName string) bool {
+	return r.DiscoveredRegistries[ns.Name].Has(secretName) && ns.Labels[secretName] != "true"
+}
+
+// Does the requested secret use the namespace's credential set, replicated secrets have no credentials and image pull credentials name their own
+func (r *renewalInputs) usesCredentialSet(secretName string) bool {
+	_, replicated := r.ReplicatedSecrets[secretName]
+	_, imagePullCredential := r.ImagePullCredentials[secretName]
+
+	return !replicated && !imagePullCredential
+}
+
+// Get the registry a requested secret is for, this is the secret name unless the secret is an image pull credential target secret
+func (r *renewalInputs) getRegistry(secretName string) string {
+	if ipc, ok := r.ImagePullCredentials[secretName]; ok {
+		return ipc.Spec.Registry
+	}
+
+	return secretName
+}
+
+// Get the credential request for a namespace requested ECR secret
+func (r *renewalInputs) getCredentialRequest(ns corev1.Namespace, secretName string) credentialRequest {
+	if ipc, ok := r.ImagePullCredentials[secretName]; ok {
+		return credentialRequest{
+			SecretName:                secretName,
+			Registry:                  ipc.Spec.Registry,
+			CredentialSecretNamespace: ipc.Spec.CredentialSecretRef.Namespace,
+			CredentialSecretName:      ipc.Spec.CredentialSecretRef.Name,
+		}
+	}
+
+	return credentialRequest{CredentialSet: getNamespaceCredentialSet(ns), SecretName: secretName}
+}
+
+// Get the image pull credential with the name, nil if there is no valid image pull credential with this name
+func (r *renewalInputs) getImagePullCredential(name string) *imagePullCredential {
+	for _, ipc := range r.ImagePullCredentials {
+		if ipc.Name == name {
+			return ipc
+		}
+	}
+
+	return nil
+}
+
+// Get the image pull credential for a registry, nil if there is none, if there are many the one with the lowest target secret name is used
+func (r *renewalInputs) getRegistryImagePullCredential(registry string) *imagePullCredential {
+	secretNames := sets.NewString()
+	for secretName, ipc := range r.ImagePullCredentials {
+		if ipc.Spec.Registry == registry {
+			secretNames.Insert(secretName)
+		}
+	}
+	if secretNames.Len() == 0 {
+		return nil
+	}
+
+	return r.ImagePullCredentials[secretNames.List()[0]]
+}
+
+// Is the namespace one the controller manages secrets in, all namespaces are unless the controller is scoped to namespaces
+func isNamespaceInScope(config config, nsName string) bool {
+	scopedNamespaces := getScopedNamespaces(config)
+
+	return len(scopedNamespaces) == 0 || sets.NewString(scopedNamespaces...).Has(nsName)
+}
+
+// Is the queue key for a single namespace
+func isNamespaceKey(key string) bool {
+	_, isImagePullCredentialKey := getImagePullCredentialKeyName(key)
+	return key != allNamespacesKey && !isImagePullCredentialKey
+}
+
+// Is the namespace requesting secrets, for an image pull credential key only secrets for that image pull credential count
+func isNamespaceRequestingSecrets(key string, ns corev1.Namespace, inputs *renewalInputs) bool {
+	name, ok := getImagePullCredentialKeyName(key)
+	if !ok {
+		return len(inputs.getNamespaceSecretNames(ns)) > 0
+	}
+
+	ipc := inputs.getImagePullCredential(name)
+	return ipc != nil && sets.NewString(inputs.getNamespaceSecretNames(ns)...).Has(ipc.getTargetSecretName())
+}
+
+// Is the secret a docker config json secret that has been annotated for replication
+func isReplicatedSecret(sec *corev1.Secret) bool {
+	return sec.Type == corev1.SecretTypeDockerConfigJson && sec.Annotations[replicateAnnotationKey] == "true"
+}
@@ -2,16 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 const (
@@ -35,10 +39,11 @@ func TestNewControllerWithFakes(t *testing.T) {
 		},
 	})
 	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
 	prometheusRegistry := prometheus.NewRegistry()
 	ecrClient := &FakeECRClient{}
 
-	ctrl, err := newController(config, k8sClient, nsInformer, prometheusRegistry, ecrClient)
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
 
 	assert.Nil(t, err, "New controller")
 	assert.Equal(t, k8sClient, ctrl.K8S, "Controller.K8S")
@@ -186,11 +191,12 @@ func TestRunController(t *testing.T) {
 			}
 			k8sClient := NewFakeK8SClient(seedData)
 			nsInformer := NewFakeSharedInformer()
+			secretInformer := NewFakeSharedInformer()
 			prometheusRegistry := prometheus.NewRegistry()
 			ecrClient := NewFakeECRClient()
 
 			ctx, cancel := context.WithCancel(context.Background())
-			ctrl, err := newController(config, k8sClient, nsInformer, prometheusRegistry, ecrClient)
+			ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
 			assert.Nil(t, err, "New controller error")
 
 			go ctrl.Run(ctx.Done())
@@ -270,33 +276,72 @@ func TestGetNamespacesToProcess(t *testing.T) {
 		},
 	})
 	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
 	prometheusRegistry := prometheus.NewRegistry()
 	ecrClient := NewFakeECRClient()
 
-	ctrl, err := newController(config, k8sClient, nsInformer, prometheusRegistry, ecrClient)
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
 	assert.Nil(t, err, "New controller error")
 
-	nss, err := ctrl.getNamespacesToProcess(allNamespacesKey)
+	nss, err := ctrl.getNamespacesToProcess(allNamespacesKey, &renewalInputs{})
 	assert.Nil(t, err, "Get namespaces to process error")
 	assert.NotNil(t, 3, len(nss), "Namesapces to process count")
 }
 
-func TestGetDistinctSecretNames(t *testing.T) {
+func TestGetNamespacesToProcessScopedToNamespaces(t *testing.T) {
+	config := getDefaultConfig()
+	config.Namespaces = ns1
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true"},
+		},
+		{
+			Name:     ns2,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true"},
+		},
+	})
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+
+	nss, err := ctrl.getNamespacesToProcess(allNamespacesKey, &renewalInputs{})
+	assert.Nil(t, err, "Get namespaces to process error")
+	assert.Equal(t, 1, len(nss), "Namespaces to process count")
+	assert.Equal(t, ns1, nss[0].Name, "Namespace to process")
+
+	nss, err = ctrl.getNamespacesToProcess(ns2, &renewalInputs{})
+	assert.Nil(t, err, "Get out of scope namespace to process error")
+	assert.Empty(t, nss, "Out of scope namespace to process")
+}
+
+func TestGetDistinctCredentialRequests(t *testing.T) {
 	config := getDefaultConfig()
 	k8sClient := NewFakeK8SClient(nil)
 	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
 	prometheusRegistry := prometheus.NewRegistry()
 	ecrClient := NewFakeECRClient()
 
-	ctrl, err := newController(config, k8sClient, nsInformer, prometheusRegistry, ecrClient)
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
 	assert.Nil(t, err, "New controller error")
 
-	secretNames := ctrl.getDistinctSecretNames([]corev1.Namespace{
+	requests := ctrl.getDistinctCredentialRequests([]corev1.Namespace{
 		corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns1, Namespace: ns1, Labels: map[string]string{"abc": "something", ecr1: "true", ecr2: "false", ecr3: "true"}}},
 		corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns2, Namespace: ns1, Labels: map[string]string{ecr3: "true", "env": "dev"}}},
-	})
+	}, &renewalInputs{})
+
+	assert.Equal(t, 2, len(requests), "Count")
+
+	requests = ctrl.getDistinctCredentialRequests([]corev1.Namespace{
+		corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns1, Namespace: ns1, Labels: map[string]string{ecr1: "true", credentialSetLabelKey: "team-a"}}},
+		corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns2, Namespace: ns2, Labels: map[string]string{ecr1: "true", credentialSetLabelKey: "team-a"}}},
+		corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns3, Namespace: ns3, Labels: map[string]string{ecr1: "true"}}},
+	}, &renewalInputs{})
 
-	assert.Equal(t, 2, len(secretNames), "Count")
+	assert.Equal(t, []credentialRequest{{SecretName: ecr1}, {CredentialSet: "team-a", SecretName: ecr1}}, requests, "Credential set requests")
 }
 
 func TestCreateECRAuthTokenData(t *testing.T) {
@@ -307,6 +352,7 @@ func TestCreateECRAuthTokenData(t *testing.T) {
 		NamespaceName        string   // Namespace
 		NamespaceSeedSecrets []string // Namespace seed secrets
 		SecretNames          []string // Secret names
+		CredentialSet        string   // Credential set used for all the secret names
 		ExpectedCount        int      // Expected secret auth data tokens created
 	}{
 		{
@@ -330,6 +376,22 @@ func TestCreateECRAuthTokenData(t *testing.T) {
 			SecretNames:          []string{"s1", "s2"},
 			ExpectedCount:        2,
 		},
+		{
+			Name:                 "Credential set AWS credential secret exists",
+			HostNamespaceSecrets: []string{config.AWSCredentialsSecretPrefix + "-s1", config.AWSCredentialsSecretPrefix + "-team-a-s2"},
+			NamespaceName:        ns1,
+			SecretNames:          []string{"s1", "s2"},
+			CredentialSet:        "team-a",
+			ExpectedCount:        1,
+		},
+		{
+			Name:                 "Invalid credential set",
+			HostNamespaceSecrets: []string{config.AWSCredentialsSecretPrefix + "-s1"},
+			NamespaceName:        ns1,
+			SecretNames:          []string{"s1"},
+			CredentialSet:        "Team_A",
+			ExpectedCount:        0,
+		},
 	} {
 		t.Run(tc.Name, func(t *testing.T) {
 			k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
@@ -344,13 +406,18 @@ func TestCreateECRAuthTokenData(t *testing.T) {
 				},
 			})
 			nsInformer := NewFakeSharedInformer()
+			secretInformer := NewFakeSharedInformer()
 			prometheusRegistry := prometheus.NewRegistry()
 			ecrClient := NewFakeECRClient()
 
-			ctrl, err := newController(config, k8sClient, nsInformer, prometheusRegistry, ecrClient)
+			ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
 			assert.Nil(t, err, "New controller error")
 
-			authTokenData, err := ctrl.createECRAuthTokenData(tc.SecretNames)
+			requests := []credentialRequest{}
+			for _, secretName := range tc.SecretNames {
+				requests = append(requests, credentialRequest{CredentialSet: tc.CredentialSet, SecretName: secretName})
+			}
+			authTokenData, _, err := ctrl.createECRAuthTokenData(requests)
 			assert.Nil(t, err, "Create ECR token data")
 			assert.NotNil(t, authTokenData, "ECR token data")
 			assert.Equal(t, tc.ExpectedCount, len(authTokenData), "ECR token data count")
@@ -384,10 +451,11 @@ func TestCreateNamespaceSecret(t *testing.T) {
 				},
 			})
 			nsInformer := NewFakeSharedInformer()
+			secretInformer := NewFakeSharedInformer()
 			prometheusRegistry := prometheus.NewRegistry()
 			ecrClient := &FakeECRClient{}
 
-			ctrl, err := newController(config, k8sClient, nsInformer, prometheusRegistry, ecrClient)
+			ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
 			assert.Nil(t, err, "New controller error")
 
 			// Create
@@ -406,3 +474,855 @@ func TestCreateNamespaceSecret(t *testing.T) {
 		})
 	}
 }
+
+func TestReplicatedSecrets(t *testing.T) {
+	config := getDefaultConfig()
+	const replicatedSecretName = "dockerhub"
+	for _, tc := range []struct {
+		Name                 string            // Test case name
+		HostNamespaceSecrets []string          // Host namespace replicated secrets
+		NS1NamespaceLabels   map[string]string // Namespace 1 labels
+		UpdatedNS1Labels     map[string]string // Namespace 1 labels post initialization, nil if no update
+		DeleteSourceSecret   bool              // Delete the host namespace replicated secret post initialization
+		ExpectedInitialExist bool              // Expect the replica to exist in namespace 1 after initialization
+		ExpectedFinalExist   bool              // Expect the replica to exist in namespace 1 at the end
+	}{
+		{
+			Name:                 "No replicated secret exists",
+			HostNamespaceSecrets: []string{},
+			NS1NamespaceLabels:   map[string]string{replicatedSecretName: "true"},
+			ExpectedInitialExist: false,
+			ExpectedFinalExist:   false,
+		},
+		{
+			Name:                 "Replicated secret exists but namespace label is false",
+			HostNamespaceSecrets: []string{replicatedSecretName},
+			NS1NamespaceLabels:   map[string]string{replicatedSecretName: "false"},
+			ExpectedInitialExist: false,
+			ExpectedFinalExist:   false,
+		},
+		{
+			Name:                 "Replicated secret exists and namespace is labelled",
+			HostNamespaceSecrets: []string{replicatedSecretName},
+			NS1NamespaceLabels:   map[string]string{replicatedSecretName: "true"},
+			ExpectedInitialExist: true,
+			ExpectedFinalExist:   true,
+		},
+		{
+			Name:                 "Subsequent namespace alteration where label removed",
+			HostNamespaceSecrets: []string{replicatedSecretName},
+			NS1NamespaceLabels:   map[string]string{replicatedSecretName: "true"},
+			UpdatedNS1Labels:     map[string]string{},
+			ExpectedInitialExist: true,
+			ExpectedFinalExist:   false,
+		},
+		{
+			Name:                 "Subsequent replicated secret deletion",
+			HostNamespaceSecrets: []string{replicatedSecretName},
+			NS1NamespaceLabels:   map[string]string{replicatedSecretName: "true"},
+			DeleteSourceSecret:   true,
+			ExpectedInitialExist: true,
+			ExpectedFinalExist:   false,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+				{
+					Name:              config.HostNamespace,
+					IsActive:          true,
+					ReplicatedSecrets: tc.HostNamespaceSecrets,
+				},
+				{
+					Name:     ns1,
+					IsActive: true,
+					Labels:   tc.NS1NamespaceLabels,
+				},
+			})
+			nsInformer := NewFakeSharedInformer()
+			secretInformer := NewFakeSharedInformer()
+			prometheusRegistry := prometheus.NewRegistry()
+			ecrClient := NewFakeECRClient()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+			assert.Nil(t, err, "New controller error")
+
+			go ctrl.Run(ctx.Done())
+
+			nsList, _ := k8sClient.GetNamespaces()
+			for _, ns := range nsList.Items {
+				nsInformer.SimulateAddNamespace(&ns)
+			}
+
+			time.Sleep(150 * time.Millisecond)
+			assert.Equal(t, tc.ExpectedInitialExist, k8sClient.SecretExists(ns1, replicatedSecretName), "Initial replica exists")
+
+			if tc.UpdatedNS1Labels != nil {
+				oldNS, _ := k8sClient.GetNamespace(ns1)
+				newNS := oldNS.DeepCopy()
+				newNS.Labels = tc.UpdatedNS1Labels
+				newNS.ResourceVersion += "."
+				k8sClient.UpdateNamespaceRecord(newNS)
+				nsInformer.SimulateUpdateNamespace(oldNS, newNS)
+			}
+			if tc.DeleteSourceSecret {
+				source, _ := k8sClient.GetSecret(config.HostNamespace, replicatedSecretName)
+				_ = k8sClient.DeleteSecret(config.HostNamespace, replicatedSecretName)
+				secretInformer.SimulateDeleteSecret(source)
+			}
+
+			time.Sleep(150 * time.Millisecond)
+			assert.Equal(t, tc.ExpectedFinalExist, k8sClient.SecretExists(ns1, replicatedSecretName), "Final replica exists")
+		})
+	}
+}
+
+func TestReplicatedSecretNotOverwritten(t *testing.T) {
+	config := getDefaultConfig()
+	const replicatedSecretName = "dockerhub"
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:              config.HostNamespace,
+			IsActive:          true,
+			Labels:            map[string]string{replicatedSecretName: "true"},
+			ReplicatedSecrets: []string{replicatedSecretName},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{replicatedSecretName: "true"},
+		},
+		{
+			Name:     ns2,
+			IsActive: true,
+			Labels:   map[string]string{replicatedSecretName: "true"},
+			Secrets:  []string{replicatedSecretName},
+		},
+	})
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+
+	err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+	assert.NotNil(t, err, "Renewal error")
+	assert.Contains(t, err.Error(), "namespace [ns-2] secret [dockerhub] exists and is not managed by eatr", "Renewal error message")
+
+	source, _ := k8sClient.GetSecret(config.HostNamespace, replicatedSecretName)
+	assert.Equal(t, "true", source.Annotations[replicateAnnotationKey], "Source secret replicate annotation")
+	assert.Equal(t, "", source.Labels[managedByLabelKey], "Source secret managed by label")
+	replica, _ := k8sClient.GetSecret(ns1, replicatedSecretName)
+	assert.Equal(t, managedByLabelValue, replica.Labels[managedByLabelKey], "Replica managed by label")
+	unmanaged, _ := k8sClient.GetSecret(ns2, replicatedSecretName)
+	assert.Equal(t, "", unmanaged.Labels[managedByLabelKey], "Unmanaged secret managed by label")
+	assert.Equal(t, "Warning:"+secretWriteFailedEventReason, k8sClient.WaitForEventReasons(ns2, "Warning:"+secretWriteFailedEventReason), "Unmanaged secret write failed event")
+}
+
+func TestPreUpgradeSecretAdopted(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1},
+		},
+		{
+			Name:              ns1,
+			IsActive:          true,
+			Labels:            map[string]string{ecr1: "true"},
+			PreUpgradeSecrets: []string{ecr1},
+		},
+	})
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+	preUpgrade, _ := k8sClient.GetSecret(ns1, ecr1)
+
+	err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+	assert.Nil(t, err, "Renewal error")
+
+	adopted, _ := k8sClient.GetSecret(ns1, ecr1)
+	assert.Equal(t, managedByLabelValue, adopted.Labels[managedByLabelKey], "Adopted secret managed by label")
+	assert.NotEmpty(t, adopted.Annotations[expiresAtAnnotationKey], "Adopted secret expires at annotation")
+	assert.NotEqual(t, preUpgrade.Data[corev1.DockerConfigJsonKey], adopted.Data[corev1.DockerConfigJsonKey], "Adopted secret data renewed")
+	assert.Equal(t, 1, k8sClient.UpdatedSecretCount(), "Secret update count")
+}
+
+func TestMergedSecret(t *testing.T) {
+	config := getDefaultConfig()
+	config.MergedSecretName = "eatr-registries"
+	for _, tc := range []struct {
+		Name               string            // Test case name
+		NS1NamespaceLabels map[string]string // Namespace 1 labels
+		UpdatedNS1Labels   map[string]string // Namespace 1 labels for the second renewal
+		ExpectedAuthCount  int               // Expected merged secret auths count after first renewal
+		ExpectedFinalCount int               // Expected merged secret auths count after second renewal, 0 means the secret should not exist
+	}{
+		{
+			Name:               "All registries merged",
+			NS1NamespaceLabels: map[string]string{ecr1: "true", ecr2: "true", "dockerhub": "true"},
+			UpdatedNS1Labels:   map[string]string{ecr1: "true", ecr2: "true", "dockerhub": "true"},
+			ExpectedAuthCount:  3,
+			ExpectedFinalCount: 3,
+		},
+		{
+			Name:               "Registry label removed",
+			NS1NamespaceLabels: map[string]string{ecr1: "true", ecr2: "true", "dockerhub": "true"},
+			UpdatedNS1Labels:   map[string]string{ecr1: "true", ecr2: "false"},
+			ExpectedAuthCount:  3,
+			ExpectedFinalCount: 1,
+		},
+		{
+			Name:               "All registry labels removed",
+			NS1NamespaceLabels: map[string]string{ecr1: "true"},
+			UpdatedNS1Labels:   map[string]string{},
+			ExpectedAuthCount:  1,
+			ExpectedFinalCount: 0,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+				{
+					Name:              config.HostNamespace,
+					IsActive:          true,
+					Secrets:           []string{config.AWSCredentialsSecretPrefix + "-" + ecr1, config.AWSCredentialsSecretPrefix + "-" + ecr2},
+					ReplicatedSecrets: []string{"dockerhub"},
+				},
+				{
+					Name:     ns1,
+					IsActive: true,
+					Labels:   tc.NS1NamespaceLabels,
+				},
+			})
+			nsInformer := NewFakeSharedInformer()
+			secretInformer := NewFakeSharedInformer()
+			prometheusRegistry := prometheus.NewRegistry()
+			ecrClient := NewFakeECRClient()
+			// Distinct endpoint per call so each registry gets its own auths entry
+			calls := 0
+			ecrClient.GetAuthTokenFn = func(ctx context.Context, region, id, secret string) (*ecr.AuthorizationData, error) {
+				calls++
+				return &ecr.AuthorizationData{
+					AuthorizationToken: aws.String("SomeAuthTokenJibberish"),
+					ExpiresAt:          aws.Time(time.Now().Add(12 * time.Hour)),
+					ProxyEndpoint:      aws.String(fmt.Sprintf("https://registry-%d", calls)),
+				}, nil
+			}
+
+			ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+			assert.Nil(t, err, "New controller error")
+
+			getAuthCount := func() int {
+				sec, err := k8sClient.GetSecret(ns1, config.MergedSecretName)
+				if err != nil {
+					return 0
+				}
+				merged := newDockerConfigJSON()
+				assert.Nil(t, json.Unmarshal(sec.Data[corev1.DockerConfigJsonKey], merged), "Merged secret content")
+				return len(merged.Auths)
+			}
+
+			err = ctrl.renewECRImagePullSecrets(ns1)
+			assert.Nil(t, err, "Initial renewal error")
+			assert.Equal(t, tc.ExpectedAuthCount, getAuthCount(), "Initial merged secret auths count")
+			for k := range tc.NS1NamespaceLabels {
+				assert.False(t, k8sClient.SecretExists(ns1, k), "Individual secret should not exist")
+			}
+
+			ns, _ := k8sClient.GetNamespace(ns1)
+			ns.Labels = tc.UpdatedNS1Labels
+			k8sClient.UpdateNamespaceRecord(ns)
+
+			err = ctrl.renewECRImagePullSecrets(ns1)
+			assert.Nil(t, err, "Final renewal error")
+			assert.Equal(t, tc.ExpectedFinalCount, getAuthCount(), "Final merged secret auths count")
+		})
+	}
+}
+
+func TestPatchServiceAccounts(t *testing.T) {
+	config := getDefaultConfig()
+	config.PatchServiceAccounts = true
+	for _, tc := range []struct {
+		Name                 string            // Test case name
+		NS1Annotations       map[string]string // Namespace 1 annotations
+		NS1NamespaceLabels   map[string]string // Namespace 1 labels
+		UpdatedNS1Labels     map[string]string // Namespace 1 labels for the second renewal
+		ExpectedDefaultSA    string            // Expected comma separated default service account image pull secret names after first renewal
+		ExpectedBuilderSA    string            // Expected comma separated builder service account image pull secret names after first renewal
+		ExpectedFinalDefault string            // Expected comma separated default service account image pull secret names after second renewal
+	}{
+		{
+			Name:                 "Default service account patched",
+			NS1NamespaceLabels:   map[string]string{ecr1: "true", ecr2: "true"},
+			UpdatedNS1Labels:     map[string]string{ecr1: "true", ecr2: "true"},
+			ExpectedDefaultSA:    ecr1 + "," + ecr2,
+			ExpectedBuilderSA:    "",
+			ExpectedFinalDefault: ecr1 + "," + ecr2,
+		},
+		{
+			Name:                 "Namespace annotation overrides service account names",
+			NS1Annotations:       map[string]string{serviceAccountsAnnotationKey: "builder"},
+			NS1NamespaceLabels:   map[string]string{ecr1: "true"},
+			UpdatedNS1Labels:     map[string]string{ecr1: "true"},
+			ExpectedDefaultSA:    "",
+			ExpectedBuilderSA:    ecr1,
+			ExpectedFinalDefault: "",
+		},
+		{
+			Name:                 "Label removed",
+			NS1NamespaceLabels:   map[string]string{ecr1: "true", ecr2: "true"},
+			UpdatedNS1Labels:     map[string]string{ecr2: "true"},
+			ExpectedDefaultSA:    ecr1 + "," + ecr2,
+			ExpectedBuilderSA:    "",
+			ExpectedFinalDefault: ecr2,
+		},
+		{
+			Name:                 "All labels removed",
+			NS1NamespaceLabels:   map[string]string{ecr1: "true"},
+			UpdatedNS1Labels:     map[string]string{},
+			ExpectedDefaultSA:    ecr1,
+			ExpectedBuilderSA:    "",
+			ExpectedFinalDefault: "",
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+				{
+					Name:     config.HostNamespace,
+					IsActive: true,
+					Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1, config.AWSCredentialsSecretPrefix + "-" + ecr2},
+				},
+				{
+					Name:            ns1,
+					IsActive:        true,
+					Annotations:     tc.NS1Annotations,
+					Labels:          tc.NS1NamespaceLabels,
+					ServiceAccounts: []string{"default", "builder"},
+				},
+			})
+			nsInformer := NewFakeSharedInformer()
+			secretInformer := NewFakeSharedInformer()
+			saInformer := NewFakeSharedInformer()
+			prometheusRegistry := prometheus.NewRegistry()
+			ecrClient := NewFakeECRClient()
+
+			ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer, ServiceAccount: saInformer}, prometheusRegistry, ecrClient)
+			assert.Nil(t, err, "New controller error")
+
+			err = ctrl.renewECRImagePullSecrets(ns1)
+			assert.Nil(t, err, "Initial renewal error")
+			assert.Equal(t, tc.ExpectedDefaultSA, k8sClient.ServiceAccountImagePullSecretNames(ns1, "default"), "Initial default service account image pull secrets")
+			assert.Equal(t, tc.ExpectedBuilderSA, k8sClient.ServiceAccountImagePullSecretNames(ns1, "builder"), "Initial builder service account image pull secrets")
+
+			ns, _ := k8sClient.GetNamespace(ns1)
+			ns.Labels = tc.UpdatedNS1Labels
+			k8sClient.UpdateNamespaceRecord(ns)
+
+			err = ctrl.renewECRImagePullSecrets(ns1)
+			assert.Nil(t, err, "Final renewal error")
+			assert.Equal(t, tc.ExpectedFinalDefault, k8sClient.ServiceAccountImagePullSecretNames(ns1, "default"), "Final default service account image pull secrets")
+		})
+	}
+}
+
+func TestNewServiceAccountIsPatched(t *testing.T) {
+	config := getDefaultConfig()
+	config.PatchServiceAccounts = true
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true"},
+		},
+	})
+	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
+	saInformer := NewFakeSharedInformer()
+	prometheusRegistry := prometheus.NewRegistry()
+	ecrClient := NewFakeECRClient()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer, ServiceAccount: saInformer}, prometheusRegistry, ecrClient)
+	assert.Nil(t, err, "New controller error")
+
+	go ctrl.Run(ctx.Done())
+
+	// Service account created after the namespace, as happens for a new namespace
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: ns1}}
+	k8sClient.InsertNewServiceAccountRecord(sa)
+	saInformer.SimulateAddServiceAccount(sa)
+
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, ecr1, k8sClient.ServiceAccountImagePullSecretNames(ns1, "default"), "Default service account image pull secrets")
+}
+
+func TestSetServiceAccountImagePullSecrets(t *testing.T) {
+	for _, tc := range []struct {
+		Name              string   // Test case name
+		Existing          []string // Existing image pull secret names
+		PreviouslyPatched string   // Existing tracking annotation value, empty if never patched
+		Desired           []string // Desired managed secret names
+		ExpectedChanged   bool     // Expect the service account to be altered
+		Expected          []string // Expected image pull secret names
+	}{
+		{
+			Name:            "Never patched",
+			Existing:        []string{"other"},
+			Desired:         []string{ecr1},
+			ExpectedChanged: true,
+			Expected:        []string{"other", ecr1},
+		},
+		{
+			Name:              "Already patched",
+			Existing:          []string{"other", ecr1},
+			PreviouslyPatched: ecr1,
+			Desired:           []string{ecr1},
+			ExpectedChanged:   false,
+			Expected:          []string{"other", ecr1},
+		},
+		{
+			Name:              "Managed secret no longer wanted",
+			Existing:          []string{ecr1, "other", ecr2},
+			PreviouslyPatched: ecr1 + "," + ecr2,
+			Desired:           []string{ecr2},
+			ExpectedChanged:   true,
+			Expected:          []string{"other", ecr2},
+		},
+		{
+			Name:              "Secret with same name added by someone else is left alone",
+			Existing:          []string{ecr1},
+			PreviouslyPatched: "",
+			Desired:           []string{},
+			ExpectedChanged:   false,
+			Expected:          []string{ecr1},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: ns1}}
+			for _, name := range tc.Existing {
+				sa.ImagePullSecrets = append(sa.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+			}
+			if tc.PreviouslyPatched != "" {
+				sa.Annotations = map[string]string{serviceAccountImagePullSecretsAnnotationKey: tc.PreviouslyPatched}
+			}
+
+			changed := setServiceAccountImagePullSecrets(sa, sets.NewString(tc.Desired...))
+
+			actual := []string{}
+			for _, ref := range sa.ImagePullSecrets {
+				actual = append(actual, ref.Name)
+			}
+			assert.Equal(t, tc.ExpectedChanged, changed, "Changed")
+			assert.Equal(t, tc.Expected, actual, "Image pull secret names")
+		})
+	}
+}
+
+func TestDiscoveryMode(t *testing.T) {
+	for _, tc := range []struct {
+		Name                         string   // Test case name
+		DiscoveryMode                bool     // Discovery mode config
+		DiscoveryIncludeWorkloads    bool     // Discovery include workloads config
+		DiscoveryNamespaces          string   // Discovery namespaces allowlist config
+		NS1PodImages                 []string // Namespace 1 pod images
+		NS1DeploymentImages          []string // Namespace 1 deployment images
+		ExpectedNamespacedSecretKeys string   // Expected comma separated namespaced secret keys - distinct list of secrets that were created
+	}{
+		{
+			Name:                         "Discovery mode off",
+			NS1PodImages:                 []string{ecr1 + "/app:1.0"},
+			ExpectedNamespacedSecretKeys: "",
+		},
+		{
+			Name:                         "Pod image from registry with credentials",
+			DiscoveryMode:                true,
+			NS1PodImages:                 []string{ecr1 + "/app:1.0", "nginx:1.13"},
+			ExpectedNamespacedSecretKeys: "ns-1:123456789012.dkr.ecr.eu-west-1.amazonaws.com",
+		},
+		{
+			Name:                         "Pod image from registry with no credentials",
+			DiscoveryMode:                true,
+			NS1PodImages:                 []string{ecr3 + "/app:1.0"},
+			ExpectedNamespacedSecretKeys: "",
+		},
+		{
+			Name:                         "Namespace not in allowlist",
+			DiscoveryMode:                true,
+			DiscoveryNamespaces:          "team-*,other",
+			NS1PodImages:                 []string{ecr1 + "/app:1.0"},
+			ExpectedNamespacedSecretKeys: "",
+		},
+		{
+			Name:                         "Namespace matches allowlist pattern",
+			DiscoveryMode:                true,
+			DiscoveryNamespaces:          "team-*,ns-*",
+			NS1PodImages:                 []string{ecr1 + "/app:1.0"},
+			ExpectedNamespacedSecretKeys: "ns-1:123456789012.dkr.ecr.eu-west-1.amazonaws.com",
+		},
+		{
+			Name:                         "Deployment image ignored when not including workloads",
+			DiscoveryMode:                true,
+			NS1DeploymentImages:          []string{ecr2 + "/app:1.0"},
+			ExpectedNamespacedSecretKeys: "",
+		},
+		{
+			Name:                         "Deployment image included when including workloads",
+			DiscoveryMode:                true,
+			DiscoveryIncludeWorkloads:    true,
+			NS1PodImages:                 []string{ecr1 + "/app:1.0"},
+			NS1DeploymentImages:          []string{ecr2 + "/app:1.0"},
+			ExpectedNamespacedSecretKeys: "ns-1:123456789012.dkr.ecr.eu-west-1.amazonaws.com,ns-1:444456781111.dkr.ecr.us-east-1.amazonaws.com",
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			config := getDefaultConfig()
+			config.DiscoveryMode = tc.DiscoveryMode
+			config.DiscoveryIncludeWorkloads = tc.DiscoveryIncludeWorkloads
+			config.DiscoveryNamespaces = tc.DiscoveryNamespaces
+			k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+				{
+					Name:     config.HostNamespace,
+					IsActive: true,
+					Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1, config.AWSCredentialsSecretPrefix + "-" + ecr2},
+				},
+				{
+					Name:             ns1,
+					IsActive:         true,
+					PodImages:        tc.NS1PodImages,
+					DeploymentImages: tc.NS1DeploymentImages,
+				},
+			})
+			nsInformer := NewFakeSharedInformer()
+			secretInformer := NewFakeSharedInformer()
+			prometheusRegistry := prometheus.NewRegistry()
+			ecrClient := NewFakeECRClient()
+
+			ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+			assert.Nil(t, err, "New controller error")
+
+			err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+			assert.Nil(t, err, "Renewal error")
+			assert.Equal(t, tc.ExpectedNamespacedSecretKeys, k8sClient.DistinctNamespacedSecretKeysCreated(), "Namespaced secret keys")
+		})
+	}
+}
+
+func TestDiscoveryModeNewPod(t *testing.T) {
+	config := getDefaultConfig()
+	config.DiscoveryMode = true
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+		},
+	})
+	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
+	podInformer := NewFakeSharedInformer()
+	prometheusRegistry := prometheus.NewRegistry()
+	ecrClient := NewFakeECRClient()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer, Pod: podInformer}, prometheusRegistry, ecrClient)
+	assert.Nil(t, err, "New controller error")
+
+	go ctrl.Run(ctx.Done())
+
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, "", k8sClient.DistinctNamespacedSecretKeysCreated(), "Initial namespaced secret keys")
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: ns1}, Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "c1", Image: ecr1 + "/app:1.0"}}}}
+	// Only added to the informer's store, discovery does not list pods via the API when there is a pod informer
+	podInformer.SimulateAddPod(pod)
+
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, "ns-1:123456789012.dkr.ecr.eu-west-1.amazonaws.com", k8sClient.DistinctNamespacedSecretKeysCreated(), "Final namespaced secret keys")
+}
+
+func TestDiscoveryModeMissingCredentialsWarning(t *testing.T) {
+	config := getDefaultConfig()
+	config.DiscoveryMode = true
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+		},
+		{
+			Name:      ns1,
+			IsActive:  true,
+			PodImages: []string{ecr3 + "/app:1.0"},
+		},
+		{
+			Name:     ns2,
+			IsActive: true,
+			Labels:   map[string]string{ecr3: "true"},
+		},
+	})
+	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
+	prometheusRegistry := prometheus.NewRegistry()
+	ecrClient := NewFakeECRClient()
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+	assert.Nil(t, err, "New controller error")
+
+	for i := 0; i < 3; i++ {
+		err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+		assert.Nil(t, err, "Renewal error")
+	}
+	assert.Equal(t, "Warning:CredentialsMissing", k8sClient.WaitForEventReasons(ns1, "Warning:CredentialsMissing"), "Discovered registry events")
+	assert.Equal(t, "Warning:CredentialsMissing", k8sClient.WaitForEventReasons(ns2, "Warning:CredentialsMissing"), "Labelled registry events")
+	time.Sleep(100 * time.Millisecond)
+	// Repeated events are aggregated into a single event with a count
+	if events := k8sClient.Events(ns1); assert.Equal(t, 1, len(events), "Discovered registry events count") {
+		assert.Equal(t, int32(1), events[0].Count, "Discovered registry warning is only recorded once")
+	}
+	if events := k8sClient.Events(ns2); assert.Equal(t, 1, len(events), "Labelled registry events count") {
+		assert.Equal(t, int32(3), events[0].Count, "Labelled registry warning is recorded on every renewal")
+	}
+}
+
+func TestImagePullFailures(t *testing.T) {
+	const imagePullAuthFailureMessage = "rpc error: code = Unknown desc = Error response from daemon: Get https://" + ecr1 + "/v2/app/manifests/1.0: no basic auth credentials"
+
+	for _, tc := range []struct {
+		Name                       string // Test case name
+		DeleteImagePullFailurePods bool   // Delete image pull failure pods config
+		Image                      string // Failing pod image
+		WaitingReason              string // Failing pod container waiting reason
+		WaitingMessage             string // Failing pod container waiting message
+		Owned                      bool   // Failing pod has an owner
+		MissingCredentials         bool   // Host namespace has no AWS credentials secret for the registry
+		ExpectedDetection          bool   // Expect the image pull failure to be detected
+		ExpectedRenewal            bool   // Expect the namespace secrets to be renewed
+		ExpectedDeletedPodKeys     string // Expected comma separated namespaced pod keys that were deleted
+	}{
+		{
+			Name:              "Pod pulling ECR image with no credentials",
+			Image:             ecr1 + "/app:1.0",
+			WaitingReason:     "ErrImagePull",
+			WaitingMessage:    imagePullAuthFailureMessage,
+			Owned:             true,
+			ExpectedDetection: true,
+			ExpectedRenewal:   true,
+		},
+		{
+			Name:              "Pod pulling ECR image with an expired token",
+			Image:             ecr1 + "/app:1.0",
+			WaitingReason:     "ErrImagePull",
+			WaitingMessage:    "rpc error: code = Unknown desc = Error response from daemon: denied: Your authorization token has expired. Reauthenticate and try again.",
+			Owned:             true,
+			ExpectedDetection: true,
+			ExpectedRenewal:   true,
+		},
+		{
+			Name:            "Pod pulling ECR image that is backing off",
+			Image:           ecr1 + "/app:1.0",
+			WaitingReason:   "ImagePullBackOff",
+			WaitingMessage:  "Back-off pulling image \"" + ecr1 + "/app:1.0\"",
+			Owned:           true,
+			ExpectedRenewal: false,
+		},
+		{
+			Name:                       "Pod pulling ECR image that does not exist is neither renewed nor deleted",
+			DeleteImagePullFailurePods: true,
+			Image:                      ecr1 + "/app:1.0",
+			WaitingReason:              "ErrImagePull",
+			WaitingMessage:             "rpc error: code = Unknown desc = Error response from daemon: manifest for " + ecr1 + "/app:1.0 not found",
+			Owned:                      true,
+			ExpectedRenewal:            false,
+		},
+		{
+			Name:            "Pod pulling non ECR image that is backing off",
+			Image:           "nginx:1.13",
+			WaitingReason:   "ErrImagePull",
+			WaitingMessage:  imagePullAuthFailureMessage,
+			Owned:           true,
+			ExpectedRenewal: false,
+		},
+		{
+			Name:            "Pod pulling ECR image that is waiting for another reason",
+			Image:           ecr1 + "/app:1.0",
+			WaitingReason:   "ContainerCreating",
+			Owned:           true,
+			ExpectedRenewal: false,
+		},
+		{
+			Name:                       "Pod with owner is deleted",
+			DeleteImagePullFailurePods: true,
+			Image:                      ecr1 + "/app:1.0",
+			WaitingReason:              "ErrImagePull",
+			WaitingMessage:             imagePullAuthFailureMessage,
+			Owned:                      true,
+			ExpectedDetection:          true,
+			ExpectedRenewal:            true,
+			ExpectedDeletedPodKeys:     "ns-1:p1",
+		},
+		{
+			Name:                       "Pod with no owner is not deleted",
+			DeleteImagePullFailurePods: true,
+			Image:                      ecr1 + "/app:1.0",
+			WaitingReason:              "ErrImagePull",
+			WaitingMessage:             imagePullAuthFailureMessage,
+			Owned:                      false,
+			ExpectedDetection:          true,
+			ExpectedRenewal:            true,
+		},
+		{
+			Name:                       "Pod with owner is not deleted when the credentials are missing",
+			DeleteImagePullFailurePods: true,
+			Image:                      ecr1 + "/app:1.0",
+			WaitingReason:              "ErrImagePull",
+			WaitingMessage:             imagePullAuthFailureMessage,
+			Owned:                      true,
+			MissingCredentials:         true,
+			ExpectedDetection:          true,
+			ExpectedRenewal:            false,
+		},
+		{
+			Name:                       "Pod pulling ECR image the namespace does not request",
+			DeleteImagePullFailurePods: true,
+			Image:                      ecr2 + "/app:1.0",
+			WaitingReason:              "ErrImagePull",
+			WaitingMessage:             imagePullAuthFailureMessage,
+			Owned:                      true,
+			ExpectedDetection:          false,
+			ExpectedRenewal:            false,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			config := getDefaultConfig()
+			config.ReactToImagePullFailures = true
+			config.DeleteImagePullFailurePods = tc.DeleteImagePullFailurePods
+			hostSecrets := []string{config.AWSCredentialsSecretPrefix + "-" + ecr1}
+			if tc.MissingCredentials {
+				hostSecrets = nil
+			}
+			k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+				{
+					Name:     config.HostNamespace,
+					IsActive: true,
+					Secrets:  hostSecrets,
+				},
+				{
+					Name:     ns1,
+					IsActive: true,
+					Labels:   map[string]string{ecr1: "true"},
+				},
+			})
+			nsInformer := NewFakeSharedInformer()
+			secretInformer := NewFakeSharedInformer()
+			podInformer := NewFakeSharedInformer()
+			prometheusRegistry := prometheus.NewRegistry()
+			ecrClient := NewFakeECRClient()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer, Pod: podInformer}, prometheusRegistry, ecrClient)
+			assert.Nil(t, err, "New controller error")
+
+			go ctrl.Run(ctx.Done())
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: ns1}, Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "c1", Image: tc.Image}}}}
+			if tc.Owned {
+				pod.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "rs1"}}
+			}
+			k8sClient.InsertNewPodRecord(pod)
+			podInformer.SimulateAddPod(pod)
+
+			failingPod := pod.DeepCopy()
+			failingPod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "c1", Image: tc.Image, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: tc.WaitingReason, Message: tc.WaitingMessage}}}}
+			podInformer.SimulateUpdatePod(pod, failingPod)
+			// Repeated status update while backing off should not result in another detection
+			podInformer.SimulateUpdatePod(failingPod, failingPod)
+
+			time.Sleep(150 * time.Millisecond)
+			assert.Equal(t, tc.ExpectedRenewal, k8sClient.TotalSecretsCreated() > 0, "Renewal")
+			assert.Equal(t, tc.ExpectedDeletedPodKeys, k8sClient.DeletedPodKeys(), "Deleted pod keys")
+
+			detected := &dto.Metric{}
+			ctrl.ImagePullFailuresDetectedCounter.WithLabelValues(ns1, getImageRegistryHost(tc.Image)).Write(detected)
+			expectedDetected := 0.0
+			if tc.ExpectedDetection {
+				expectedDetected = 1
+			}
+			assert.Equal(t, expectedDetected, detected.GetCounter().GetValue(), "Detected count")
+		})
+	}
+}
+
+func TestCredentialSets(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1, config.AWSCredentialsSecretPrefix + "-team-a-" + ecr1},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", credentialSetLabelKey: "team-a"},
+		},
+		{
+			Name:     ns2,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", credentialSetLabelKey: "team-a"},
+		},
+		{
+			Name:     ns3,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true"},
+		},
+		{
+			Name:     ns4,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", credentialSetLabelKey: "team-b"},
+		},
+	})
+	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
+	prometheusRegistry := prometheus.NewRegistry()
+	ecrClient := NewFakeECRClient()
+	// Distinct token per call so we can tell which namespaces share a token
+	calls := 0
+	ecrClient.GetAuthTokenFn = func(ctx context.Context, region, id, secret string) (*ecr.AuthorizationData, error) {
+		calls++
+		return &ecr.AuthorizationData{
+			AuthorizationToken: aws.String(fmt.Sprintf("SomeAuthTokenJibberish-%d", calls)),
+			ExpiresAt:          aws.Time(time.Now().Add(12 * time.Hour)),
+			ProxyEndpoint:      aws.String("https://" + ecr1),
+		}, nil
+	}
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+	assert.Nil(t, err, "New controller error")
+
+	err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+	assert.Nil(t, err, "Renewal error")
+
+	getSecretData := func(ns string) string {
+		sec, err := k8sClient.GetSecret(ns, ecr1)
+		if err != nil {
+			return ""
+		}
+		return string(sec.Data[corev1.DockerConfigJsonKey])
+	}
+
+	assert.Equal(t, 2, calls, "ECR token calls, one per credential set")
+	assert.NotEqual(t, "", getSecretData(ns1), "Credential set namespace secret")
+	assert.Equal(t, getSecretData(ns1), getSecretData(ns2), "Credential set namespaces share a token")
+	assert.NotEqual(t, getSecretData(ns1), getSecretData(ns3), "Default credential namespace token")
+	assert.False(t, k8sClient.SecretExists(ns4, ecr1), "Credential set with no AWS credentials secret does not fall back to the default credentials")
+}
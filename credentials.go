@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	awsCredentialsLabelKey    = "eatr.io/aws-credentials" // Label we apply to the AWS credentials secrets the credentials command writes, used to list them
+	registryAnnotationKey     = "eatr.io/registry"        // Annotation we apply to AWS credentials secrets, the registry the credentials are for, as the secret name is ambiguous with credential sets
+	rotatedAtAnnotationKey    = "eatr.io/rotated-at"      // Annotation we apply to AWS credentials secrets, when the credentials were last written
+	stsGetCallerIdentityLimit = 30 * time.Second
+)
+
+// An AWS IAM user access key, the controller uses static credentials so no session token
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// The aws iam create-access-key JSON output, so the output can be piped in
+type awsCreateAccessKeyOutput struct {
+	AccessKey struct {
+		AccessKeyId     string
+		SecretAccessKey string
+	}
+}
+
+func getCredentialsCommands() []command {
+	return []command{
+		{Name: "add", Description: "Add an AWS credentials secret for a registry, fails if the secret already exists", Run: func(args []string) error { return runPutCredentialsCommand(args, false) }},
+		{Name: "list", Description: "List the AWS credentials secrets", Run: runListCredentialsCommand},
+		{Name: "remove", Description: "Remove an AWS credentials secret, fails if labelled namespaces still request the registry unless forced", Run: runRemoveCredentialsCommand},
+		{Name: "rotate", Description: "Replace the access key in an existing AWS credentials secret", Run: func(args []string) error { return runPutCredentialsCommand(args, true) }},
+	}
+}
+
+// Credentials command, replaces the k8s/create-eatr-aws-credentials-k8s-secret.sh script
+func runCredentialsCommand(args []string) error {
+	return dispatchCommand(args, getCredentialsCommands(), "")
+}
+
+// Add or rotate command, credentials come from flags, stdin or an AWS profile and are checked with STS before the secret is written
+func runPutCredentialsCommand(args []string, rotate bool) error {
+	var registry, credentialSet, accessKeyID, secretAccessKey, profile string
+	var useStdin bool
+	config, err := getConfig(args, func(fs *flag.FlagSet) {
+		addRegistryFlags(fs, &registry, &credentialSet)
+		fs.StringVar(&accessKeyID, "aws-access-key-id", "", "AWS access key Id, needs the AWS secret access key, prefer stdin or an AWS profile so the secret is not in your shell history")
+		fs.StringVar(&secretAccessKey, "aws-secret-access-key", "", "AWS secret access key")
+		fs.StringVar(&profile, "aws-profile", "", "AWS shared config profile to take the access key from")
+		fs.BoolVar(&useStdin, "stdin", false, "Read the access key from stdin, either the aws iam create-access-key JSON output or the access key Id and secret access key separated by whitespace")
+	})
+	if err != nil {
+		return errors.Wrap(err, "getConfig failed")
+	}
+
+	creds, err := getAWSCredentials(accessKeyID, secretAccessKey, profile, useStdin, os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	ctrl, err := newCommandController(config)
+	if err != nil {
+		return err
+	}
+
+	sec, err := ctrl.putAWSCredentials(newSTSClient(), registry, credentialSet, creds, rotate, time.Now())
+	if err != nil {
+		return err
+	}
+	if ctrl.DryRun != nil {
+		writePlan(os.Stdout, ctrl.DryRun.plan())
+		return nil
+	}
+
+	fmt.Fprintf(os.Stdout, "Wrote namespace [%s] AWS credentials secret [%s] for registry [%s]\n", sec.Namespace, sec.Name, registry)
+	if rotate {
+		fmt.Fprintln(os.Stdout, "Deactivate the previous access key once the managed secrets have been renewed, i.e. with the renew command")
+	}
+	return nil
+}
+
+// List command
+func runListCredentialsCommand(args []string) error {
+	config, err := getConfig(args, nil)
+	if err != nil {
+		return errors.Wrap(err, "getConfig failed")
+	}
+
+	ctrl, err := newCommandController(config)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.writeAWSCredentials(os.Stdout)
+}
+
+// Remove command
+func runRemoveCredentialsCommand(args []string) error {
+	var registry, credentialSet string
+	var force bool
+	config, err := getConfig(args, func(fs *flag.FlagSet) {
+		addRegistryFlags(fs, &registry, &credentialSet)
+		fs.BoolVar(&force, "force", false, "Force - If set the secret is removed even if labelled namespaces still request the registry")
+	})
+	if err != nil {
+		return errors.Wrap(err, "getConfig failed")
+	}
+
+	ctrl, err := newCommandController(config)
+	if err != nil {
+		return err
+	}
+
+	name, err := ctrl.removeAWSCredentials(registry, credentialSet, force)
+	if err != nil {
+		return err
+	}
+	if ctrl.DryRun != nil {
+		writePlan(os.Stdout, ctrl.DryRun.plan())
+		return nil
+	}
+
+	fmt.Fprintf(os.Stdout, "Removed namespace [%s] AWS credentials secret [%s]\n", config.HostNamespace, name)
+	return nil
+}
+
+func addRegistryFlags(fs *flag.FlagSet, registry, credentialSet *string) {
+	fs.StringVar(registry, "registry", "", "Registry the credentials are for, i.e. 123456789012.dkr.ecr.eu-west-1.amazonaws.com")
+	fs.StringVar(credentialSet, "credential-set", "", "Credential set, optional, for namespaces labelled with eatr.io/credential-set, the default credentials are used if not set")
+}
+
+// Get the AWS credentials from exactly one of the flags, stdin or an AWS profile
+func getAWSCredentials(accessKeyID, secretAccessKey, profile string, useStdin bool, stdin io.Reader) (awsCredentials, error) {
+	sources := 0
+	for _, used := range []bool{accessKeyID != "" || secretAccessKey != "", profile != "", useStdin} {
+		if used {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return awsCredentials{}, errors.New("exactly one of the access key flags, aws-profile or stdin must be used")
+	}
+
+	var creds awsCredentials
+	switch {
+	case profile != "":
+		var err error
+		if creds, err = getAWSProfileCredentials(profile); err != nil {
+			return awsCredentials{}, err
+		}
+	case useStdin:
+		data, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return awsCredentials{}, errors.Wrap(err, "read stdin failed")
+		}
+		creds = parseAWSCredentials(data)
+	default:
+		creds = awsCredentials{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey}
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return awsCredentials{}, errors.New("both the AWS access key Id and secret access key are needed")
+	}
+
+	return creds, nil
+}
+
+// Parse the aws iam create-access-key JSON output, or the access key Id and secret access key separated by whitespace
+func parseAWSCredentials(data []byte) awsCredentials {
+	out := awsCreateAccessKeyOutput{}
+	if err := json.Unmarshal(data, &out); err == nil {
+		return awsCredentials{AccessKeyID: out.AccessKey.AccessKeyId, SecretAccessKey: out.AccessKey.SecretAccessKey}
+	}
+	if fields := strings.Fields(string(data)); len(fields) == 2 {
+		return awsCredentials{AccessKeyID: fields[0], SecretAccessKey: fields[1]}
+	}
+
+	return awsCredentials{}
+}
+
+// Get the account Id and region from a registry host
+func parseECRRegistry(registry string) (string, string, error) {
+	matches := namespaceSecretLabelKeyRegEx.FindStringSubmatch(registry)
+	if matches == nil {
+		return "", "", errors.Errorf("registry [%s] is not an ECR registry host", registry)
+	}
+
+	return matches[1], matches[2], nil
+}
+
+// Write the AWS credentials secret for a registry, the credentials must belong to the registry's account
+// Add fails if the secret exists, rotate fails if it does not, rotate keeps any existing labels and annotations so also adopts secrets created by the old script
+func (c *controller) putAWSCredentials(stsClient stsInterface, registry, credentialSet string, creds awsCredentials, rotate bool, now time.Time) (*corev1.Secret, error) {
+	request := credentialRequest{CredentialSet: credentialSet, SecretName: registry}
+	if !request.isValid() {
+		return nil, errors.Errorf("credential set [%s] is not a valid name", credentialSet)
+	}
+	accountID, region, err := parseECRRegistry(registry)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stsGetCallerIdentityLimit)
+	defer cancel()
+	credsAccountID, err := stsClient.GetCallerIdentity(ctx, region, creds.AccessKeyID, creds.SecretAccessKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "AWS credentials check failed for registry [%s]", registry)
+	}
+	if credsAccountID != accountID {
+		return nil, errors.Errorf("AWS credentials are for account [%s] but registry [%s] is in account [%s]", credsAccountID, registry, accountID)
+	}
+
+	ns, name := c.Config.HostNamespace, request.getAWSCredentialsSecretName(c.Config.AWSCredentialsSecretPrefix)
+	existing, err := c.K8S.GetSecret(ns, name)
+	if err != nil {
+		if !k8serr.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "get namespace [%s] AWS credentials secret [%s] failed", ns, name)
+		}
+		existing = nil
+	}
+	if rotate && existing == nil {
+		return nil, errors.Errorf("namespace [%s] AWS credentials secret [%s] was not found, use add", ns, name)
+	}
+	if !rotate && existing != nil {
+		return nil, errors.Errorf("namespace [%s] AWS credentials secret [%s] already exists, use rotate", ns, name)
+	}
+
+	sec := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns}, Type: corev1.SecretTypeOpaque}
+	if existing != nil {
+		sec = existing.DeepCopy()
+	}
+	if sec.Labels == nil {
+		sec.Labels = map[string]string{}
+	}
+	if sec.Annotations == nil {
+		sec.Annotations = map[string]string{}
+	}
+	sec.Labels[awsCredentialsLabelKey] = "true"
+	if credentialSet != "" {
+		sec.Labels[credentialSetLabelKey] = credentialSet
+	}
+	sec.Annotations[registryAnnotationKey] = registry
+	sec.Annotations[rotatedAtAnnotationKey] = now.UTC().Format(time.RFC3339)
+	sec.Data = map[string][]byte{
+		"aws_access_key_id":     []byte(creds.AccessKeyID),
+		"aws_region":            []byte(region),
+		"aws_secret_access_key": []byte(creds.SecretAccessKey),
+	}
+
+	if existing == nil {
+		sec, err = c.K8S.CreateSecret(ns, sec)
+	} else {
+		sec, err = c.K8S.UpdateSecret(ns, sec)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "write namespace [%s] AWS credentials secret [%s] failed", ns, name)
+	}
+
+	return sec, nil
+}
+
+// Remove the AWS credentials secret for a registry, returns the secret name
+func (c *controller) removeAWSCredentials(registry, credentialSet string, force bool) (string, error) {
+	request := credentialRequest{CredentialSet: credentialSet, SecretName: registry}
+	if !request.isValid() {
+		return "", errors.Errorf("credential set [%s] is not a valid name", credentialSet)
+	}
+	if _, _, err := parseECRRegistry(registry); err != nil {
+		return "", err
+	}
+
+	if !force {
+		nss, err := c.K8S.GetNamespaces()
+		if err != nil {
+			return "", errors.Wrap(err, "get namespaces failed")
+		}
+		requesting := []string{}
+		for _, ns := range nss.Items {
+			if _, ok := ns.Labels[registry]; ok && getNamespaceCredentialSet(ns) == credentialSet {
+				requesting = append(requesting, ns.Name)
+			}
+		}
+		if len(requesting) > 0 {
+			sort.Strings(requesting)
+			return "", errors.Errorf("namespaces [%s] still request registry [%s], use force to remove anyway", strings.Join(requesting, ","), registry)
+		}
+	}
+
+	ns, name := c.Config.HostNamespace, request.getAWSCredentialsSecretName(c.Config.AWSCredentialsSecretPrefix)
+	if err := c.K8S.DeleteSecret(ns, name); err != nil {
+		if k8serr.IsNotFound(err) {
+			return "", errors.Errorf("namespace [%s] AWS credentials secret [%s] was not found", ns, name)
+		}
+		return "", errors.Wrapf(err, "delete namespace [%s] AWS credentials secret [%s] failed", ns, name)
+	}
+
+	return name, nil
+}
+
+// Write the host namespace AWS credentials secrets, those we labelled and those with the prefix, so secrets created by the old script are included
+// Only the last 4 characters of the access key Id are shown
+func (c *controller) writeAWSCredentials(w io.Writer) error {
+	secrets, err := c.K8S.GetSecrets(c.Config.HostNamespace)
+	if err != nil {
+		return errors.Wrapf(err, "get namespace [%s] secrets failed", c.Config.HostNamespace)
+	}
+	sort.Slice(secrets.Items, func(i, j int) bool { return secrets.Items[i].Name < secrets.Items[j].Name })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SECRET\tREGISTRY\tCREDENTIAL SET\tACCESS KEY ID\tROTATED AT")
+	for _, sec := range secrets.Items {
+		if sec.Labels[awsCredentialsLabelKey] != "true" && !strings.HasPrefix(sec.Name, c.Config.AWSCredentialsSecretPrefix+"-") {
+			continue
+		}
+		registry, credentialSet, rotatedAt := "-", "-", "-"
+		if value, ok := sec.Annotations[registryAnnotationKey]; ok {
+			registry = value
+		}
+		if value, ok := sec.Labels[credentialSetLabelKey]; ok {
+			credentialSet = value
+		}
+		if value, ok := sec.Annotations[rotatedAtAnnotationKey]; ok {
+			rotatedAt = value
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", sec.Name, registry, credentialSet, maskAccessKeyID(string(sec.Data["aws_access_key_id"])), rotatedAt)
+	}
+	tw.Flush()
+
+	return nil
+}
+
+func maskAccessKeyID(id string) string {
+	if len(id) <= 4 {
+		return "****"
+	}
+
+	return "****" + id[len(id)-4:]
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAWSCredentials(t *testing.T) {
+	for _, tc := range []struct {
+		Name            string         // Test case name
+		AccessKeyID     string         // Access key Id flag
+		SecretAccessKey string         // Secret access key flag
+		UseStdin        bool           // Stdin flag
+		Stdin           string         // Stdin content
+		ExpectedError   bool           // Expect an error
+		ExpectedCreds   awsCredentials // Expected credentials
+	}{
+		{
+			Name:            "Flags",
+			AccessKeyID:     "AKIA1",
+			SecretAccessKey: "secret1",
+			ExpectedCreds:   awsCredentials{AccessKeyID: "AKIA1", SecretAccessKey: "secret1"},
+		},
+		{
+			Name:          "Stdin create access key output",
+			UseStdin:      true,
+			Stdin:         `{"AccessKey": {"UserName": "ecr-puller", "AccessKeyId": "AKIA1", "Status": "Active", "SecretAccessKey": "secret1"}}`,
+			ExpectedCreds: awsCredentials{AccessKeyID: "AKIA1", SecretAccessKey: "secret1"},
+		},
+		{
+			Name:          "Stdin fields",
+			UseStdin:      true,
+			Stdin:         "AKIA1\nsecret1\n",
+			ExpectedCreds: awsCredentials{AccessKeyID: "AKIA1", SecretAccessKey: "secret1"},
+		},
+		{
+			Name:          "Stdin incomplete",
+			UseStdin:      true,
+			Stdin:         "AKIA1",
+			ExpectedError: true,
+		},
+		{
+			Name:            "Flags and stdin",
+			AccessKeyID:     "AKIA1",
+			SecretAccessKey: "secret1",
+			UseStdin:        true,
+			ExpectedError:   true,
+		},
+		{
+			Name:          "Missing secret access key",
+			AccessKeyID:   "AKIA1",
+			ExpectedError: true,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			creds, err := getAWSCredentials(tc.AccessKeyID, tc.SecretAccessKey, "", tc.UseStdin, strings.NewReader(tc.Stdin))
+			if tc.ExpectedError {
+				assert.NotNil(t, err, "Error")
+				return
+			}
+			assert.Nil(t, err, "Error")
+			assert.Equal(t, tc.ExpectedCreds, creds, "Credentials")
+		})
+	}
+}
+
+func TestAWSCredentials(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", credentialSetLabelKey: "team-a"},
+		},
+	})
+	stsClient := NewFakeSTSClient(map[string]string{"AKIA1111": "123456789012", "AKIA2222": "123456789012", "AKIA3333": "444456781111"})
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+
+	_, err = ctrl.putAWSCredentials(stsClient, ecr1, "team-a", awsCredentials{AccessKeyID: "AKIA3333", SecretAccessKey: "secret"}, false, now)
+	assert.NotNil(t, err, "Other account credentials error")
+	_, err = ctrl.putAWSCredentials(stsClient, ecr1, "team-a", awsCredentials{AccessKeyID: "AKIA9999", SecretAccessKey: "secret"}, false, now)
+	assert.NotNil(t, err, "Invalid credentials error")
+	_, err = ctrl.putAWSCredentials(stsClient, ecr1, "team-a", awsCredentials{AccessKeyID: "AKIA1111", SecretAccessKey: "secret"}, true, now)
+	assert.NotNil(t, err, "Rotate missing secret error")
+	_, err = ctrl.putAWSCredentials(stsClient, "registry.example.com", "", awsCredentials{AccessKeyID: "AKIA1111", SecretAccessKey: "secret"}, false, now)
+	assert.NotNil(t, err, "Non ECR registry error")
+
+	sec, err := ctrl.putAWSCredentials(stsClient, ecr1, "team-a", awsCredentials{AccessKeyID: "AKIA1111", SecretAccessKey: "secret"}, false, now)
+	assert.Nil(t, err, "Add error")
+	assert.Equal(t, config.AWSCredentialsSecretPrefix+"-team-a-"+ecr1, sec.Name, "Secret name")
+	assert.Equal(t, "eu-west-1", string(sec.Data["aws_region"]), "Secret region")
+	assert.Equal(t, "team-a", sec.Labels[credentialSetLabelKey], "Secret credential set label")
+	assert.Equal(t, ecr1, sec.Annotations[registryAnnotationKey], "Secret registry annotation")
+
+	// The secret must satisfy the same checks the controller makes
+	_, err = ctrl.checkCredentialRequest(credentialRequest{CredentialSet: "team-a", SecretName: ecr1})
+	assert.Nil(t, err, "Credential request check error")
+
+	_, err = ctrl.putAWSCredentials(stsClient, ecr1, "team-a", awsCredentials{AccessKeyID: "AKIA2222", SecretAccessKey: "secret"}, false, now)
+	assert.NotNil(t, err, "Add existing secret error")
+	sec, err = ctrl.putAWSCredentials(stsClient, ecr1, "team-a", awsCredentials{AccessKeyID: "AKIA2222", SecretAccessKey: "secret"}, true, now.Add(time.Hour))
+	assert.Nil(t, err, "Rotate error")
+	assert.Equal(t, "AKIA2222", string(sec.Data["aws_access_key_id"]), "Rotated access key Id")
+
+	out := &bytes.Buffer{}
+	err = ctrl.writeAWSCredentials(out)
+	assert.Nil(t, err, "List error")
+	assert.Contains(t, out.String(), "****2222", "Masked access key Id")
+	assert.Contains(t, out.String(), "2026-01-02T04:04:05Z", "Rotated at")
+	assert.NotContains(t, out.String(), "AKIA2222", "Unmasked access key Id")
+
+	_, err = ctrl.removeAWSCredentials(ecr1, "team-a", false)
+	assert.NotNil(t, err, "Remove requested credentials error")
+	name, err := ctrl.removeAWSCredentials(ecr1, "team-a", true)
+	assert.Nil(t, err, "Forced remove error")
+	assert.Equal(t, config.AWSCredentialsSecretPrefix+"-team-a-"+ecr1, name, "Removed secret name")
+	_, err = ctrl.removeAWSCredentials(ecr1, "team-a", true)
+	assert.NotNil(t, err, "Remove missing secret error")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	credentialSetLabelKey = "eatr.io/credential-set" // Namespace label selecting the AWS credentials used to create the namespace's ECR tokens, the default credentials are used if not set
+)
+
+// A request for an ECR authorization token, credential set is empty for the default credentials
+// Image pull credentials name their credentials secret explicitly, in which case the credential set is not used
+type credentialRequest struct {
+	CredentialSet             string
+	SecretName                string
+	CredentialSecretNamespace string
+	CredentialSecretName      string
+	Registry                  string // Only set if the registry is not the secret name, see getRegistry
+}
+
+// Get the AWS credentials secret name, takes the form [Prefix]-[ECRDNS] for the default credentials and [Prefix]-[CredentialSet]-[ECRDNS] for a credential set
+func (r credentialRequest) getAWSCredentialsSecretName(prefix string) string {
+	if r.CredentialSecretName != "" {
+		return r.CredentialSecretName
+	}
+	if r.CredentialSet == "" {
+		return prefix + "-" + r.SecretName
+	}
+
+	return prefix + "-" + r.CredentialSet + "-" + r.SecretName
+}
+
+// Credential set is used in the AWS credentials secret name so must be a DNS label
+func (r credentialRequest) isValid() bool {
+	return r.CredentialSet == "" || len(validation.IsDNS1123Label(r.CredentialSet)) == 0
+}
+
+// Get the registry the token is for, the secret name unless the request is for an image pull credential with a target secret name
+func (r credentialRequest) getRegistry() string {
+	if r.Registry != "" {
+		return r.Registry
+	}
+
+	return r.SecretName
+}
+
+// Get the AWS credentials secret namespace, the host namespace unless an image pull credential names another namespace
+func (r credentialRequest) getAWSCredentialsSecretNamespace(hostNamespace string) string {
+	if r.CredentialSecretNamespace != "" {
+		return r.CredentialSecretNamespace
+	}
+
+	return hostNamespace
+}
+
+func (r credentialRequest) String() string {
+	if r.CredentialSecretName != "" {
+		return r.CredentialSecretNamespace + "/" + r.CredentialSecretName + "/" + r.SecretName
+	}
+	if r.CredentialSet == "" {
+		return r.SecretName
+	}
+
+	return r.CredentialSet + "/" + r.SecretName
+}
+
+// Get the credential set a namespace has selected, empty if the namespace uses the default credentials
+func getNamespaceCredentialSet(ns corev1.Namespace) string {
+	return ns.Labels[credentialSetLabelKey]
+}
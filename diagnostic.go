@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+// TLS certificate loader which reloads the cert and key when either file changes, so rotated certificates are picked up without a restart
+// If a reload fails the last loaded certificate continues to be used
+type certificateReloader struct {
+	mutex           sync.Mutex
+	certFilePath    string
+	keyFilePath     string
+	cert            *tls.Certificate
+	certFileModTime time.Time
+	keyFileModTime  time.Time
+}
+
+func newCertificateReloader(certFilePath, keyFilePath string) (*certificateReloader, error) {
+	r := &certificateReloader{certFilePath: certFilePath, keyFilePath: keyFilePath}
+	if err := r.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Satisfies the tls.Config GetCertificate func, checked on each handshake
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := r.reloadIfChanged(); err != nil {
+		glog.Warningf("TLS certificate reload failed, using the last loaded certificate: %s\n", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.cert, nil
+}
+
+func (r *certificateReloader) reloadIfChanged() error {
+	certFileInfo, err := os.Stat(r.certFilePath)
+	if err != nil {
+		return errors.Wrapf(err, "stat TLS cert file failed [%s]", r.certFilePath)
+	}
+	keyFileInfo, err := os.Stat(r.keyFilePath)
+	if err != nil {
+		return errors.Wrapf(err, "stat TLS key file failed [%s]", r.keyFilePath)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.cert != nil && certFileInfo.ModTime().Equal(r.certFileModTime) && keyFileInfo.ModTime().Equal(r.keyFileModTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFilePath, r.keyFilePath)
+	if err != nil {
+		return errors.Wrap(err, "load TLS certificate failed")
+	}
+	glog.Infof("Loaded TLS certificate [%s]\n", r.certFilePath)
+	r.cert, r.certFileModTime, r.keyFileModTime = &cert, certFileInfo.ModTime(), keyFileInfo.ModTime()
+
+	return nil
+}
+
+// Diagnostic auth handler, authenticates the request bearer token with a TokenReview and authorises the user for the request path and verb with a SubjectAccessReview
+// Responds with 401 if the token is missing or not authenticated and 403 if the user is not authorised, the verb is the lower cased request method as used by non resource URL RBAC rules
+func newDiagnosticAuthHandler(k8s k8sInterface, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := getBearerToken(r)
+		if token == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		tr, err := k8s.CreateTokenReview(&authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}})
+		if err != nil {
+			glog.Warningf("Diagnostic auth token review failed for [%s]: %s\n", r.URL.Path, err)
+			http.Error(w, "token review failed", http.StatusInternalServerError)
+			return
+		}
+		if !tr.Status.Authenticated {
+			glog.V(detailiedGLogLevel).Infof("Diagnostic auth token not authenticated for [%s]\n", r.URL.Path)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		user := tr.Status.User
+		extra := map[string]authorizationv1.ExtraValue{}
+		for k, v := range user.Extra {
+			extra[k] = authorizationv1.ExtraValue(v)
+		}
+		verb := strings.ToLower(r.Method)
+		sar, err := k8s.CreateSubjectAccessReview(&authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:                  user.Username,
+				Groups:                user.Groups,
+				UID:                   user.UID,
+				Extra:                 extra,
+				NonResourceAttributes: &authorizationv1.NonResourceAttributes{Path: r.URL.Path, Verb: verb},
+			},
+		})
+		if err != nil {
+			glog.Warningf("Diagnostic auth subject access review failed for [%s]: %s\n", r.URL.Path, err)
+			http.Error(w, "subject access review failed", http.StatusInternalServerError)
+			return
+		}
+		if !sar.Status.Allowed {
+			glog.Infof("Diagnostic auth denied [%s] on [%s] for [%s]\n", verb, r.URL.Path, user.Username)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		glog.V(detailiedGLogLevel).Infof("Diagnostic auth allowed [%s] on [%s] for [%s]\n", verb, r.URL.Path, user.Username)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func getBearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(header, prefix))
+}
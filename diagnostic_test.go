@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+func TestDiagnosticAuth(t *testing.T) {
+	const (
+		validToken = "valid-token"
+		userName   = "system:serviceaccount:ci-cd:on-call"
+	)
+
+	for _, tc := range []struct {
+		Name               string                                 // Test case name
+		Path               string                                 // Request path
+		Method             string                                 // Request method
+		Token              string                                 // Request bearer token, no authorization header if empty
+		TokenReviewErr     error                                  // Token review error
+		AllowedPaths       map[string]string                      // Paths the user is authorised for by verb
+		ExpectedStatusCode int                                    // Expected response status code
+		ExpectedSAR        *authorizationv1.NonResourceAttributes // Expected subject access review attributes, nil if no review is expected
+	}{
+		{
+			Name:               "Metrics are open",
+			Path:               "/metrics",
+			Method:             http.MethodGet,
+			ExpectedStatusCode: http.StatusOK,
+		},
+		{
+			Name:               "Health check is open",
+			Path:               "/healthz",
+			Method:             http.MethodGet,
+			ExpectedStatusCode: http.StatusOK,
+		},
+		{
+			Name:               "Pprof with no token",
+			Path:               "/debug/pprof/",
+			Method:             http.MethodGet,
+			ExpectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			Name:               "Pprof with unauthenticated token",
+			Path:               "/debug/pprof/",
+			Method:             http.MethodGet,
+			Token:              "invalid-token",
+			ExpectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			Name:               "Pprof with token review failure",
+			Path:               "/debug/pprof/",
+			Method:             http.MethodGet,
+			Token:              validToken,
+			TokenReviewErr:     errors.New("API server is unavailable"),
+			ExpectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			Name:               "Pprof with unauthorised user",
+			Path:               "/debug/pprof/",
+			Method:             http.MethodGet,
+			Token:              validToken,
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedSAR:        &authorizationv1.NonResourceAttributes{Path: "/debug/pprof/", Verb: "get"},
+		},
+		{
+			Name:               "Pprof with authorised user",
+			Path:               "/debug/pprof/",
+			Method:             http.MethodGet,
+			Token:              validToken,
+			AllowedPaths:       map[string]string{"/debug/pprof/": "get"},
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedSAR:        &authorizationv1.NonResourceAttributes{Path: "/debug/pprof/", Verb: "get"},
+		},
+		{
+			Name:               "Admin renew with user only authorised for status",
+			Path:               "/renew",
+			Method:             http.MethodPost,
+			Token:              validToken,
+			AllowedPaths:       map[string]string{"/status": "get"},
+			ExpectedStatusCode: http.StatusForbidden,
+			ExpectedSAR:        &authorizationv1.NonResourceAttributes{Path: "/renew", Verb: "post"},
+		},
+		{
+			Name:               "Admin renew with authorised user",
+			Path:               "/renew",
+			Method:             http.MethodPost,
+			Token:              validToken,
+			AllowedPaths:       map[string]string{"/renew": "post"},
+			ExpectedStatusCode: http.StatusAccepted,
+			ExpectedSAR:        &authorizationv1.NonResourceAttributes{Path: "/renew", Verb: "post"},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			config := getDefaultConfig()
+			config.AdminAPI = true
+			config.DiagnosticAuth = true
+			k8sClient := NewFakeK8SClient(nil)
+			k8sClient.CreateTokenReviewFn = func(tr *authenticationv1.TokenReview) (*authenticationv1.TokenReview, error) {
+				if tc.TokenReviewErr != nil {
+					return nil, tc.TokenReviewErr
+				}
+				if tr.Spec.Token == validToken {
+					tr.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: userName}}
+				}
+				return tr, nil
+			}
+			var actualSAR *authorizationv1.SubjectAccessReview
+			k8sClient.CreateSubjectAccessReviewFn = func(sar *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+				actualSAR = sar
+				attrs := sar.Spec.NonResourceAttributes
+				sar.Status.Allowed = sar.Spec.User == userName && attrs != nil && tc.AllowedPaths[attrs.Path] == attrs.Verb
+				return sar, nil
+			}
+
+			promRegistry := prometheus.NewRegistry()
+			ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: NewFakeSharedInformer(), HostSecret: NewFakeSharedInformer()}, promRegistry, NewFakeECRClient())
+			assert.Nil(t, err, "New controller error")
+			ctrl.Health.recordCachesSynced()
+			ctrl.Health.recordConsumerRunning(true)
+			diagSrv, err := newDiagnosticHTTPServer(promRegistry, ctrl)
+			assert.Nil(t, err, "New diagnostic HTTP server error")
+			srv := httptest.NewServer(diagSrv.Handler)
+			defer srv.Close()
+
+			req, _ := http.NewRequest(tc.Method, srv.URL+tc.Path, nil)
+			if tc.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.Token)
+			}
+			res, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err, "Request error")
+			defer res.Body.Close()
+			assert.Equal(t, tc.ExpectedStatusCode, res.StatusCode, "Status code")
+			if tc.ExpectedSAR == nil {
+				assert.Nil(t, actualSAR, "Subject access review")
+				return
+			}
+			if assert.NotNil(t, actualSAR, "Subject access review") {
+				assert.Equal(t, userName, actualSAR.Spec.User, "Subject access review user")
+				assert.Equal(t, tc.ExpectedSAR, actualSAR.Spec.NonResourceAttributes, "Subject access review attributes")
+			}
+		})
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eatr")
+	assert.Nil(t, err, "Temp dir error")
+	defer os.RemoveAll(dir)
+	certFilePath, keyFilePath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	_, err = newCertificateReloader(certFilePath, keyFilePath)
+	assert.NotNil(t, err, "New certificate reloader error with no files")
+
+	writeTestCertificate(t, certFilePath, keyFilePath, "first")
+	reloader, err := newCertificateReloader(certFilePath, keyFilePath)
+	assert.Nil(t, err, "New certificate reloader error")
+	assert.Equal(t, "first", getTestCertificateCommonName(t, reloader), "Initial certificate")
+
+	// Ensure the mod time changes on file systems with a coarse mod time resolution
+	writeTestCertificate(t, certFilePath, keyFilePath, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFilePath, later, later)
+	os.Chtimes(keyFilePath, later, later)
+	assert.Equal(t, "second", getTestCertificateCommonName(t, reloader), "Reloaded certificate")
+
+	assert.Nil(t, ioutil.WriteFile(certFilePath, []byte("not a certificate"), 0600), "Write invalid cert file error")
+	evenLater := later.Add(time.Minute)
+	os.Chtimes(certFilePath, evenLater, evenLater)
+	assert.Equal(t, "second", getTestCertificateCommonName(t, reloader), "Certificate after failed reload")
+}
+
+func writeTestCertificate(t *testing.T, certFilePath, keyFilePath, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err, "Generate key error")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err, "Create certificate error")
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err, "Marshal key error")
+
+	assert.Nil(t, ioutil.WriteFile(certFilePath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600), "Write cert file error")
+	assert.Nil(t, ioutil.WriteFile(keyFilePath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600), "Write key file error")
+}
+
+func getTestCertificateCommonName(t *testing.T, reloader *certificateReloader) string {
+	cert, err := reloader.GetCertificate(nil)
+	assert.Nil(t, err, "Get certificate error")
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.Nil(t, err, "Parse certificate error")
+
+	return parsed.Subject.CommonName
+}
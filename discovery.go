@@ -0,0 +1,219 @@
+package main
+
+import (
+	"path"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Warnings recorded for discovered registries that have no ECR authorization token, keyed by namespace then registry with the event reason
+// Discovered registries are requested on every renewal, so the warning event is only recorded when the reason changes rather than on every renewal
+type discoveryWarnings struct {
+	mutex   sync.Mutex
+	reasons map[string]map[string]string
+}
+
+func newDiscoveryWarnings() *discoveryWarnings {
+	return &discoveryWarnings{reasons: map[string]map[string]string{}}
+}
+
+// Record the namespace registry warning reason, returns true if the reason changed so the warning event should be recorded
+func (w *discoveryWarnings) changed(nsName, registry, reason string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if _, ok := w.reasons[nsName]; !ok {
+		w.reasons[nsName] = map[string]string{}
+	}
+	if w.reasons[nsName][registry] == reason {
+		return false
+	}
+	w.reasons[nsName][registry] = reason
+
+	return true
+}
+
+// Clear the namespace registry warning, so a later failure is warned about again
+func (w *discoveryWarnings) clear(nsName, registry string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	delete(w.reasons[nsName], registry)
+}
+
+// Remove the namespace's warnings for registries it no longer requests
+func (w *discoveryWarnings) retain(nsName string, registries sets.String) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for registry := range w.reasons[nsName] {
+		if !registries.Has(registry) {
+			delete(w.reasons[nsName], registry)
+		}
+	}
+	if len(w.reasons[nsName]) == 0 {
+		delete(w.reasons, nsName)
+	}
+}
+
+// Discover the ECR registries namespaces pull from by examining pod, and optionally workload, image references
+// Namespace name can be metav1.NamespaceAll, only namespaces that are eligible for discovery are included
+// Pods and workloads are read from the informers' stores, the API is only listed when there are no informers i.e. renewing once
+func (c *controller) discoverRegistries(nsName string) (map[string]sets.String, error) {
+	res := map[string]sets.String{}
+	add := func(namespace string, spec *corev1.PodSpec) {
+		if !isDiscoveryNamespace(c.Config, namespace) {
+			return
+		}
+		for _, host := range getPodSpecECRRegistryHosts(spec) {
+			if _, ok := res[namespace]; !ok {
+				res[namespace] = sets.NewString()
+			}
+			res[namespace].Insert(host)
+		}
+	}
+	addStore := func(store cache.Store) {
+		for _, obj := range store.List() {
+			if namespace, spec, ok := getObjectPodSpec(obj); ok && (nsName == metav1.NamespaceAll || namespace == nsName) {
+				add(namespace, spec)
+			}
+		}
+	}
+
+	if c.Informers.Pod != nil {
+		addStore(c.Informers.Pod.GetStore())
+	} else {
+		glog.V(detailiedGLogLevel).Infof("Getting namespace [%s] pods for registry discovery\n", nsName)
+		pods, err := c.K8S.GetPods(nsName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get namespace [%s] pods failed", nsName)
+		}
+		for i := range pods.Items {
+			add(pods.Items[i].Namespace, &pods.Items[i].Spec)
+		}
+	}
+
+	if !c.Config.DiscoveryIncludeWorkloads {
+		return res, nil
+	}
+	if len(c.Informers.Workloads) > 0 {
+		for _, informer := range c.Informers.Workloads {
+			addStore(informer.GetStore())
+		}
+		return res, nil
+	}
+
+	glog.V(detailiedGLogLevel).Infof("Getting namespace [%s] workloads for registry discovery\n", nsName)
+	deployments, err := c.K8S.GetDeployments(nsName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get namespace [%s] deployments failed", nsName)
+	}
+	for i := range deployments.Items {
+		add(deployments.Items[i].Namespace, &deployments.Items[i].Spec.Template.Spec)
+	}
+
+	statefulSets, err := c.K8S.GetStatefulSets(nsName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get namespace [%s] stateful sets failed", nsName)
+	}
+	for i := range statefulSets.Items {
+		add(statefulSets.Items[i].Namespace, &statefulSets.Items[i].Spec.Template.Spec)
+	}
+
+	cronJobs, err := c.K8S.GetCronJobs(nsName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get namespace [%s] cron jobs failed", nsName)
+	}
+	for i := range cronJobs.Items {
+		add(cronJobs.Items[i].Namespace, &cronJobs.Items[i].Spec.JobTemplate.Spec.Template.Spec)
+	}
+
+	return res, nil
+}
+
+// Is the namespace eligible for discovery, an empty allowlist means all namespaces are eligible, allowlist entries can be patterns, see path.Match
+func isDiscoveryNamespace(config config, nsName string) bool {
+	allowed := splitNames(config.DiscoveryNamespaces)
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, pattern := range allowed {
+		if matched, _ := path.Match(pattern, nsName); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resource event handler for discovery informers, enqueues the namespace when a pod or workload that uses an ECR registry is added or its spec changes
+func (c *controller) newDiscoveryEventHandler() cache.ResourceEventHandler {
+	enqueue := func(obj interface{}) {
+		namespace, spec, ok := getObjectPodSpec(obj)
+		if !ok || len(getPodSpecECRRegistryHosts(spec)) == 0 {
+			return
+		}
+		c.ConfigMutex.RLock()
+		eligible := isDiscoveryNamespace(c.Config, namespace)
+		c.ConfigMutex.RUnlock()
+		if !eligible {
+			return
+		}
+		glog.V(detailiedGLogLevel).Infof("Discovered ECR registry usage in ns [%s]\n", namespace)
+		c.Queue.Add(namespace)
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			_, oldSpec, _ := getObjectPodSpec(oldObj)
+			_, newSpec, ok := getObjectPodSpec(newObj)
+			if ok && !sets.NewString(getPodSpecECRRegistryHosts(oldSpec)...).Equal(sets.NewString(getPodSpecECRRegistryHosts(newSpec)...)) {
+				enqueue(newObj)
+			}
+		},
+	}
+}
+
+// Get the namespace and pod spec for a pod or workload
+func getObjectPodSpec(obj interface{}) (string, *corev1.PodSpec, bool) {
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return o.Namespace, &o.Spec, true
+	case *appsv1.Deployment:
+		return o.Namespace, &o.Spec.Template.Spec, true
+	case *appsv1.StatefulSet:
+		return o.Namespace, &o.Spec.Template.Spec, true
+	case *batchv1beta1.CronJob:
+		return o.Namespace, &o.Spec.JobTemplate.Spec.Template.Spec, true
+	}
+
+	return "", nil, false
+}
+
+// Get the distinct ECR registry hosts used by the pod spec's containers and init containers
+func getPodSpecECRRegistryHosts(spec *corev1.PodSpec) []string {
+	hosts := sets.NewString()
+	if spec == nil {
+		return hosts.List()
+	}
+
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		if host := getImageRegistryHost(container.Image); namespaceSecretLabelKeyRegEx.MatchString(host) {
+			hosts.Insert(host)
+		}
+	}
+
+	return hosts.List()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/pkg/errors"
+)
+
+// Docker config json file format, see ~/.docker/config.json
+// Entries are kept as raw json so replicated secret entries (username, password, email etc.) are copied verbatim
+type dockerConfigJSON struct {
+	Auths map[string]json.RawMessage `json:"auths"`
+}
+
+type dockerConfigJSONAuth struct {
+	Auth string `json:"auth"`
+}
+
+func newDockerConfigJSON() *dockerConfigJSON {
+	return &dockerConfigJSON{Auths: map[string]json.RawMessage{}}
+}
+
+// Add an ECR authorization token entry, the token is already base64 encoded user:password so can be used as is
+func (d *dockerConfigJSON) addAuthToken(authTokenData *ecr.AuthorizationData) {
+	endpoint := *(*authTokenData).ProxyEndpoint
+	password := *(*authTokenData).AuthorizationToken
+
+	// Cannot fail, a struct with a single string field
+	entry, _ := json.Marshal(dockerConfigJSONAuth{Auth: password})
+	d.Auths[endpoint] = entry
+}
+
+// Merge the entries from existing docker config json secret data, existing entries for the same registry are replaced
+func (d *dockerConfigJSON) mergeSecretData(data []byte) error {
+	other := newDockerConfigJSON()
+	if err := json.Unmarshal(data, other); err != nil {
+		return errors.Wrap(err, "unmarshal docker config json failed")
+	}
+
+	for k, v := range other.Auths {
+		d.Auths[k] = v
+	}
+
+	return nil
+}
+
+func (d *dockerConfigJSON) marshal() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+// Marshal in the legacy ~/.dockercfg format, which is the auths map without the wrapping object
+func (d *dockerConfigJSON) marshalDockerCfg() ([]byte, error) {
+	return json.Marshal(d.Auths)
+}
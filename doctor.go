@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+// Doctor command, checks the config, the host namespace, the RBAC permissions the enabled features need and the AWS credentials secrets, exits non zero if any check fails
+// Permissions are checked for the current user unless a service account is given, so can be run as the eatr service account or from a laptop with a kube config
+func runDoctorCommand(args []string) error {
+	var fetchTokens bool
+	var serviceAccount string
+	config, err := getConfig(args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&fetchTokens, "fetch-tokens", false, "Fetch tokens - If set an ECR authorization token is also requested with each AWS credentials secret")
+		fs.StringVar(&serviceAccount, "service-account", "", "Service account to check the RBAC permissions for, in the form [Namespace]:[Name], i.e. ci-cd:eatr, the current user's permissions are checked if not set")
+	})
+	if err != nil {
+		return errors.Wrap(err, "getConfig failed")
+	}
+	// Validated before any checks run, so a malformed service account does not lose the results of the checks already made
+	if _, _, err := parseServiceAccount(serviceAccount); err != nil {
+		return err
+	}
+
+	results := getConfigChecks(config)
+	ctrl, err := newCommandController(config)
+	if err != nil {
+		writeCheckResults(os.Stdout, results)
+		return err
+	}
+
+	results = append(results, ctrl.getHostNamespaceCheck())
+	permissionResults, err := ctrl.getPermissionChecks(serviceAccount)
+	if err != nil {
+		writeCheckResults(os.Stdout, results)
+		return err
+	}
+	results = append(results, permissionResults...)
+	credentialResults, err := ctrl.getCredentialChecks(fetchTokens)
+	if err != nil {
+		results = append(results, checkResult{Category: "credentials", Name: "get credential requests", Err: err})
+	}
+	results = append(results, credentialResults...)
+
+	failed := writeCheckResults(os.Stdout, results)
+	fmt.Fprintf(os.Stdout, "\n%d checks, %d failed\n", len(results), failed)
+	if failed > 0 {
+		return errors.Errorf("%d checks failed", failed)
+	}
+
+	return nil
+}
+
+// Check the host namespace exists, also checks we can connect to the cluster
+func (c *controller) getHostNamespaceCheck() checkResult {
+	_, err := c.K8S.GetNamespace(c.Config.HostNamespace)
+	if err != nil {
+		err = errors.Wrapf(err, "get host namespace [%s] failed", c.Config.HostNamespace)
+	}
+
+	return checkResult{Category: "cluster", Name: fmt.Sprintf("host namespace [%s] exists", c.Config.HostNamespace), Err: err}
+}
+
+// Get a check result for each verb of each permission the enabled features need
+// Uses a self subject access review for the current user, or a subject access review if a service account is given
+func (c *controller) getPermissionChecks(serviceAccount string) ([]checkResult, error) {
+	saNamespace, saName, err := parseServiceAccount(serviceAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []checkResult{}
+	for _, permission := range getRequiredPermissions(c.Config) {
+		for _, verb := range permission.Verbs {
+			attrs := &authorizationv1.ResourceAttributes{Namespace: permission.Namespace, Verb: verb, Group: permission.Group, Resource: permission.Resource}
+			allowed, err := c.isAllowed(attrs, saNamespace, saName)
+			if err == nil && !allowed {
+				err = errors.Errorf("not allowed, needed to %s", permission.Reason)
+			}
+			res = append(res, checkResult{Category: "rbac", Name: getPermissionCheckName(permission, verb), Err: err})
+		}
+	}
+
+	return res, nil
+}
+
+// Parse a [Namespace]:[Name] service account, empty is allowed and means the current user
+func parseServiceAccount(serviceAccount string) (string, string, error) {
+	if serviceAccount == "" {
+		return "", "", nil
+	}
+	parts := strings.Split(serviceAccount, ":")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.Errorf("service account [%s] must be in the form [Namespace]:[Name]", serviceAccount)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func (c *controller) isAllowed(attrs *authorizationv1.ResourceAttributes, saNamespace, saName string) (bool, error) {
+	if saName == "" {
+		ssar, err := c.K8S.CreateSelfSubjectAccessReview(&authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: attrs},
+		})
+		if err != nil {
+			return false, errors.Wrap(err, "self subject access review failed")
+		}
+		return ssar.Status.Allowed, nil
+	}
+
+	sar, err := c.K8S.CreateSubjectAccessReview(&authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: attrs,
+			User:               "system:serviceaccount:" + saNamespace + ":" + saName,
+			Groups:             []string{"system:serviceaccounts", "system:serviceaccounts:" + saNamespace, "system:authenticated"},
+		},
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "subject access review failed")
+	}
+	return sar.Status.Allowed, nil
+}
+
+// Get the permission check name, i.e. list deployments.apps cluster wide
+func getPermissionCheckName(permission requiredPermission, verb string) string {
+	resource := permission.Resource
+	if permission.Group != "" {
+		resource += "." + permission.Group
+	}
+	if permission.Namespace == "" {
+		return fmt.Sprintf("%s %s cluster wide", verb, resource)
+	}
+
+	return fmt.Sprintf("%s %s in namespace [%s]", verb, resource, permission.Namespace)
+}
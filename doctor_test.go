@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDoctorPermissionChecks(t *testing.T) {
+	for _, tc := range []struct {
+		Name            string   // Test case name
+		ServiceAccount  string   // Service account to check, empty for the current user
+		DeniedVerbs     []string // Verbs the reviews do not allow
+		ExpectedError   bool     // Expect the checks to error
+		ExpectedFailed  int      // Expected number of failed checks
+		ExpectedOutputs []string // Expected output fragments
+	}{
+		{
+			Name:            "Current user allowed everything",
+			ExpectedOutputs: []string{"PASS  rbac  list namespaces cluster wide", "PASS  rbac  watch secrets in namespace [ci-cd]", "PASS  rbac  patch events cluster wide"},
+		},
+		{
+			Name:            "Current user cannot delete",
+			DeniedVerbs:     []string{"delete"},
+			ExpectedFailed:  1,
+			ExpectedOutputs: []string{"FAIL  rbac  delete secrets cluster wide  not allowed"},
+		},
+		{
+			Name:            "Service account cannot watch",
+			ServiceAccount:  "ci-cd:eatr",
+			DeniedVerbs:     []string{"watch"},
+			ExpectedFailed:  2,
+			ExpectedOutputs: []string{"FAIL  rbac  watch namespaces cluster wide", "FAIL  rbac  watch secrets in namespace [ci-cd]"},
+		},
+		{
+			Name:           "Invalid service account",
+			ServiceAccount: "eatr",
+			ExpectedError:  true,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			config := getDefaultConfig()
+			k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{{Name: config.HostNamespace, IsActive: true}})
+			isAllowed := func(attrs *authorizationv1.ResourceAttributes) bool {
+				for _, verb := range tc.DeniedVerbs {
+					if attrs.Verb == verb {
+						return false
+					}
+				}
+				return true
+			}
+			k8sClient.CreateSelfSubjectAccessReviewFn = func(ssar *authorizationv1.SelfSubjectAccessReview) (*authorizationv1.SelfSubjectAccessReview, error) {
+				assert.Empty(t, tc.ServiceAccount, "Self subject access review used for a service account")
+				ssar.Status.Allowed = isAllowed(ssar.Spec.ResourceAttributes)
+				return ssar, nil
+			}
+			k8sClient.CreateSubjectAccessReviewFn = func(sar *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+				assert.Equal(t, "system:serviceaccount:"+tc.ServiceAccount, sar.Spec.User, "Subject access review user")
+				sar.Status.Allowed = isAllowed(sar.Spec.ResourceAttributes)
+				return sar, nil
+			}
+
+			ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+			assert.Nil(t, err, "New controller error")
+
+			results, err := ctrl.getPermissionChecks(tc.ServiceAccount)
+			if tc.ExpectedError {
+				assert.NotNil(t, err, "Checks error")
+				return
+			}
+			assert.Nil(t, err, "Checks error")
+
+			out := &bytes.Buffer{}
+			assert.Equal(t, tc.ExpectedFailed, writeCheckResults(out, results), "Failed checks")
+			for _, expected := range tc.ExpectedOutputs {
+				assert.Contains(t, out.String(), expected, "Output")
+			}
+		})
+	}
+}
+
+func TestDoctorChecks(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1, config.AWSCredentialsSecretPrefix + "-" + ecr2},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", ecr2: "true"},
+		},
+	})
+	k8sClient.UpdateSecret(config.HostNamespace, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: config.AWSCredentialsSecretPrefix + "-" + ecr1},
+		Data:       map[string][]byte{"aws_access_key_id": []byte("id"), "aws_region": []byte("eu-west-1"), "aws_secret_access_key": []byte("secret")},
+	})
+	k8sClient.UpdateSecret(config.HostNamespace, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: config.AWSCredentialsSecretPrefix + "-" + ecr2},
+		Data:       map[string][]byte{"aws_access_key_id": []byte("id"), "aws_region": []byte("us-east-1"), "aws_secret_access_key": []byte("secret")},
+	})
+
+	ecrClient := NewFakeECRClient()
+	getAuthToken := ecrClient.GetAuthTokenFn
+	ecrClient.GetAuthTokenFn = func(ctx context.Context, region, id, secret string) (*ecr.AuthorizationData, error) {
+		if region == "us-east-1" {
+			return nil, errors.New("access denied")
+		}
+		return getAuthToken(ctx, region, id, secret)
+	}
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), ecrClient)
+	assert.Nil(t, err, "New controller error")
+
+	results := []checkResult{ctrl.getHostNamespaceCheck()}
+	credentialResults, err := ctrl.getCredentialChecks(true)
+	assert.Nil(t, err, "Credential checks error")
+	results = append(results, credentialResults...)
+
+	out := &bytes.Buffer{}
+	assert.Equal(t, 1, writeCheckResults(out, results), "Failed checks")
+	assert.Contains(t, out.String(), "PASS  cluster  host namespace [ci-cd] exists", "Host namespace")
+	assert.Contains(t, out.String(), "PASS  ecr  "+ecr1, "Token fetched")
+	assert.Contains(t, out.String(), "FAIL  ecr  "+ecr2, "Token fetch failed")
+	assert.Contains(t, out.String(), "access denied", "Token fetch error")
+}
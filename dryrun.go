@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	plannedChangeActionAdopt  = "adopt" // Update of an existing pre-upgrade secret we do not yet manage, we would take it over
+	plannedChangeActionCreate = "create"
+	plannedChangeActionDelete = "delete"
+	plannedChangeActionUpdate = "update"
+)
+
+// A change the controller would have made if not in dry run mode, changes never include secret values
+type plannedChange struct {
+	Action    string   `json:"action"`
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Changes   []string `json:"changes,omitempty"`
+}
+
+// K8S client decorator for dry run mode, reads are passed through, writes are recorded as planned changes and not made
+// Events and the status config map are dropped as they only report on the writes we did not make
+// The plan has the latest planned change per object, so repeated renewals do not grow it
+type recordingK8SClient struct {
+	k8sInterface
+	mutex   sync.Mutex
+	changes []plannedChange
+	index   map[string]int // Kind, namespace and name key to changes index
+}
+
+func newRecordingK8SClient(k8sClient k8sInterface) *recordingK8SClient {
+	return &recordingK8SClient{k8sInterface: k8sClient, index: map[string]int{}}
+}
+
+func (k *recordingK8SClient) CreateConfigMap(ns string, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	glog.V(detailiedGLogLevel).Infof("Dry run, not creating namespace [%s] config map [%s]\n", ns, cm.Name)
+	return cm, nil
+}
+
+func (k *recordingK8SClient) CreateEvent(ns string, e *corev1.Event) (*corev1.Event, error) {
+	glog.V(detailiedGLogLevel).Infof("Dry run, not creating namespace [%s] event [%s]\n", ns, e.Reason)
+	return e, nil
+}
+
+func (k *recordingK8SClient) CreateSecret(ns string, s *corev1.Secret) (*corev1.Secret, error) {
+	res := s.DeepCopy()
+	res.Namespace = ns
+	k.record(plannedChange{Action: plannedChangeActionCreate, Kind: "Secret", Namespace: ns, Name: s.Name, Changes: getSecretChanges(nil, s)})
+	return res, nil
+}
+
+func (k *recordingK8SClient) DeleteConfigMap(ns, name string) error {
+	k.record(plannedChange{Action: plannedChangeActionDelete, Kind: "ConfigMap", Namespace: ns, Name: name})
+	return nil
+}
+
+func (k *recordingK8SClient) DeletePod(ns, name string) error {
+	k.record(plannedChange{Action: plannedChangeActionDelete, Kind: "Pod", Namespace: ns, Name: name})
+	return nil
+}
+
+func (k *recordingK8SClient) DeleteSecret(ns, name string) error {
+	k.record(plannedChange{Action: plannedChangeActionDelete, Kind: "Secret", Namespace: ns, Name: name})
+	return nil
+}
+
+func (k *recordingK8SClient) PatchEvent(ns, name string, data []byte) (*corev1.Event, error) {
+	glog.V(detailiedGLogLevel).Infof("Dry run, not patching namespace [%s] event [%s]\n", ns, name)
+	return &corev1.Event{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name}}, nil
+}
+
+func (k *recordingK8SClient) UpdateConfigMap(ns string, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	glog.V(detailiedGLogLevel).Infof("Dry run, not updating namespace [%s] config map [%s]\n", ns, cm.Name)
+	return cm, nil
+}
+
+func (k *recordingK8SClient) UpdateEvent(ns string, e *corev1.Event) (*corev1.Event, error) {
+	glog.V(detailiedGLogLevel).Infof("Dry run, not updating namespace [%s] event [%s]\n", ns, e.Reason)
+	return e, nil
+}
+
+func (k *recordingK8SClient) UpdateNamespace(ns *corev1.Namespace) (*corev1.Namespace, error) {
+	existing, err := k.k8sInterface.GetNamespace(ns.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get namespace [%s] failed", ns.Name)
+	}
+
+	changes := getStringMapChanges("label", existing.Labels, ns.Labels)
+	removed := []string{}
+	for key := range existing.Labels {
+		if _, ok := ns.Labels[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	for _, key := range removed {
+		changes = append(changes, fmt.Sprintf("label [%s] removed", key))
+	}
+	k.record(plannedChange{Action: plannedChangeActionUpdate, Kind: "Namespace", Namespace: ns.Name, Name: ns.Name, Changes: changes})
+	return ns, nil
+}
+
+func (k *recordingK8SClient) UpdateSecret(ns string, s *corev1.Secret) (*corev1.Secret, error) {
+	existing, err := k.k8sInterface.GetSecret(ns, s.Name)
+	if err != nil {
+		if !k8serr.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "get namespace [%s] secret [%s] failed", ns, s.Name)
+		}
+		existing = nil
+	}
+
+	action := plannedChangeActionUpdate
+	if existing != nil && existing.Labels[managedByLabelKey] != managedByLabelValue {
+		action = plannedChangeActionAdopt
+	}
+	res := s.DeepCopy()
+	res.Namespace = ns
+	k.record(plannedChange{Action: action, Kind: "Secret", Namespace: ns, Name: s.Name, Changes: getSecretChanges(existing, s)})
+	return res, nil
+}
+
+func (k *recordingK8SClient) UpdateServiceAccount(ns string, sa *corev1.ServiceAccount) (*corev1.ServiceAccount, error) {
+	list, err := k.k8sInterface.GetServiceAccounts(ns)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get namespace [%s] service accounts failed", ns)
+	}
+
+	previous := []string{}
+	for _, existing := range list.Items {
+		if existing.Name == sa.Name {
+			previous = getImagePullSecretNames(existing.ImagePullSecrets)
+		}
+	}
+	changes := []string{}
+	if desired := getImagePullSecretNames(sa.ImagePullSecrets); strings.Join(previous, ",") != strings.Join(desired, ",") {
+		changes = append(changes, fmt.Sprintf("imagePullSecrets [%s] -> [%s]", strings.Join(previous, ","), strings.Join(desired, ",")))
+	}
+	k.record(plannedChange{Action: plannedChangeActionUpdate, Kind: "ServiceAccount", Namespace: ns, Name: sa.Name, Changes: changes})
+	return sa, nil
+}
+
+func (k *recordingK8SClient) record(change plannedChange) {
+	glog.Infof("Dry run, would %s namespace [%s] %s [%s] %s\n", change.Action, change.Namespace, strings.ToLower(change.Kind), change.Name, strings.Join(change.Changes, ", "))
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	key := change.Kind + "/" + change.Namespace + "/" + change.Name
+	if i, ok := k.index[key]; ok {
+		k.changes[i] = change
+		return
+	}
+	k.index[key] = len(k.changes)
+	k.changes = append(k.changes, change)
+}
+
+// Get the planned changes sorted by namespace, kind and name
+func (k *recordingK8SClient) plan() []plannedChange {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	res := make([]plannedChange, len(k.changes))
+	copy(res, k.changes)
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Namespace != res[j].Namespace {
+			return res[i].Namespace < res[j].Namespace
+		}
+		if res[i].Kind != res[j].Kind {
+			return res[i].Kind < res[j].Kind
+		}
+		return res[i].Name < res[j].Name
+	})
+
+	return res
+}
+
+// Get the differences between an existing secret, nil if creating, and the desired secret, data values are never included
+func getSecretChanges(existing, desired *corev1.Secret) []string {
+	if existing == nil {
+		existing = &corev1.Secret{}
+	}
+
+	changes := []string{}
+	if existing.Type != desired.Type {
+		changes = append(changes, fmt.Sprintf("type [%s] -> [%s]", existing.Type, desired.Type))
+	}
+	changes = append(changes, getStringMapChanges("label", existing.Labels, desired.Labels)...)
+	changes = append(changes, getStringMapChanges("annotation", existing.Annotations, desired.Annotations)...)
+	keys := []string{}
+	for key := range desired.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !bytes.Equal(existing.Data[key], desired.Data[key]) {
+			changes = append(changes, fmt.Sprintf("data [%s] changed", key))
+		}
+	}
+
+	return changes
+}
+
+// Get the desired map entries that differ, entries we do not set are left alone so are not reported
+func getStringMapChanges(name string, existing, desired map[string]string) []string {
+	keys := []string{}
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changes := []string{}
+	for _, key := range keys {
+		if value, ok := existing[key]; !ok || value != desired[key] {
+			changes = append(changes, fmt.Sprintf("%s [%s] [%s] -> [%s]", name, key, value, desired[key]))
+		}
+	}
+
+	return changes
+}
+
+func getImagePullSecretNames(refs []corev1.LocalObjectReference) []string {
+	names := []string{}
+	for _, ref := range refs {
+		names = append(names, ref.Name)
+	}
+
+	return names
+}
+
+// Write the plan diff style, + for create, ~ for update, ! for adopt and - for delete, with the changes indented below
+func writePlan(w io.Writer, changes []plannedChange) {
+	symbols := map[string]string{
+		plannedChangeActionAdopt:  "!",
+		plannedChangeActionCreate: "+",
+		plannedChangeActionDelete: "-",
+		plannedChangeActionUpdate: "~",
+	}
+
+	fmt.Fprintf(w, "Dry run plan, %d changes\n", len(changes))
+	for _, change := range changes {
+		fmt.Fprintf(w, "%s %s %s %s/%s\n", symbols[change.Action], change.Action, strings.ToLower(change.Kind), change.Namespace, change.Name)
+		for _, c := range change.Changes {
+			fmt.Fprintf(w, "    %s\n", c)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDryRun(t *testing.T) {
+	config := getDefaultConfig()
+	config.AdminAPI = true
+	config.DryRun = true
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true"},
+		},
+		{
+			Name:     ns2,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true"},
+		},
+		{
+			Name:              ns3,
+			IsActive:          true,
+			Labels:            map[string]string{ecr1: "true"},
+			PreUpgradeSecrets: []string{ecr1},
+		},
+	})
+	// Managed secret no longer wanted
+	k8sClient.CreateSecret(ns1, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: ecr2, Labels: map[string]string{managedByLabelKey: managedByLabelValue}}})
+	// Managed secret to renew
+	k8sClient.CreateSecret(ns2, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: ecr1, Labels: map[string]string{managedByLabelKey: managedByLabelValue}}, Type: corev1.SecretTypeDockerConfigJson})
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+	assert.NotNil(t, ctrl.DryRun, "Dry run client")
+
+	summary := &bytes.Buffer{}
+	err = ctrl.renewOnce(summary)
+	assert.Nil(t, err, "Renew once error")
+	assert.False(t, k8sClient.SecretExists(ns1, ecr1), "Planned create secret exists")
+	assert.True(t, k8sClient.SecretExists(ns1, ecr2), "Planned delete secret exists")
+	assert.Empty(t, k8sClient.Events(ns1), "Events")
+	assert.Empty(t, k8sClient.ConfigMapData(config.HostNamespace, config.StatusConfigMapName, statusConfigMapDataKey), "Status config map")
+
+	plan := ctrl.DryRun.plan()
+	if assert.Equal(t, 4, len(plan), "Plan changes") {
+		for i, tc := range []struct {
+			ExpectedAction    string // Expected action
+			ExpectedNamespace string // Expected namespace
+			ExpectedName      string // Expected name
+		}{
+			{ExpectedAction: plannedChangeActionCreate, ExpectedNamespace: ns1, ExpectedName: ecr1},
+			{ExpectedAction: plannedChangeActionDelete, ExpectedNamespace: ns1, ExpectedName: ecr2},
+			{ExpectedAction: plannedChangeActionUpdate, ExpectedNamespace: ns2, ExpectedName: ecr1},
+			{ExpectedAction: plannedChangeActionAdopt, ExpectedNamespace: ns3, ExpectedName: ecr1},
+		} {
+			assert.Equal(t, tc.ExpectedAction, plan[i].Action, "Plan change action")
+			assert.Equal(t, "Secret", plan[i].Kind, "Plan change kind")
+			assert.Equal(t, tc.ExpectedNamespace, plan[i].Namespace, "Plan change namespace")
+			assert.Equal(t, tc.ExpectedName, plan[i].Name, "Plan change name")
+		}
+		assert.Contains(t, plan[2].Changes, "data ["+corev1.DockerConfigJsonKey+"] changed", "Update data change")
+		assert.Contains(t, plan[3].Changes, "label ["+managedByLabelKey+"] [] -> ["+managedByLabelValue+"]", "Adopt label change")
+		assert.Contains(t, plan[3].Changes, "data ["+corev1.DockerConfigJsonKey+"] changed", "Adopt data change")
+	}
+	assert.Contains(t, summary.String(), "Dry run plan, 4 changes", "Summary plan")
+	assert.Contains(t, summary.String(), "+ create secret "+ns1+"/"+ecr1, "Summary plan create")
+	assert.Contains(t, summary.String(), "- delete secret "+ns1+"/"+ecr2, "Summary plan delete")
+	assert.Contains(t, summary.String(), "~ update secret "+ns2+"/"+ecr1, "Summary plan update")
+	assert.Contains(t, summary.String(), "! adopt secret "+ns3+"/"+ecr1, "Summary plan adopt")
+
+	// Repeated renewals do not grow the plan
+	err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+	assert.Nil(t, err, "Renewal error")
+	assert.Equal(t, 4, len(ctrl.DryRun.plan()), "Plan changes after repeated renewal")
+
+	mux := http.NewServeMux()
+	ctrl.addAdminRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	res, err := http.Get(srv.URL + "/status")
+	assert.Nil(t, err, "Get status error")
+	defer res.Body.Close()
+	status := adminStatus{}
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&status), "Status decode error")
+	assert.Equal(t, plan, status.Plan, "Status plan")
+}
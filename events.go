@@ -0,0 +1,109 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	credentialsInvalidEventReason = "CredentialsInvalid"
+	credentialsMissingEventReason = "CredentialsMissing"
+	ecrFailedEventReason          = "ECRAuthorizationFailed"
+	eventSourceComponent          = "eatr"
+	secretCreatedEventReason      = "SecretCreated"
+	secretRenewedEventReason      = "SecretRenewed"
+	secretWriteFailedEventReason  = "SecretWriteFailed"
+)
+
+// AWS error codes which mean the AWS credentials themselves are the problem rather than ECR
+var invalidCredentialsErrorCodes = sets.NewString("AccessDeniedException", "IncompleteSignature", "InvalidClientTokenId", "InvalidSignatureException", "UnrecognizedClientException")
+
+// Event recorder, events are recorded with the k8s client so they are visible to the namespace's users, who cannot read our logs
+// Uses the client-go event broadcaster, which aggregates repeated events into a single event with a count and rate limits events per object
+// Events are written asynchronously, failing to record an event is logged by the broadcaster but is not treated as an error
+type eventRecorder struct {
+	Broadcaster record.EventBroadcaster
+	Recorder    record.EventRecorder
+}
+
+// Why a credential request did not produce an ECR authorization token, the event reason is used for the namespace events
+type credentialRequestFailure struct {
+	EventReason string
+	Err         error
+}
+
+func newEventRecorder(k8sClient k8sInterface) *eventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&k8sEventSink{K8S: k8sClient})
+
+	return &eventRecorder{
+		Broadcaster: broadcaster,
+		Recorder:    broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventSourceComponent}),
+	}
+}
+
+// Record an event on a namespace, the event is created in the namespace itself
+func (r *eventRecorder) namespaceEvent(ns corev1.Namespace, eventType, reason, message string) {
+	r.record(
+		&corev1.ObjectReference{
+			APIVersion:      "v1",
+			Kind:            "Namespace",
+			Name:            ns.Name,
+			Namespace:       ns.Name,
+			ResourceVersion: ns.ResourceVersion,
+			UID:             ns.UID,
+		},
+		eventType, reason, message)
+}
+
+// Record an event on a secret, used for the namespace image pull secrets and the host namespace AWS credentials secrets
+func (r *eventRecorder) secretEvent(sec *corev1.Secret, eventType, reason, message string) {
+	r.record(
+		&corev1.ObjectReference{
+			APIVersion:      "v1",
+			Kind:            "Secret",
+			Name:            sec.Name,
+			Namespace:       sec.Namespace,
+			ResourceVersion: sec.ResourceVersion,
+			UID:             sec.UID,
+		},
+		eventType, reason, message)
+}
+
+// The event is created in the involved object's namespace
+func (r *eventRecorder) record(involvedObject *corev1.ObjectReference, eventType, reason, message string) {
+	glog.V(detailiedGLogLevel).Infof("Recording namespace [%s] %s [%s] event [%s]\n", involvedObject.Namespace, involvedObject.Kind, involvedObject.Name, reason)
+	r.Recorder.Event(involvedObject, eventType, reason, message)
+}
+
+// Event sink for the broadcaster, so events are written with our k8s client and are counted by the instrumented client and dropped by the dry run client
+type k8sEventSink struct {
+	K8S k8sInterface
+}
+
+func (s *k8sEventSink) Create(e *corev1.Event) (*corev1.Event, error) {
+	return s.K8S.CreateEvent(e.Namespace, e)
+}
+
+func (s *k8sEventSink) Update(e *corev1.Event) (*corev1.Event, error) {
+	return s.K8S.UpdateEvent(e.Namespace, e)
+}
+
+func (s *k8sEventSink) Patch(e *corev1.Event, data []byte) (*corev1.Event, error) {
+	return s.K8S.PatchEvent(e.Namespace, e.Name, data)
+}
+
+// Get the event reason for an ECR authorization token failure, distinguishes AWS credentials that are rejected from other ECR failures
+func getECRFailureEventReason(err error) string {
+	if awsErr, ok := errors.Cause(err).(awserr.Error); ok && invalidCredentialsErrorCodes.Has(awsErr.Code()) {
+		return credentialsInvalidEventReason
+	}
+
+	return ecrFailedEventReason
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestEvents(t *testing.T) {
+	config := getDefaultConfig()
+	tokens := 0
+
+	for _, tc := range []struct {
+		Name                 string                                                                        // Test case name
+		NS1NamespaceLabels   map[string]string                                                             // NS1 namespace labels
+		GetAuthTokenFn       func(context.Context, string, string, string) (*ecr.AuthorizationData, error) // ECR get auth token func, nil means use the default fake
+		CreateSecretFn       func(string, *corev1.Secret) (*corev1.Secret, error)                          // K8S create secret func, nil means use the default fake
+		Renewals             int                                                                           // Number of renewals to run
+		ExpectedNSEvents     string                                                                        // Expected NS1 namespace events
+		ExpectedHostNSEvents string                                                                        // Expected host namespace events
+	}{
+		{
+			Name:               "Secret created and renewed with the same content",
+			NS1NamespaceLabels: map[string]string{ecr1: "true"},
+			Renewals:           2,
+			ExpectedNSEvents:   "Normal:SecretCreated",
+		},
+		{
+			Name:               "Secret created and renewed with a new token",
+			NS1NamespaceLabels: map[string]string{ecr1: "true"},
+			GetAuthTokenFn: func(ctx context.Context, region, id, secret string) (*ecr.AuthorizationData, error) {
+				tokens++
+				return &ecr.AuthorizationData{
+					AuthorizationToken: aws.String(fmt.Sprintf("token-%d", tokens)),
+					ExpiresAt:          aws.Time(time.Now().Add(12 * time.Hour)),
+					ProxyEndpoint:      aws.String("https://" + ecr1),
+				}, nil
+			},
+			Renewals:         2,
+			ExpectedNSEvents: "Normal:SecretCreated,Normal:SecretRenewed",
+		},
+		{
+			Name:               "Credentials secret missing",
+			NS1NamespaceLabels: map[string]string{ecr3: "true"},
+			Renewals:           1,
+			ExpectedNSEvents:   "Warning:CredentialsMissing",
+		},
+		{
+			Name:               "ECR failure",
+			NS1NamespaceLabels: map[string]string{ecr1: "true"},
+			GetAuthTokenFn: func(ctx context.Context, region, id, secret string) (*ecr.AuthorizationData, error) {
+				return nil, errors.New("ECR is unavailable")
+			},
+			Renewals:             1,
+			ExpectedNSEvents:     "Warning:ECRAuthorizationFailed",
+			ExpectedHostNSEvents: "Warning:ECRAuthorizationFailed",
+		},
+		{
+			Name:               "Invalid credentials",
+			NS1NamespaceLabels: map[string]string{ecr1: "true"},
+			GetAuthTokenFn: func(ctx context.Context, region, id, secret string) (*ecr.AuthorizationData, error) {
+				return nil, awserr.New("UnrecognizedClientException", "The security token included in the request is invalid", nil)
+			},
+			Renewals:             1,
+			ExpectedNSEvents:     "Warning:CredentialsInvalid",
+			ExpectedHostNSEvents: "Warning:CredentialsInvalid",
+		},
+		{
+			Name:               "Secret write failure",
+			NS1NamespaceLabels: map[string]string{ecr1: "true"},
+			CreateSecretFn: func(ns string, s *corev1.Secret) (*corev1.Secret, error) {
+				return nil, errors.New("API server is unavailable")
+			},
+			Renewals:         1,
+			ExpectedNSEvents: "Warning:SecretWriteFailed",
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+				{
+					Name:     config.HostNamespace,
+					IsActive: true,
+					Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1},
+				},
+				{
+					Name:     ns1,
+					IsActive: true,
+					Labels:   tc.NS1NamespaceLabels,
+				},
+			})
+			if tc.CreateSecretFn != nil {
+				k8sClient.CreateSecretFn = tc.CreateSecretFn
+			}
+			nsInformer := NewFakeSharedInformer()
+			secretInformer := NewFakeSharedInformer()
+			prometheusRegistry := prometheus.NewRegistry()
+			ecrClient := NewFakeECRClient()
+			if tc.GetAuthTokenFn != nil {
+				ecrClient.GetAuthTokenFn = tc.GetAuthTokenFn
+			}
+
+			ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+			assert.Nil(t, err, "New controller error")
+
+			for i := 0; i < tc.Renewals; i++ {
+				ctrl.renewECRImagePullSecrets(allNamespacesKey)
+			}
+
+			assert.Equal(t, tc.ExpectedNSEvents, k8sClient.WaitForEventReasons(ns1, tc.ExpectedNSEvents), "Namespace events")
+			assert.Equal(t, tc.ExpectedHostNSEvents, k8sClient.WaitForEventReasons(config.HostNamespace, tc.ExpectedHostNSEvents), "Host namespace events")
+			for _, e := range k8sClient.Events(config.HostNamespace) {
+				assert.Equal(t, "Secret", e.InvolvedObject.Kind, "Host namespace event involved object kind")
+				assert.Equal(t, config.AWSCredentialsSecretPrefix+"-"+ecr1, e.InvolvedObject.Name, "Host namespace event involved object name")
+			}
+		})
+	}
+}
@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ecr"
 
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	k8serr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,12 +46,42 @@ func (f *FakeECRClient) GetAuthToken(ctx context.Context, region, id, secret str
 	return f.GetAuthTokenFn(ctx, region, id, secret)
 }
 
+// STS client fake, credentials belong to the account they are keyed by, unknown credentials fail
+type FakeSTSClient struct {
+	Accounts            map[string]string // Access key Id to account Id
+	GetCallerIdentityFn func(context.Context, string, string, string) (string, error)
+}
+
+func NewFakeSTSClient(accounts map[string]string) *FakeSTSClient {
+	f := &FakeSTSClient{Accounts: accounts}
+
+	f.GetCallerIdentityFn = func(ctx context.Context, region, id, secret string) (string, error) {
+		accountID, ok := f.Accounts[id]
+		if !ok {
+			return "", fmt.Errorf("InvalidClientTokenId: the security token included in the request is invalid")
+		}
+		return accountID, nil
+	}
+
+	return f
+}
+
+func (f *FakeSTSClient) GetCallerIdentity(ctx context.Context, region, id, secret string) (string, error) {
+	return f.GetCallerIdentityFn(ctx, region, id, secret)
+}
+
 // Seed data to initialise a FakeK8sClient
 type FakeK8SClientSeedNamespace struct {
-	Name     string
-	IsActive bool
-	Labels   map[string]string
-	Secrets  []string
+	Name              string
+	IsActive          bool
+	Annotations       map[string]string
+	Labels            map[string]string
+	Secrets           []string
+	ReplicatedSecrets []string // Docker config json secrets annotated for replication
+	PreUpgradeSecrets []string // Docker config json secrets without the managed by label, as written before secrets were labelled
+	ServiceAccounts   []string
+	PodImages         []string // A pod is created for each image
+	DeploymentImages  []string // A deployment is created for each image
 }
 
 // K8S client fake, also has some extra helpers and state tracking for tests
@@ -52,16 +89,44 @@ type FakeK8SClient struct {
 	mutex                      sync.RWMutex
 	namespaces                 *corev1.NamespaceList
 	secrets                    *corev1.SecretList
+	serviceAccounts            *corev1.ServiceAccountList
+	pods                       *corev1.PodList
+	deployments                *appsv1.DeploymentList
+	imagePullCredentials       *imagePullCredentialList
 	createdNamespaceSecretKeys sets.String
 	newlyCreatedSecretCount    int
 	updatedSecretCount         int
-
-	CreateSecretFn  func(string, *corev1.Secret) (*corev1.Secret, error)
-	GetNamespaceFn  func(string) (*corev1.Namespace, error)
-	GetNamespacesFn func() (*corev1.NamespaceList, error)
-	GetSecretFn     func(string, string) (*corev1.Secret, error)
-	GetSecretsFn    func(string) (*corev1.SecretList, error)
-	UpdateSecretFn  func(string, *corev1.Secret) (*corev1.Secret, error)
+	deletedSecretCount         int
+	deletedPodKeys             sets.String
+	events                     []corev1.Event
+	configMaps                 map[string]*corev1.ConfigMap
+
+	CreateConfigMapFn               func(string, *corev1.ConfigMap) (*corev1.ConfigMap, error)
+	CreateEventFn                   func(string, *corev1.Event) (*corev1.Event, error)
+	CreateSecretFn                  func(string, *corev1.Secret) (*corev1.Secret, error)
+	CreateSelfSubjectAccessReviewFn func(*authorizationv1.SelfSubjectAccessReview) (*authorizationv1.SelfSubjectAccessReview, error)
+	CreateSubjectAccessReviewFn     func(*authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error)
+	CreateTokenReviewFn             func(*authenticationv1.TokenReview) (*authenticationv1.TokenReview, error)
+	DeleteConfigMapFn               func(string, string) error
+	DeletePodFn                     func(string, string) error
+	DeleteSecretFn                  func(string, string) error
+	GetConfigMapFn                  func(string, string) (*corev1.ConfigMap, error)
+	GetCronJobsFn                   func(string) (*batchv1beta1.CronJobList, error)
+	GetDeploymentsFn                func(string) (*appsv1.DeploymentList, error)
+	GetImagePullCredentialsFn       func() (*imagePullCredentialList, error)
+	GetNamespaceFn                  func(string) (*corev1.Namespace, error)
+	GetNamespacesFn                 func() (*corev1.NamespaceList, error)
+	GetPodsFn                       func(string) (*corev1.PodList, error)
+	GetSecretFn                     func(string, string) (*corev1.Secret, error)
+	GetSecretsFn                    func(string) (*corev1.SecretList, error)
+	GetServiceAccountsFn            func(string) (*corev1.ServiceAccountList, error)
+	GetStatefulSetsFn               func(string) (*appsv1.StatefulSetList, error)
+	PatchEventFn                    func(string, string, []byte) (*corev1.Event, error)
+	UpdateConfigMapFn               func(string, *corev1.ConfigMap) (*corev1.ConfigMap, error)
+	UpdateEventFn                   func(string, *corev1.Event) (*corev1.Event, error)
+	UpdateNamespaceFn               func(*corev1.Namespace) (*corev1.Namespace, error)
+	UpdateSecretFn                  func(string, *corev1.Secret) (*corev1.Secret, error)
+	UpdateServiceAccountFn          func(string, *corev1.ServiceAccount) (*corev1.ServiceAccount, error)
 }
 
 func NewFakeK8SClient(seed []FakeK8SClientSeedNamespace) *FakeK8SClient {
@@ -71,7 +136,13 @@ func NewFakeK8SClient(seed []FakeK8SClientSeedNamespace) *FakeK8SClient {
 	f := &FakeK8SClient{
 		namespaces:                 &corev1.NamespaceList{},
 		secrets:                    &corev1.SecretList{},
+		serviceAccounts:            &corev1.ServiceAccountList{},
+		pods:                       &corev1.PodList{},
+		deployments:                &appsv1.DeploymentList{},
+		imagePullCredentials:       &imagePullCredentialList{},
+		configMaps:                 map[string]*corev1.ConfigMap{},
 		createdNamespaceSecretKeys: sets.NewString(),
+		deletedPodKeys:             sets.NewString(),
 	}
 
 	for _, seedNS := range seed {
@@ -81,13 +152,47 @@ func NewFakeK8SClient(seed []FakeK8SClientSeedNamespace) *FakeK8SClient {
 		}
 
 		f.namespaces.Items = append(f.namespaces.Items,
-			corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: seedNS.Name, Namespace: seedNS.Name, Labels: seedNS.Labels}, Status: corev1.NamespaceStatus{Phase: phase}})
+			corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: seedNS.Name, Namespace: seedNS.Name, Annotations: seedNS.Annotations, Labels: seedNS.Labels}, Status: corev1.NamespaceStatus{Phase: phase}})
 
 		for _, secretName := range seedNS.Secrets {
 			// We don't need a type or data for our tests
 			f.secrets.Items = append(f.secrets.Items,
 				corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: seedNS.Name}})
 		}
+
+		for _, secretName := range seedNS.ReplicatedSecrets {
+			f.secrets.Items = append(f.secrets.Items,
+				corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: seedNS.Name, Annotations: map[string]string{replicateAnnotationKey: "true"}},
+					Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{ "auths": { "https://index.docker.io/v1/": { "auth": "dXNlcjpwYXNz" } } }`)},
+					Type:       corev1.SecretTypeDockerConfigJson,
+				})
+		}
+
+		for _, secretName := range seedNS.PreUpgradeSecrets {
+			f.secrets.Items = append(f.secrets.Items,
+				corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: seedNS.Name},
+					Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{ "auths": { "https://` + secretName + `": { "auth": "QVdTOmV4cGlyZWQ=" } } }`)},
+					Type:       corev1.SecretTypeDockerConfigJson,
+				})
+		}
+
+		for _, saName := range seedNS.ServiceAccounts {
+			f.serviceAccounts.Items = append(f.serviceAccounts.Items,
+				corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: saName, Namespace: seedNS.Name}})
+		}
+
+		for i, image := range seedNS.PodImages {
+			f.pods.Items = append(f.pods.Items,
+				corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod-%d", i), Namespace: seedNS.Name}, Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "c", Image: image}}}})
+		}
+
+		for i, image := range seedNS.DeploymentImages {
+			deployment := appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("deployment-%d", i), Namespace: seedNS.Name}}
+			deployment.Spec.Template.Spec.Containers = []corev1.Container{{Name: "c", Image: image}}
+			f.deployments.Items = append(f.deployments.Items, deployment)
+		}
 	}
 
 	getSecretIndexFn := func(ns, name string) int {
@@ -108,6 +213,80 @@ func NewFakeK8SClient(seed []FakeK8SClientSeedNamespace) *FakeK8SClient {
 		return indexNotFound
 	}
 
+	f.CreateConfigMapFn = func(ns string, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		cm.ObjectMeta.Namespace = ns
+		f.configMaps[ns+":"+cm.Name] = cm.DeepCopy()
+
+		return cm, nil
+	}
+
+	f.GetConfigMapFn = func(ns, name string) (*corev1.ConfigMap, error) {
+		f.mutex.RLock()
+		defer f.mutex.RUnlock()
+
+		if cm, ok := f.configMaps[ns+":"+name]; ok {
+			return cm.DeepCopy(), nil
+		}
+		return nil, k8sNotFoundErr
+	}
+
+	f.UpdateConfigMapFn = func(ns string, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		if _, ok := f.configMaps[ns+":"+cm.Name]; !ok {
+			return nil, k8sNotFoundErr
+		}
+		cm.ObjectMeta.Namespace = ns
+		f.configMaps[ns+":"+cm.Name] = cm.DeepCopy()
+
+		return cm, nil
+	}
+
+	f.CreateEventFn = func(ns string, e *corev1.Event) (*corev1.Event, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		e.ObjectMeta.Namespace = ns
+		f.events = append(f.events, *e.DeepCopy())
+
+		return e, nil
+	}
+
+	// Strategic merge patches of the event count, timestamps and message are applied by unmarshalling them over the existing event
+	f.PatchEventFn = func(ns, name string, data []byte) (*corev1.Event, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		for i := range f.events {
+			if f.events[i].Namespace == ns && f.events[i].Name == name {
+				if err := json.Unmarshal(data, &f.events[i]); err != nil {
+					return nil, err
+				}
+				return f.events[i].DeepCopy(), nil
+			}
+		}
+
+		return nil, k8sNotFoundErr
+	}
+
+	f.UpdateEventFn = func(ns string, e *corev1.Event) (*corev1.Event, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		for i := range f.events {
+			if f.events[i].Namespace == ns && f.events[i].Name == e.Name {
+				f.events[i] = *e.DeepCopy()
+				return e, nil
+			}
+		}
+
+		return nil, k8sNotFoundErr
+	}
+
 	f.CreateSecretFn = func(ns string, s *corev1.Secret) (*corev1.Secret, error) {
 		f.mutex.Lock()
 		defer f.mutex.Unlock()
@@ -119,6 +298,87 @@ func NewFakeK8SClient(seed []FakeK8SClientSeedNamespace) *FakeK8SClient {
 		return s, nil
 	}
 
+	// Reviews default to unauthenticated and not allowed, tests set the review responses they need
+	f.CreateSelfSubjectAccessReviewFn = func(ssar *authorizationv1.SelfSubjectAccessReview) (*authorizationv1.SelfSubjectAccessReview, error) {
+		ssar.Status = authorizationv1.SubjectAccessReviewStatus{Allowed: false}
+		return ssar, nil
+	}
+
+	f.CreateSubjectAccessReviewFn = func(sar *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+		sar.Status = authorizationv1.SubjectAccessReviewStatus{Allowed: false}
+		return sar, nil
+	}
+
+	f.CreateTokenReviewFn = func(tr *authenticationv1.TokenReview) (*authenticationv1.TokenReview, error) {
+		tr.Status = authenticationv1.TokenReviewStatus{Authenticated: false}
+		return tr, nil
+	}
+
+	f.DeleteConfigMapFn = func(ns, name string) error {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		if _, ok := f.configMaps[ns+":"+name]; !ok {
+			return k8sNotFoundErr
+		}
+		delete(f.configMaps, ns+":"+name)
+
+		return nil
+	}
+
+	f.DeletePodFn = func(ns, name string) error {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		for i, p := range f.pods.Items {
+			if p.Namespace == ns && p.Name == name {
+				f.pods.Items = append(f.pods.Items[:i], f.pods.Items[i+1:]...)
+				f.deletedPodKeys.Insert(ns + ":" + name)
+				return nil
+			}
+		}
+		return k8sNotFoundErr
+	}
+
+	f.DeleteSecretFn = func(ns, name string) error {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		idx := getSecretIndexFn(ns, name)
+		if idx == indexNotFound {
+			return k8sNotFoundErr
+		}
+
+		f.secrets.Items = append(f.secrets.Items[:idx], f.secrets.Items[idx+1:]...)
+		f.deletedSecretCount++
+
+		return nil
+	}
+
+	f.GetCronJobsFn = func(ns string) (*batchv1beta1.CronJobList, error) {
+		return &batchv1beta1.CronJobList{}, nil
+	}
+
+	f.GetDeploymentsFn = func(ns string) (*appsv1.DeploymentList, error) {
+		f.mutex.RLock()
+		defer f.mutex.RUnlock()
+
+		ds := &appsv1.DeploymentList{}
+		for _, d := range f.deployments.Items {
+			if ns == metav1.NamespaceAll || d.Namespace == ns {
+				ds.Items = append(ds.Items, *d.DeepCopy())
+			}
+		}
+		return ds, nil
+	}
+
+	f.GetImagePullCredentialsFn = func() (*imagePullCredentialList, error) {
+		f.mutex.RLock()
+		defer f.mutex.RUnlock()
+
+		return f.imagePullCredentials.DeepCopy(), nil
+	}
+
 	f.GetNamespaceFn = func(ns string) (*corev1.Namespace, error) {
 		f.mutex.RLock()
 		defer f.mutex.RUnlock()
@@ -138,6 +398,19 @@ func NewFakeK8SClient(seed []FakeK8SClientSeedNamespace) *FakeK8SClient {
 		return f.namespaces.DeepCopy(), nil
 	}
 
+	f.GetPodsFn = func(ns string) (*corev1.PodList, error) {
+		f.mutex.RLock()
+		defer f.mutex.RUnlock()
+
+		ps := &corev1.PodList{}
+		for _, p := range f.pods.Items {
+			if ns == metav1.NamespaceAll || p.Namespace == ns {
+				ps.Items = append(ps.Items, *p.DeepCopy())
+			}
+		}
+		return ps, nil
+	}
+
 	f.GetSecretFn = func(ns, name string) (*corev1.Secret, error) {
 		f.mutex.RLock()
 		defer f.mutex.RUnlock()
@@ -163,6 +436,37 @@ func NewFakeK8SClient(seed []FakeK8SClientSeedNamespace) *FakeK8SClient {
 		return ss, nil
 	}
 
+	f.GetServiceAccountsFn = func(ns string) (*corev1.ServiceAccountList, error) {
+		f.mutex.RLock()
+		defer f.mutex.RUnlock()
+
+		sas := &corev1.ServiceAccountList{}
+		for _, sa := range f.serviceAccounts.Items {
+			if sa.Namespace == ns {
+				sas.Items = append(sas.Items, *sa.DeepCopy())
+			}
+		}
+		return sas, nil
+	}
+
+	f.GetStatefulSetsFn = func(ns string) (*appsv1.StatefulSetList, error) {
+		return &appsv1.StatefulSetList{}, nil
+	}
+
+	f.UpdateNamespaceFn = func(ns *corev1.Namespace) (*corev1.Namespace, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		idx := getNamespaceIndexFn(ns.Name)
+		if idx == indexNotFound {
+			return nil, k8sNotFoundErr
+		}
+
+		f.namespaces.Items[idx] = *ns.DeepCopy()
+
+		return ns, nil
+	}
+
 	f.UpdateSecretFn = func(ns string, s *corev1.Secret) (*corev1.Secret, error) {
 		f.mutex.Lock()
 		defer f.mutex.Unlock()
@@ -172,6 +476,7 @@ func NewFakeK8SClient(seed []FakeK8SClientSeedNamespace) *FakeK8SClient {
 			return nil, k8sNotFoundErr
 		}
 
+		s.ObjectMeta.Namespace = ns
 		f.secrets.Items[idx] = *s.DeepCopy()
 		f.createdNamespaceSecretKeys[ns+":"+(*s).Name] = sets.Empty{}
 		f.updatedSecretCount++
@@ -179,13 +484,74 @@ func NewFakeK8SClient(seed []FakeK8SClientSeedNamespace) *FakeK8SClient {
 		return s, nil
 	}
 
+	f.UpdateServiceAccountFn = func(ns string, sa *corev1.ServiceAccount) (*corev1.ServiceAccount, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		for i, c := range f.serviceAccounts.Items {
+			if c.Namespace == ns && c.Name == sa.Name {
+				f.serviceAccounts.Items[i] = *sa.DeepCopy()
+				return sa, nil
+			}
+		}
+		return nil, k8sNotFoundErr
+	}
+
 	return f
 }
 
+func (f *FakeK8SClient) CreateConfigMap(ns string, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	return f.CreateConfigMapFn(ns, cm)
+}
+
+func (f *FakeK8SClient) CreateEvent(ns string, e *corev1.Event) (*corev1.Event, error) {
+	return f.CreateEventFn(ns, e)
+}
+
 func (f *FakeK8SClient) CreateSecret(ns string, s *corev1.Secret) (*corev1.Secret, error) {
 	return f.CreateSecretFn(ns, s)
 }
 
+func (f *FakeK8SClient) CreateSelfSubjectAccessReview(ssar *authorizationv1.SelfSubjectAccessReview) (*authorizationv1.SelfSubjectAccessReview, error) {
+	return f.CreateSelfSubjectAccessReviewFn(ssar)
+}
+
+func (f *FakeK8SClient) CreateSubjectAccessReview(sar *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+	return f.CreateSubjectAccessReviewFn(sar)
+}
+
+func (f *FakeK8SClient) CreateTokenReview(tr *authenticationv1.TokenReview) (*authenticationv1.TokenReview, error) {
+	return f.CreateTokenReviewFn(tr)
+}
+
+func (f *FakeK8SClient) DeleteConfigMap(ns, name string) error {
+	return f.DeleteConfigMapFn(ns, name)
+}
+
+func (f *FakeK8SClient) DeletePod(ns, name string) error {
+	return f.DeletePodFn(ns, name)
+}
+
+func (f *FakeK8SClient) DeleteSecret(ns, name string) error {
+	return f.DeleteSecretFn(ns, name)
+}
+
+func (f *FakeK8SClient) GetConfigMap(ns, name string) (*corev1.ConfigMap, error) {
+	return f.GetConfigMapFn(ns, name)
+}
+
+func (f *FakeK8SClient) GetCronJobs(ns string) (*batchv1beta1.CronJobList, error) {
+	return f.GetCronJobsFn(ns)
+}
+
+func (f *FakeK8SClient) GetDeployments(ns string) (*appsv1.DeploymentList, error) {
+	return f.GetDeploymentsFn(ns)
+}
+
+func (f *FakeK8SClient) GetImagePullCredentials() (*imagePullCredentialList, error) {
+	return f.GetImagePullCredentialsFn()
+}
+
 func (f *FakeK8SClient) GetNamespace(ns string) (*corev1.Namespace, error) {
 	return f.GetNamespaceFn(ns)
 }
@@ -194,6 +560,10 @@ func (f *FakeK8SClient) GetNamespaces() (*corev1.NamespaceList, error) {
 	return f.GetNamespacesFn()
 }
 
+func (f *FakeK8SClient) GetPods(ns string) (*corev1.PodList, error) {
+	return f.GetPodsFn(ns)
+}
+
 func (f *FakeK8SClient) GetSecret(ns, name string) (*corev1.Secret, error) {
 	return f.GetSecretFn(ns, name)
 }
@@ -202,10 +572,38 @@ func (f *FakeK8SClient) GetSecrets(ns string) (*corev1.SecretList, error) {
 	return f.GetSecretsFn(ns)
 }
 
+func (f *FakeK8SClient) GetServiceAccounts(ns string) (*corev1.ServiceAccountList, error) {
+	return f.GetServiceAccountsFn(ns)
+}
+
+func (f *FakeK8SClient) GetStatefulSets(ns string) (*appsv1.StatefulSetList, error) {
+	return f.GetStatefulSetsFn(ns)
+}
+
+func (f *FakeK8SClient) PatchEvent(ns, name string, data []byte) (*corev1.Event, error) {
+	return f.PatchEventFn(ns, name, data)
+}
+
+func (f *FakeK8SClient) UpdateConfigMap(ns string, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	return f.UpdateConfigMapFn(ns, cm)
+}
+
+func (f *FakeK8SClient) UpdateEvent(ns string, e *corev1.Event) (*corev1.Event, error) {
+	return f.UpdateEventFn(ns, e)
+}
+
+func (f *FakeK8SClient) UpdateNamespace(ns *corev1.Namespace) (*corev1.Namespace, error) {
+	return f.UpdateNamespaceFn(ns)
+}
+
 func (f *FakeK8SClient) UpdateSecret(ns string, s *corev1.Secret) (*corev1.Secret, error) {
 	return f.UpdateSecretFn(ns, s)
 }
 
+func (f *FakeK8SClient) UpdateServiceAccount(ns string, sa *corev1.ServiceAccount) (*corev1.ServiceAccount, error) {
+	return f.UpdateServiceAccountFn(ns, sa)
+}
+
 // Insert new namespace record - used for populating the local cache with no counter increments - post initialization - needed to test post start new namesapce handling
 func (f *FakeK8SClient) InsertNewNamespaceRecord(ns *corev1.Namespace) {
 	f.mutex.Lock()
@@ -229,6 +627,30 @@ func (f *FakeK8SClient) UpdateNamespaceRecord(ns *corev1.Namespace) {
 	}
 }
 
+// Insert new service account record - used for populating the local cache - post initialization - needed to test post start new service account handling
+func (f *FakeK8SClient) InsertNewServiceAccountRecord(sa *corev1.ServiceAccount) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.serviceAccounts.Items = append(f.serviceAccounts.Items, *sa.DeepCopy())
+}
+
+// Insert new pod record - used for populating the local cache - post initialization - needed to test post start new pod handling
+func (f *FakeK8SClient) InsertNewPodRecord(p *corev1.Pod) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.pods.Items = append(f.pods.Items, *p.DeepCopy())
+}
+
+// Insert new image pull credential record - used for populating the local cache - post initialization - needed to test image pull credential handling
+func (f *FakeK8SClient) InsertNewImagePullCredentialRecord(ipc *imagePullCredential) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.imagePullCredentials.Items = append(f.imagePullCredentials.Items, *ipc.DeepCopy())
+}
+
 func (f *FakeK8SClient) NewlyCreatedSecretCount() int {
 	f.mutex.RLock()
 	defer f.mutex.RUnlock()
@@ -243,6 +665,96 @@ func (f *FakeK8SClient) UpdatedSecretCount() int {
 	return f.updatedSecretCount
 }
 
+func (f *FakeK8SClient) DeletedSecretCount() int {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	return f.deletedSecretCount
+}
+
+// Comma separated namespaced pod keys that were deleted
+func (f *FakeK8SClient) DeletedPodKeys() string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	return strings.Join(f.deletedPodKeys.List(), ",")
+}
+
+// Comma separated type:reason of the events recorded in a namespace
+func (f *FakeK8SClient) EventReasons(ns string) string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	reasons := []string{}
+	for _, e := range f.events {
+		if e.Namespace == ns {
+			reasons = append(reasons, e.Type+":"+e.Reason)
+		}
+	}
+	return strings.Join(reasons, ",")
+}
+
+// Config map data value, empty if the config map or key does not exist
+func (f *FakeK8SClient) ConfigMapData(ns, name, key string) string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if cm, ok := f.configMaps[ns+":"+name]; ok {
+		return cm.Data[key]
+	}
+	return ""
+}
+
+// Wait for the events recorded in a namespace to match the expected comma separated type:reason, events are recorded asynchronously
+// Returns the last comma separated type:reason seen, so the caller can assert on it
+func (f *FakeK8SClient) WaitForEventReasons(ns, expected string) string {
+	deadline := time.Now().Add(time.Second)
+	for {
+		actual := f.EventReasons(ns)
+		if actual == expected || time.Now().After(deadline) {
+			return actual
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Events recorded in a namespace
+func (f *FakeK8SClient) Events(ns string) []corev1.Event {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	res := []corev1.Event{}
+	for _, e := range f.events {
+		if e.Namespace == ns {
+			res = append(res, *e.DeepCopy())
+		}
+	}
+	return res
+}
+
+// Does the secret currently exist
+func (f *FakeK8SClient) SecretExists(ns, name string) bool {
+	_, err := f.GetSecret(ns, name)
+	return err == nil
+}
+
+// Comma separated image pull secret names for a service account, empty if the service account does not exist
+func (f *FakeK8SClient) ServiceAccountImagePullSecretNames(ns, name string) string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	names := []string{}
+	for _, sa := range f.serviceAccounts.Items {
+		if sa.Namespace == ns && sa.Name == name {
+			for _, ref := range sa.ImagePullSecrets {
+				names = append(names, ref.Name)
+			}
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
 // Total secrets created - newly created + existing secrets that were updated
 func (f *FakeK8SClient) TotalSecretsCreated() int {
 	f.mutex.RLock()
@@ -269,27 +781,29 @@ func (f *FakeK8SClient) DistinctNamespacedSecretKeysCreated() string {
 
 // Shared informer fake - Must satisfy the client-go/tools/cache/SharedInformer interface
 type FakeSharedInformer struct {
-	mutex   sync.RWMutex
-	handler cache.ResourceEventHandler
+	mutex    sync.RWMutex
+	handlers []cache.ResourceEventHandler
+	store    cache.Store
 }
 
 func NewFakeSharedInformer() *FakeSharedInformer {
-	return &FakeSharedInformer{}
+	return &FakeSharedInformer{
+		store: cache.NewStore(cache.MetaNamespaceKeyFunc),
+	}
 }
 
 func (f *FakeSharedInformer) AddEventHandler(handler cache.ResourceEventHandler) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
-	f.handler = handler
+	f.handlers = append(f.handlers, handler)
 }
 
 func (f *FakeSharedInformer) AddEventHandlerWithResyncPeriod(handler cache.ResourceEventHandler, resyncPeriod time.Duration) {
 }
 
 func (f *FakeSharedInformer) GetStore() cache.Store {
-	// Satisfy interface
-	return nil
+	return f.store
 }
 
 func (f *FakeSharedInformer) GetController() cache.Controller {
@@ -315,12 +829,97 @@ func (f *FakeSharedInformer) SimulateAddNamespace(ns *corev1.Namespace) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
-	f.handler.OnAdd(ns.DeepCopy())
+	f.store.Add(ns.DeepCopy())
+
+	for _, handler := range f.handlers {
+		handler.OnAdd(ns.DeepCopy())
+	}
 }
 
 func (f *FakeSharedInformer) SimulateUpdateNamespace(oldNS, newNS *corev1.Namespace) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
-	f.handler.OnUpdate(oldNS.DeepCopy(), newNS.DeepCopy())
+	f.store.Update(newNS.DeepCopy())
+
+	for _, handler := range f.handlers {
+		handler.OnUpdate(oldNS.DeepCopy(), newNS.DeepCopy())
+	}
+}
+
+func (f *FakeSharedInformer) SimulateAddServiceAccount(sa *corev1.ServiceAccount) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.store.Add(sa.DeepCopy())
+
+	for _, handler := range f.handlers {
+		handler.OnAdd(sa.DeepCopy())
+	}
+}
+
+func (f *FakeSharedInformer) SimulateAddPod(p *corev1.Pod) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.store.Add(p.DeepCopy())
+
+	for _, handler := range f.handlers {
+		handler.OnAdd(p.DeepCopy())
+	}
+}
+
+func (f *FakeSharedInformer) SimulateUpdatePod(oldP, newP *corev1.Pod) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.store.Update(newP.DeepCopy())
+
+	for _, handler := range f.handlers {
+		handler.OnUpdate(oldP.DeepCopy(), newP.DeepCopy())
+	}
+}
+
+func (f *FakeSharedInformer) SimulateAddSecret(s *corev1.Secret) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.store.Add(s.DeepCopy())
+
+	for _, handler := range f.handlers {
+		handler.OnAdd(s.DeepCopy())
+	}
+}
+
+func (f *FakeSharedInformer) SimulateUpdateSecret(oldS, newS *corev1.Secret) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.store.Update(newS.DeepCopy())
+
+	for _, handler := range f.handlers {
+		handler.OnUpdate(oldS.DeepCopy(), newS.DeepCopy())
+	}
+}
+
+func (f *FakeSharedInformer) SimulateDeleteSecret(s *corev1.Secret) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.store.Delete(s.DeepCopy())
+
+	for _, handler := range f.handlers {
+		handler.OnDelete(s.DeepCopy())
+	}
+}
+
+func (f *FakeSharedInformer) SimulateAddImagePullCredential(ipc *imagePullCredential) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.store.Add(ipc.DeepCopy())
+
+	for _, handler := range f.handlers {
+		handler.OnAdd(ipc.DeepCopy())
+	}
 }
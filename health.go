@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Controller health state, used by the liveness and readiness endpoints
+type controllerHealth struct {
+	mutex                    sync.RWMutex
+	cachesSyncedAt           time.Time
+	consumerRunning          bool
+	consumerStopped          bool
+	processingStartedAt      time.Time
+	lastAllNamespacesRenewal time.Time
+}
+
+func newControllerHealth() *controllerHealth {
+	return &controllerHealth{}
+}
+
+func (h *controllerHealth) recordCachesSynced() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.cachesSyncedAt = time.Now()
+}
+
+func (h *controllerHealth) recordConsumerRunning(running bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.consumerRunning = running
+	h.consumerStopped = !running
+}
+
+// Record the start of processing a queue item, a zero time means no item is being processed
+func (h *controllerHealth) recordProcessing(startedAt time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.processingStartedAt = startedAt
+}
+
+func (h *controllerHealth) recordAllNamespacesRenewal() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastAllNamespacesRenewal = time.Now()
+}
+
+// Get the renewal staleness window, defaults to twice the renewal interval if not configured
+func (c *controller) getRenewalStalenessWindow() time.Duration {
+	c.ConfigMutex.RLock()
+	defer c.ConfigMutex.RUnlock()
+
+	if c.Config.RenewalStalenessWindow > 0 {
+		return c.Config.RenewalStalenessWindow
+	}
+
+	return 2 * c.Config.AuthenticationTokenRenewalInterval
+}
+
+// Liveness check, the process is alive, the queue consumer has not stopped and is not wedged on a single item for longer than the staleness window
+func (c *controller) checkLiveness() error {
+	c.Health.mutex.RLock()
+	defer c.Health.mutex.RUnlock()
+
+	if c.Health.consumerStopped {
+		return errors.New("queue consumer has stopped")
+	}
+	if !c.Health.processingStartedAt.IsZero() && time.Since(c.Health.processingStartedAt) > c.getRenewalStalenessWindow() {
+		return errors.Errorf("queue consumer has been processing an item since %s", c.Health.processingStartedAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// Readiness check, the informer caches are synced, the queue consumer is running, the last all namespaces renewal is within the staleness window and no registry that has renewed is stuck failing
+// Until the first all namespaces renewal the staleness window is measured from the cache sync, as the first population is via the informers
+func (c *controller) checkReadiness() error {
+	if err := c.checkLiveness(); err != nil {
+		return err
+	}
+
+	window := c.getRenewalStalenessWindow()
+	c.Health.mutex.RLock()
+	cachesSyncedAt, consumerRunning, lastRenewal := c.Health.cachesSyncedAt, c.Health.consumerRunning, c.Health.lastAllNamespacesRenewal
+	c.Health.mutex.RUnlock()
+
+	if cachesSyncedAt.IsZero() {
+		return errors.New("informer caches are not synced")
+	}
+	if !consumerRunning {
+		return errors.New("queue consumer is not running")
+	}
+	if lastRenewal.IsZero() {
+		lastRenewal = cachesSyncedAt
+	}
+	if time.Since(lastRenewal) > window {
+		return errors.Errorf("last all namespaces renewal at %s is older than %s", lastRenewal.Format(time.RFC3339), window)
+	}
+	if registries := c.Status.getStuckRegistries(time.Now().Add(-window)); len(registries) > 0 {
+		return errors.Errorf("registries have been failing for longer than %s [%s]", window, strings.Join(registries, ","))
+	}
+
+	return nil
+}
+
+// Health check handler, responds with 200 and ok if the check passes, 503 and the failure reason otherwise
+func newHealthCheckHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthChecks(t *testing.T) {
+	for _, tc := range []struct {
+		Name              string                 // Test case name
+		Mutate            func(ctrl *controller) // Change to make to a healthy and ready controller
+		ExpectedLiveness  int                    // Expected healthz status code
+		ExpectedReadiness int                    // Expected readyz status code
+	}{
+		{
+			Name:              "Healthy and ready",
+			Mutate:            func(ctrl *controller) {},
+			ExpectedLiveness:  http.StatusOK,
+			ExpectedReadiness: http.StatusOK,
+		},
+		{
+			Name:              "Caches not synced",
+			Mutate:            func(ctrl *controller) { ctrl.Health.cachesSyncedAt = time.Time{} },
+			ExpectedLiveness:  http.StatusOK,
+			ExpectedReadiness: http.StatusServiceUnavailable,
+		},
+		{
+			Name:              "Queue consumer stopped",
+			Mutate:            func(ctrl *controller) { ctrl.Health.recordConsumerRunning(false) },
+			ExpectedLiveness:  http.StatusServiceUnavailable,
+			ExpectedReadiness: http.StatusServiceUnavailable,
+		},
+		{
+			Name:              "Queue consumer wedged",
+			Mutate:            func(ctrl *controller) { ctrl.Health.recordProcessing(time.Now().Add(-2 * time.Hour)) },
+			ExpectedLiveness:  http.StatusServiceUnavailable,
+			ExpectedReadiness: http.StatusServiceUnavailable,
+		},
+		{
+			Name:              "Last all namespaces renewal is stale",
+			Mutate:            func(ctrl *controller) { ctrl.Health.lastAllNamespacesRenewal = time.Now().Add(-2 * time.Hour) },
+			ExpectedLiveness:  http.StatusOK,
+			ExpectedReadiness: http.StatusServiceUnavailable,
+		},
+		{
+			Name: "Registry stuck failing",
+			Mutate: func(ctrl *controller) {
+				ctrl.Status.recordFetch(ecr1, time.Now().Add(-2*time.Hour), time.Now().Add(10*time.Hour))
+				ctrl.Status.recordFetchFailure(ecr1, errors.New("ECR is unavailable"))
+			},
+			ExpectedLiveness:  http.StatusOK,
+			ExpectedReadiness: http.StatusServiceUnavailable,
+		},
+		{
+			Name: "Registry failing within the window",
+			Mutate: func(ctrl *controller) {
+				ctrl.Status.recordFetch(ecr1, time.Now().Add(-time.Minute), time.Now().Add(10*time.Hour))
+				ctrl.Status.recordFetchFailure(ecr1, errors.New("ECR is unavailable"))
+			},
+			ExpectedLiveness:  http.StatusOK,
+			ExpectedReadiness: http.StatusOK,
+		},
+		{
+			Name: "Registry never renewed",
+			Mutate: func(ctrl *controller) {
+				ctrl.Status.recordFetchFailure(ecr1, errors.New("AWS credentials secret not found"))
+			},
+			ExpectedLiveness:  http.StatusOK,
+			ExpectedReadiness: http.StatusOK,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			config := getDefaultConfig()
+			config.RenewalStalenessWindow = time.Hour
+
+			ctrl, err := newController(config, NewFakeK8SClient(nil), controllerInformers{Namespace: NewFakeSharedInformer(), HostSecret: NewFakeSharedInformer()}, prometheus.NewRegistry(), NewFakeECRClient())
+			assert.Nil(t, err, "New controller error")
+			ctrl.Health.recordCachesSynced()
+			ctrl.Health.recordConsumerRunning(true)
+			ctrl.Health.recordAllNamespacesRenewal()
+			tc.Mutate(ctrl)
+
+			res := httptest.NewRecorder()
+			newHealthCheckHandler(ctrl.checkLiveness).ServeHTTP(res, httptest.NewRequest("GET", "/healthz", nil))
+			assert.Equal(t, tc.ExpectedLiveness, res.Code, "Liveness status code")
+
+			res = httptest.NewRecorder()
+			newHealthCheckHandler(ctrl.checkReadiness).ServeHTTP(res, httptest.NewRequest("GET", "/readyz", nil))
+			assert.Equal(t, tc.ExpectedReadiness, res.Code, "Readiness status code")
+		})
+	}
+}
+
+func TestHealthChecksRun(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{{Name: config.HostNamespace, IsActive: true}})
+	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+	assert.NotNil(t, ctrl.checkReadiness(), "Readiness error before run")
+
+	stop := make(chan struct{})
+	go ctrl.Run(stop)
+	time.Sleep(150 * time.Millisecond)
+	assert.Nil(t, ctrl.checkLiveness(), "Liveness error")
+	assert.Nil(t, ctrl.checkReadiness(), "Readiness error")
+
+	close(stop)
+	time.Sleep(150 * time.Millisecond)
+	assert.NotNil(t, ctrl.checkLiveness(), "Liveness error after stop")
+}
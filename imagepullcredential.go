@@ -0,0 +1,313 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	imagePullCredentialKeyPrefix                    = "**ipc**/" // Queue key prefix for renewals driven by an image pull credential's renewal policy, is not a valid namespace name so cannot clash
+	imagePullCredentialProviderECR                  = "ecr"
+	imagePullCredentialResource                     = "imagepullcredentials"
+	imagePullCredentialSecretFormatDockerCfg        = "dockercfg"
+	imagePullCredentialSecretFormatDockerConfigJSON = "dockerconfigjson"
+)
+
+var (
+	imagePullCredentialGroupVersion = schema.GroupVersion{Group: "eatr.io", Version: "v1alpha1"}
+)
+
+// Cluster scoped resource configuring a registry, the credentials used to create tokens for it and the namespaces that get a pull secret for it
+type imagePullCredential struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec imagePullCredentialSpec `json:"spec"`
+}
+
+type imagePullCredentialSpec struct {
+	Registry            string                           `json:"registry"`                     // Registry host, i.e. 123456789012.dkr.ecr.eu-west-1.amazonaws.com
+	Provider            string                           `json:"provider,omitempty"`           // Provider type used to create tokens, only ecr is supported, defaults to ecr
+	CredentialSecretRef corev1.SecretReference           `json:"credentialSecretRef"`          // Secret with the provider credentials, namespace defaults to the host namespace
+	NamespaceSelector   *metav1.LabelSelector            `json:"namespaceSelector,omitempty"`  // Namespaces that get a pull secret, defaults to namespaces labelled [Registry]=true
+	TargetSecretName    string                           `json:"targetSecretName,omitempty"`   // Pull secret name, defaults to the registry
+	TargetSecretFormat  string                           `json:"targetSecretFormat,omitempty"` // Pull secret format, dockerconfigjson or dockercfg, defaults to dockerconfigjson
+	RenewalPolicy       imagePullCredentialRenewalPolicy `json:"renewalPolicy,omitempty"`
+}
+
+type imagePullCredentialRenewalPolicy struct {
+	Interval metav1.Duration `json:"interval,omitempty"` // Renewal interval for the selected namespaces, in addition to the all namespaces renewal, not used if zero
+}
+
+type imagePullCredentialList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []imagePullCredential `json:"items"`
+}
+
+func (in *imagePullCredential) DeepCopyInto(out *imagePullCredential) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Spec.NamespaceSelector != nil {
+		out.Spec.NamespaceSelector = in.Spec.NamespaceSelector.DeepCopy()
+	}
+}
+
+func (in *imagePullCredential) DeepCopy() *imagePullCredential {
+	if in == nil {
+		return nil
+	}
+	out := new(imagePullCredential)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *imagePullCredential) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *imagePullCredentialList) DeepCopyInto(out *imagePullCredentialList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		out.Items = make([]imagePullCredential, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *imagePullCredentialList) DeepCopy() *imagePullCredentialList {
+	if in == nil {
+		return nil
+	}
+	out := new(imagePullCredentialList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *imagePullCredentialList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// Get the pull secret name, defaults to the registry
+func (in *imagePullCredential) getTargetSecretName() string {
+	if in.Spec.TargetSecretName == "" {
+		return in.Spec.Registry
+	}
+
+	return in.Spec.TargetSecretName
+}
+
+// Get the pull secret type for the target secret format
+func (in *imagePullCredential) getTargetSecretType() corev1.SecretType {
+	if in.Spec.TargetSecretFormat == imagePullCredentialSecretFormatDockerCfg {
+		return corev1.SecretTypeDockercfg
+	}
+
+	return corev1.SecretTypeDockerConfigJson
+}
+
+// Does the image pull credential select the namespace, if there is no selector the registry label convention is used
+func (in *imagePullCredential) selects(ns corev1.Namespace) bool {
+	if in.Spec.NamespaceSelector == nil {
+		return ns.Labels[in.Spec.Registry] == "true"
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(in.Spec.NamespaceSelector)
+	return err == nil && selector.Matches(labels.Set(ns.Labels))
+}
+
+// Validate the image pull credential, invalid image pull credentials are ignored
+func (in *imagePullCredential) validate() error {
+	msgs := []string{}
+	if !namespaceSecretLabelKeyRegEx.MatchString(in.Spec.Registry) {
+		msgs = append(msgs, "registry must be an ECR registry host")
+	}
+	if in.Spec.Provider != "" && in.Spec.Provider != imagePullCredentialProviderECR {
+		msgs = append(msgs, "provider ["+in.Spec.Provider+"] is not supported")
+	}
+	if in.Spec.CredentialSecretRef.Name == "" {
+		msgs = append(msgs, "credential secret ref name is required")
+	}
+	if in.Spec.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(in.Spec.NamespaceSelector); err != nil {
+			msgs = append(msgs, "namespace selector is invalid: "+err.Error())
+		}
+	}
+	if errs := validation.IsDNS1123Subdomain(in.getTargetSecretName()); len(errs) > 0 {
+		msgs = append(msgs, "target secret name is invalid: "+strings.Join(errs, ", "))
+	}
+	if in.Spec.TargetSecretFormat != "" && in.Spec.TargetSecretFormat != imagePullCredentialSecretFormatDockerConfigJSON && in.Spec.TargetSecretFormat != imagePullCredentialSecretFormatDockerCfg {
+		msgs = append(msgs, "target secret format ["+in.Spec.TargetSecretFormat+"] is not supported")
+	}
+	if in.Spec.RenewalPolicy.Interval.Duration < 0 {
+		msgs = append(msgs, "renewal policy interval cannot be negative")
+	}
+
+	if len(msgs) > 0 {
+		return errors.Errorf("image pull credential [%s] is invalid: %s", in.Name, strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// Typed client for the image pull credential resource
+type imagePullCredentialClient struct {
+	restClient     rest.Interface
+	parameterCodec runtime.ParameterCodec
+}
+
+func newImagePullCredentialClient(config *rest.Config) (*imagePullCredentialClient, error) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(imagePullCredentialGroupVersion.WithKind("ImagePullCredential"), &imagePullCredential{})
+	scheme.AddKnownTypeWithName(imagePullCredentialGroupVersion.WithKind("ImagePullCredentialList"), &imagePullCredentialList{})
+	metav1.AddToGroupVersion(scheme, imagePullCredentialGroupVersion)
+
+	restConfig := *config
+	restConfig.GroupVersion = &imagePullCredentialGroupVersion
+	restConfig.APIPath = "/apis"
+	restConfig.ContentType = runtime.ContentTypeJSON
+	restConfig.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+	if restConfig.UserAgent == "" {
+		restConfig.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+
+	restClient, err := rest.RESTClientFor(&restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "create image pull credential REST client failed")
+	}
+
+	return &imagePullCredentialClient{restClient: restClient, parameterCodec: runtime.NewParameterCodec(scheme)}, nil
+}
+
+func (c *imagePullCredentialClient) List(opts metav1.ListOptions) (*imagePullCredentialList, error) {
+	res := &imagePullCredentialList{}
+	err := c.restClient.Get().Resource(imagePullCredentialResource).VersionedParams(&opts, c.parameterCodec).Do().Into(res)
+	return res, err
+}
+
+func (c *imagePullCredentialClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	opts.Watch = true
+	return c.restClient.Get().Resource(imagePullCredentialResource).VersionedParams(&opts, c.parameterCodec).Watch()
+}
+
+// Shared informer for image pull credentials, there are no generated informers for our resource
+func newImagePullCredentialInformer(client *imagePullCredentialClient, resyncInterval time.Duration) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				return client.List(opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				return client.Watch(opts)
+			},
+		},
+		&imagePullCredential{},
+		resyncInterval,
+		cache.Indexers{},
+	)
+}
+
+// Resource event handler for the image pull credential informer, renews the namespaces an image pull credential selects when it is added or changed
+// A deleted image pull credential results in an all namespaces renewal so its secrets are removed
+func (c *controller) newImagePullCredentialEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			ipc := obj.(*imagePullCredential)
+			glog.V(detailiedGLogLevel).Infof("Added image pull credential [%s]\n", ipc.Name)
+			c.Queue.Add(imagePullCredentialKeyPrefix + ipc.Name)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldIPC := oldObj.(*imagePullCredential)
+			newIPC := newObj.(*imagePullCredential)
+			if oldIPC.ResourceVersion != newIPC.ResourceVersion {
+				glog.V(detailiedGLogLevel).Infof("Updated image pull credential [%s]\n", newIPC.Name)
+				c.Queue.Add(imagePullCredentialKeyPrefix + newIPC.Name)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			glog.V(detailiedGLogLevel).Infoln("Deleted image pull credential")
+			c.Queue.Add(allNamespacesKey)
+		},
+	}
+}
+
+// Get the image pull credential name from a queue key, returns false if not an image pull credential key
+func getImagePullCredentialKeyName(key string) (string, bool) {
+	if !strings.HasPrefix(key, imagePullCredentialKeyPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(key, imagePullCredentialKeyPrefix), true
+}
+
+// Get the valid image pull credentials keyed by target secret name, empty if image pull credentials are not enabled
+// Invalid image pull credentials are skipped, as are image pull credentials whose target secret name is already taken, in name order
+func (c *controller) getImagePullCredentials(config config) (map[string]*imagePullCredential, error) {
+	res := map[string]*imagePullCredential{}
+	if !config.ImagePullCredentials {
+		return res, nil
+	}
+
+	items, err := c.listImagePullCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	for i := range items {
+		ipc := &items[i]
+		if err := ipc.validate(); err != nil {
+			glog.Warningf("Skipping image pull credential [%s]: %s\n", ipc.Name, err)
+			continue
+		}
+		targetSecretName := ipc.getTargetSecretName()
+		if existing, ok := res[targetSecretName]; ok {
+			glog.Warningf("Skipping image pull credential [%s], target secret name [%s] is already used by image pull credential [%s]\n", ipc.Name, targetSecretName, existing.Name)
+			continue
+		}
+		res[targetSecretName] = ipc
+	}
+
+	return res, nil
+}
+
+// List the image pull credentials from the informer's store, falls back to the API when there is no informer i.e. renewing once
+func (c *controller) listImagePullCredentials() ([]imagePullCredential, error) {
+	if c.Informers.ImagePullCredential == nil {
+		glog.V(detailiedGLogLevel).Infoln("Getting image pull credentials")
+		list, err := c.K8S.GetImagePullCredentials()
+		if err != nil {
+			return nil, errors.Wrap(err, "get image pull credentials failed")
+		}
+
+		return list.Items, nil
+	}
+
+	res := []imagePullCredential{}
+	for _, obj := range c.Informers.ImagePullCredential.GetStore().List() {
+		if ipc, ok := obj.(*imagePullCredential); ok {
+			res = append(res, *ipc.DeepCopy())
+		}
+	}
+
+	return res, nil
+}
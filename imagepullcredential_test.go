@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+func newTestImagePullCredential(name, registry string, selector map[string]string) *imagePullCredential {
+	ipc := &imagePullCredential{
+		ObjectMeta: metav1.ObjectMeta{Name: name, ResourceVersion: "1"},
+		Spec: imagePullCredentialSpec{
+			Registry:            registry,
+			CredentialSecretRef: corev1.SecretReference{Name: name + "-credentials"},
+		},
+	}
+	if selector != nil {
+		ipc.Spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: selector}
+	}
+
+	return ipc
+}
+
+func TestImagePullCredentialValidate(t *testing.T) {
+	for _, tc := range []struct {
+		Name          string                         // Test case name
+		Mutate        func(*imagePullCredentialSpec) // Change to make to a valid image pull credential
+		ExpectedError bool                           // Expect a validation error
+	}{
+		{
+			Name:   "Valid",
+			Mutate: func(spec *imagePullCredentialSpec) {},
+		},
+		{
+			Name:          "Non ECR registry",
+			Mutate:        func(spec *imagePullCredentialSpec) { spec.Registry = "docker.io" },
+			ExpectedError: true,
+		},
+		{
+			Name:          "Unsupported provider",
+			Mutate:        func(spec *imagePullCredentialSpec) { spec.Provider = "gcr" },
+			ExpectedError: true,
+		},
+		{
+			Name:          "No credential secret",
+			Mutate:        func(spec *imagePullCredentialSpec) { spec.CredentialSecretRef.Name = "" },
+			ExpectedError: true,
+		},
+		{
+			Name:          "Invalid target secret name",
+			Mutate:        func(spec *imagePullCredentialSpec) { spec.TargetSecretName = "Not_Valid" },
+			ExpectedError: true,
+		},
+		{
+			Name: "Legacy target secret format",
+			Mutate: func(spec *imagePullCredentialSpec) {
+				spec.TargetSecretFormat = imagePullCredentialSecretFormatDockerCfg
+			},
+		},
+		{
+			Name:          "Unsupported target secret format",
+			Mutate:        func(spec *imagePullCredentialSpec) { spec.TargetSecretFormat = "yaml" },
+			ExpectedError: true,
+		},
+		{
+			Name: "Invalid namespace selector",
+			Mutate: func(spec *imagePullCredentialSpec) {
+				spec.NamespaceSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: "Bogus"}}}
+			},
+			ExpectedError: true,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			ipc := newTestImagePullCredential("ipc-1", ecr1, nil)
+			tc.Mutate(&ipc.Spec)
+			err := ipc.validate()
+			assert.Equal(t, tc.ExpectedError, err != nil, "Validation error")
+		})
+	}
+}
+
+func TestImagePullCredentials(t *testing.T) {
+	config := getDefaultConfig()
+	config.ImagePullCredentials = true
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{"prod-credentials", "legacy-credentials", config.AWSCredentialsSecretPrefix + "-" + ecr1, config.AWSCredentialsSecretPrefix + "-" + ecr3},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{"environment": "production"},
+		},
+		{
+			Name:     ns2,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", ecr2: "true", ecr3: "true"},
+		},
+	})
+	prod := newTestImagePullCredential("prod", ecr1, map[string]string{"environment": "production"})
+	prod.Spec.TargetSecretName = "ecr-prod"
+	k8sClient.InsertNewImagePullCredentialRecord(prod)
+	legacy := newTestImagePullCredential("legacy", ecr2, nil)
+	legacy.Spec.TargetSecretFormat = imagePullCredentialSecretFormatDockerCfg
+	k8sClient.InsertNewImagePullCredentialRecord(legacy)
+	invalid := newTestImagePullCredential("invalid", ecr3, nil)
+	invalid.Spec.Provider = "gcr"
+	k8sClient.InsertNewImagePullCredentialRecord(invalid)
+	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
+	prometheusRegistry := prometheus.NewRegistry()
+	ecrClient := NewFakeECRClient()
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+	assert.Nil(t, err, "New controller error")
+
+	err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+	assert.Nil(t, err, "Renewal error")
+
+	// Selected by the namespace selector
+	assert.True(t, k8sClient.SecretExists(ns1, "ecr-prod"), "Image pull credential target secret exists")
+	// Registry label no longer enough once an image pull credential with a selector exists for the registry
+	assert.False(t, k8sClient.SecretExists(ns2, "ecr-prod"), "Unselected namespace target secret exists")
+	assert.False(t, k8sClient.SecretExists(ns2, ecr1), "Superseded label convention secret exists")
+	// No selector so the registry label selects the namespace
+	legacySecret, err := k8sClient.GetSecret(ns2, ecr2)
+	assert.Nil(t, err, "Legacy format secret error")
+	if legacySecret != nil {
+		assert.Equal(t, corev1.SecretTypeDockercfg, legacySecret.Type, "Legacy format secret type")
+		assert.NotEmpty(t, legacySecret.Data[corev1.DockerConfigKey], "Legacy format secret data")
+	}
+	// Invalid image pull credential is ignored, label convention is the fallback
+	assert.True(t, k8sClient.SecretExists(ns2, ecr3), "Label convention secret exists")
+}
+
+func TestImagePullCredentialRenewalPolicy(t *testing.T) {
+	config := getDefaultConfig()
+	config.ImagePullCredentials = true
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{"prod-credentials"},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{"environment": "production"},
+		},
+	})
+	prod := newTestImagePullCredential("prod", ecr1, map[string]string{"environment": "production"})
+	prod.Spec.RenewalPolicy.Interval = metav1.Duration{Duration: 100 * time.Millisecond}
+	k8sClient.InsertNewImagePullCredentialRecord(prod)
+	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
+	ipcInformer := NewFakeSharedInformer()
+	prometheusRegistry := prometheus.NewRegistry()
+	ecrClient := NewFakeECRClient()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer, ImagePullCredential: ipcInformer}, prometheusRegistry, ecrClient)
+	assert.Nil(t, err, "New controller error")
+
+	go ctrl.Run(ctx.Done())
+
+	ipcInformer.SimulateAddImagePullCredential(prod)
+	time.Sleep(350 * time.Millisecond)
+	assert.Equal(t, 1, k8sClient.NewlyCreatedSecretCount(), "Secret creation count")
+	assert.True(t, k8sClient.UpdatedSecretCount() >= 2, "Secret renewed on the renewal policy interval")
+}
+
+func TestGetImagePullCredentialsFromInformerStore(t *testing.T) {
+	config := getDefaultConfig()
+	config.ImagePullCredentials = true
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{{Name: config.HostNamespace, IsActive: true}})
+	k8sClient.GetImagePullCredentialsFn = func() (*imagePullCredentialList, error) {
+		t.Error("Image pull credentials listed via the API")
+		return &imagePullCredentialList{}, nil
+	}
+	ipcInformer := NewFakeSharedInformer()
+	ipcInformer.SimulateAddImagePullCredential(newTestImagePullCredential("prod", ecr1, nil))
+	ipcInformer.SimulateAddImagePullCredential(newTestImagePullCredential("dev", ecr2, nil))
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{ImagePullCredential: ipcInformer}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+
+	ipcs, err := ctrl.getImagePullCredentials(ctrl.Config)
+	assert.Nil(t, err, "Get image pull credentials error")
+	if assert.Equal(t, 2, len(ipcs), "Image pull credentials count") {
+		assert.Equal(t, "prod", ipcs[ecr1].Name, "Image pull credential for registry 1")
+		assert.Equal(t, "dev", ipcs[ecr2].Name, "Image pull credential for registry 2")
+	}
+}
+
+func TestImagePullCredentialClientList(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/apis/eatr.io/v1alpha1/imagepullcredentials", r.URL.Path, "Request path")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"apiVersion": "eatr.io/v1alpha1",
+			"kind": "ImagePullCredentialList",
+			"metadata": { "resourceVersion": "10" },
+			"items": [ {
+				"apiVersion": "eatr.io/v1alpha1",
+				"kind": "ImagePullCredential",
+				"metadata": { "name": "prod" },
+				"spec": {
+					"registry": "` + ecr1 + `",
+					"credentialSecretRef": { "name": "prod-credentials" },
+					"namespaceSelector": { "matchLabels": { "environment": "production" } },
+					"renewalPolicy": { "interval": "1h" }
+				}
+			} ]
+		}`))
+	}))
+	defer srv.Close()
+
+	client, err := newImagePullCredentialClient(&rest.Config{Host: srv.URL})
+	assert.Nil(t, err, "New client error")
+
+	list, err := client.List(metav1.ListOptions{})
+	assert.Nil(t, err, "List error")
+	if assert.Equal(t, 1, len(list.Items), "Items count") {
+		assert.Equal(t, "prod", list.Items[0].Name, "Name")
+		assert.Equal(t, ecr1, list.Items[0].Spec.Registry, "Registry")
+		assert.Equal(t, "production", list.Items[0].Spec.NamespaceSelector.MatchLabels["environment"], "Namespace selector")
+		assert.Equal(t, time.Hour, list.Items[0].Spec.RenewalPolicy.Interval.Duration, "Renewal policy interval")
+	}
+}
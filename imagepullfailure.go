@@ -0,0 +1,226 @@
+package main
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	imagePullFailureRenewalBackoff = time.Minute // Minimum time between renewals triggered by the same failing pod, the kubelet keeps updating the pod status while backing off
+)
+
+var (
+	imagePullFailureReasons = map[string]bool{"ErrImagePull": true, "ImagePullBackOff": true}
+	// Image pull failure messages that mean the registry rejected the credentials, rather than i.e. a missing repository or tag which a renewal cannot fix
+	imagePullAuthFailureRegEx = regexp.MustCompile(`(?i)no basic auth credentials|authorization token has expired|unauthorized|forbidden|\b40[13]\b`)
+)
+
+// Pods we have seen failing to pull an image from an ECR registry, keyed by namespace/name
+type imagePullFailures struct {
+	mutex sync.Mutex
+	pods  map[string]imagePullFailure
+}
+
+type imagePullFailure struct {
+	Namespace  string
+	Name       string
+	Registries []string // Failing ECR registries the namespace requests secrets for
+	DetectedAt time.Time
+	Owned      bool // Pod has an owner (i.e. a replica set) that will recreate it if deleted
+}
+
+func newImagePullFailures() *imagePullFailures {
+	return &imagePullFailures{pods: map[string]imagePullFailure{}}
+}
+
+// Resource event handler for the pod informer, enqueues the namespace for an urgent renewal when a pod fails to pull an image from an ECR registry the namespace requests
+// Only authorization failures are acted on, the kubelet's ErrImagePull message has the cause, the ImagePullBackOff message does not so the pod is acted on when it next retries
+// Failures for registries the namespace does not request are still tracked, so the backoff also limits how often we look up what the namespace requests
+func (c *controller) newImagePullFailureEventHandler() cache.ResourceEventHandler {
+	check := func(obj interface{}) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return
+		}
+
+		key := pod.Namespace + "/" + pod.Name
+		registries, failing := getPodECRImagePullAuthFailureRegistries(pod)
+		if !failing {
+			c.ImagePullFailures.remove(key)
+			return
+		}
+		if len(registries) == 0 {
+			// Still failing, i.e. backing off after an authorization failure, or a failure a renewal cannot fix
+			return
+		}
+
+		failure := imagePullFailure{Namespace: pod.Namespace, Name: pod.Name, DetectedAt: time.Now(), Owned: len(pod.OwnerReferences) > 0}
+		if !c.ImagePullFailures.add(key, failure) {
+			return
+		}
+		requested, err := c.getNamespaceRequestedRegistries(pod)
+		if err != nil {
+			glog.Warningf("Get ns [%s] requested registries failed, ignoring pod [%s] image pull failure: %s\n", pod.Namespace, pod.Name, err)
+			return
+		}
+		registries = requested.Intersection(sets.NewString(registries...)).List()
+		if len(registries) == 0 {
+			glog.V(detailiedGLogLevel).Infof("Ignoring ns [%s] pod [%s] image pull failure, namespace does not request the failing registries\n", pod.Namespace, pod.Name)
+			return
+		}
+		failure.Registries = registries
+		c.ImagePullFailures.update(key, failure)
+		for _, registry := range registries {
+			c.ImagePullFailuresDetectedCounter.WithLabelValues(pod.Namespace, registry).Inc()
+		}
+		glog.Infof("Detected ns [%s] pod [%s] image pull failure for %v, renewing\n", pod.Namespace, pod.Name, registries)
+		c.Queue.Add(pod.Namespace)
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    check,
+		UpdateFunc: func(oldObj, newObj interface{}) { check(newObj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				c.ImagePullFailures.remove(pod.Namespace + "/" + pod.Name)
+			}
+		},
+	}
+}
+
+// Get the ECR registries a pod's namespace requests secrets for and are allowed by policy, a pod's own registries are included if the namespace is eligible for discovery
+func (c *controller) getNamespaceRequestedRegistries(pod *corev1.Pod) (sets.String, error) {
+	config, registryPolicy := c.getConfigSnapshot()
+	if !isNamespaceInScope(config, pod.Namespace) {
+		return sets.NewString(), nil
+	}
+	ns, err := c.K8S.GetNamespace(pod.Namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get namespace [%s] failed", pod.Namespace)
+	}
+	imagePullCredentials, err := c.getImagePullCredentials(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "get image pull credentials failed")
+	}
+	inputs := &renewalInputs{DiscoveredRegistries: map[string]sets.String{}, ImagePullCredentials: imagePullCredentials}
+	if config.DiscoveryMode && isDiscoveryNamespace(config, pod.Namespace) {
+		inputs.DiscoveredRegistries[pod.Namespace] = sets.NewString(getPodSpecECRRegistryHosts(&pod.Spec)...)
+	}
+
+	res := sets.NewString()
+	allowed, _ := getAllowedNamespaceSecretNames(registryPolicy, *ns, inputs)
+	for _, k := range allowed {
+		res.Insert(inputs.getRegistry(k))
+	}
+
+	return res, nil
+}
+
+// Remediate a namespace's image pull failures after its secrets have been renewed, deletes the failing pods that have an owner so they are recreated
+// Only pods whose failing registries all had their secret written in this renewal are deleted, otherwise the recreated pod would fail in the same way
+func (c *controller) remediateImagePullFailures(nsName string, renewedRegistries sets.String) {
+	if !c.Config.DeleteImagePullFailurePods {
+		return
+	}
+
+	for _, failure := range c.ImagePullFailures.take(nsName) {
+		if !failure.Owned {
+			glog.V(detailiedGLogLevel).Infof("Not deleting ns [%s] pod [%s], has no owner to recreate it\n", failure.Namespace, failure.Name)
+			continue
+		}
+		if len(failure.Registries) == 0 || !renewedRegistries.HasAll(failure.Registries...) {
+			glog.V(detailiedGLogLevel).Infof("Not deleting ns [%s] pod [%s], image pull secrets for %v were not renewed\n", failure.Namespace, failure.Name, failure.Registries)
+			continue
+		}
+
+		glog.Infof("Deleting ns [%s] pod [%s] with image pull failure so it is recreated\n", failure.Namespace, failure.Name)
+		if err := c.K8S.DeletePod(failure.Namespace, failure.Name); err != nil && !k8serr.IsNotFound(err) {
+			glog.Warningf("Delete ns [%s] pod [%s] failed: %s\n", failure.Namespace, failure.Name, err)
+			continue
+		}
+		c.ImagePullFailuresRemediatedCounter.WithLabelValues(failure.Namespace).Inc()
+	}
+}
+
+// Add a failure, returns false if we already know about it and it was detected within the backoff
+func (f *imagePullFailures) add(key string, failure imagePullFailure) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if existing, ok := f.pods[key]; ok && failure.DetectedAt.Sub(existing.DetectedAt) < imagePullFailureRenewalBackoff {
+		return false
+	}
+	f.pods[key] = failure
+
+	return true
+}
+
+// Update a failure we already know about, keeps the detection time so the backoff is unchanged
+func (f *imagePullFailures) update(key string, failure imagePullFailure) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if existing, ok := f.pods[key]; ok {
+		failure.DetectedAt = existing.DetectedAt
+		f.pods[key] = failure
+	}
+}
+
+func (f *imagePullFailures) remove(key string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	delete(f.pods, key)
+}
+
+// Take all the failures for a namespace, these are removed
+func (f *imagePullFailures) take(nsName string) []imagePullFailure {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	res := []imagePullFailure{}
+	for key, failure := range f.pods {
+		if failure.Namespace == nsName {
+			res = append(res, failure)
+			delete(f.pods, key)
+		}
+	}
+
+	return res
+}
+
+// Get the distinct ECR registries for the pod's containers that are waiting due to an image pull authorization failure, and if any container is waiting due to an ECR image pull failure for any reason
+func getPodECRImagePullAuthFailureRegistries(pod *corev1.Pod) ([]string, bool) {
+	registries := []string{}
+	seen := map[string]bool{}
+	failing := false
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting == nil || !imagePullFailureReasons[status.State.Waiting.Reason] {
+			continue
+		}
+		host := getImageRegistryHost(status.Image)
+		if !namespaceSecretLabelKeyRegEx.MatchString(host) {
+			continue
+		}
+		failing = true
+		if imagePullAuthFailureRegEx.MatchString(status.State.Waiting.Message) && !seen[host] {
+			seen[host] = true
+			registries = append(registries, host)
+		}
+	}
+
+	return registries, failing
+}
@@ -3,8 +3,13 @@ package main
 import (
 	"github.com/pkg/errors"
 
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -12,7 +17,8 @@ import (
 
 // Subset so we can test, we can fake the subset of ClientSet that the controller needs
 type k8sClient struct {
-	ClientSet *kubernetes.Clientset
+	ClientSet            *kubernetes.Clientset
+	ImagePullCredentials *imagePullCredentialClient
 }
 
 func newK8sClient(configFilePath string) (*k8sClient, error) {
@@ -29,13 +35,66 @@ func newK8sClient(configFilePath string) (*k8sClient, error) {
 
 	clientSet := kubernetes.NewForConfigOrDie(config)
 
-	return &k8sClient{ClientSet: clientSet}, nil
+	imagePullCredentials, err := newImagePullCredentialClient(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "create k8s image pull credential client failed")
+	}
+
+	return &k8sClient{ClientSet: clientSet, ImagePullCredentials: imagePullCredentials}, nil
+}
+
+func (k *k8sClient) CreateConfigMap(ns string, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	return k.ClientSet.CoreV1().ConfigMaps(ns).Create(cm)
+}
+
+func (k *k8sClient) CreateEvent(ns string, e *corev1.Event) (*corev1.Event, error) {
+	return k.ClientSet.CoreV1().Events(ns).Create(e)
 }
 
 func (k *k8sClient) CreateSecret(ns string, s *corev1.Secret) (*corev1.Secret, error) {
 	return k.ClientSet.CoreV1().Secrets(ns).Create(s)
 }
 
+func (k *k8sClient) CreateSelfSubjectAccessReview(ssar *authorizationv1.SelfSubjectAccessReview) (*authorizationv1.SelfSubjectAccessReview, error) {
+	return k.ClientSet.AuthorizationV1().SelfSubjectAccessReviews().Create(ssar)
+}
+
+func (k *k8sClient) CreateSubjectAccessReview(sar *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+	return k.ClientSet.AuthorizationV1().SubjectAccessReviews().Create(sar)
+}
+
+func (k *k8sClient) CreateTokenReview(tr *authenticationv1.TokenReview) (*authenticationv1.TokenReview, error) {
+	return k.ClientSet.AuthenticationV1().TokenReviews().Create(tr)
+}
+
+func (k *k8sClient) DeleteConfigMap(ns, name string) error {
+	return k.ClientSet.CoreV1().ConfigMaps(ns).Delete(name, &metav1.DeleteOptions{})
+}
+
+func (k *k8sClient) DeletePod(ns, name string) error {
+	return k.ClientSet.CoreV1().Pods(ns).Delete(name, &metav1.DeleteOptions{})
+}
+
+func (k *k8sClient) DeleteSecret(ns, name string) error {
+	return k.ClientSet.CoreV1().Secrets(ns).Delete(name, &metav1.DeleteOptions{})
+}
+
+func (k *k8sClient) GetConfigMap(ns, name string) (*corev1.ConfigMap, error) {
+	return k.ClientSet.CoreV1().ConfigMaps(ns).Get(name, metav1.GetOptions{})
+}
+
+func (k *k8sClient) GetCronJobs(ns string) (*batchv1beta1.CronJobList, error) {
+	return k.ClientSet.BatchV1beta1().CronJobs(ns).List(metav1.ListOptions{})
+}
+
+func (k *k8sClient) GetDeployments(ns string) (*appsv1.DeploymentList, error) {
+	return k.ClientSet.AppsV1().Deployments(ns).List(metav1.ListOptions{})
+}
+
+func (k *k8sClient) GetImagePullCredentials() (*imagePullCredentialList, error) {
+	return k.ImagePullCredentials.List(metav1.ListOptions{})
+}
+
 func (k *k8sClient) GetNamespace(name string) (*corev1.Namespace, error) {
 	return k.ClientSet.CoreV1().Namespaces().Get(name, metav1.GetOptions{})
 }
@@ -44,6 +103,10 @@ func (k *k8sClient) GetNamespaces() (*corev1.NamespaceList, error) {
 	return k.ClientSet.CoreV1().Namespaces().List(metav1.ListOptions{})
 }
 
+func (k *k8sClient) GetPods(ns string) (*corev1.PodList, error) {
+	return k.ClientSet.CoreV1().Pods(ns).List(metav1.ListOptions{})
+}
+
 func (k *k8sClient) GetSecret(ns, name string) (*corev1.Secret, error) {
 	return k.ClientSet.CoreV1().Secrets(ns).Get(name, metav1.GetOptions{})
 }
@@ -52,6 +115,34 @@ func (k *k8sClient) GetSecrets(ns string) (*corev1.SecretList, error) {
 	return k.ClientSet.CoreV1().Secrets(ns).List(metav1.ListOptions{})
 }
 
+func (k *k8sClient) GetServiceAccounts(ns string) (*corev1.ServiceAccountList, error) {
+	return k.ClientSet.CoreV1().ServiceAccounts(ns).List(metav1.ListOptions{})
+}
+
+func (k *k8sClient) GetStatefulSets(ns string) (*appsv1.StatefulSetList, error) {
+	return k.ClientSet.AppsV1().StatefulSets(ns).List(metav1.ListOptions{})
+}
+
+func (k *k8sClient) PatchEvent(ns, name string, data []byte) (*corev1.Event, error) {
+	return k.ClientSet.CoreV1().Events(ns).Patch(name, types.StrategicMergePatchType, data)
+}
+
+func (k *k8sClient) UpdateConfigMap(ns string, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	return k.ClientSet.CoreV1().ConfigMaps(ns).Update(cm)
+}
+
+func (k *k8sClient) UpdateEvent(ns string, e *corev1.Event) (*corev1.Event, error) {
+	return k.ClientSet.CoreV1().Events(ns).Update(e)
+}
+
+func (k *k8sClient) UpdateNamespace(ns *corev1.Namespace) (*corev1.Namespace, error) {
+	return k.ClientSet.CoreV1().Namespaces().Update(ns)
+}
+
 func (k *k8sClient) UpdateSecret(ns string, s *corev1.Secret) (*corev1.Secret, error) {
 	return k.ClientSet.CoreV1().Secrets(ns).Update(s)
 }
+
+func (k *k8sClient) UpdateServiceAccount(ns string, sa *corev1.ServiceAccount) (*corev1.ServiceAccount, error) {
+	return k.ClientSet.CoreV1().ServiceAccounts(ns).Update(sa)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
@@ -17,7 +18,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 // See	https://blog.heptio.com/straighten-out-your-kubernetes-client-go-dependencies-heptioprotip-8baeed46fe7d
@@ -35,24 +39,32 @@ var (
 )
 
 func main() {
-	if err := runMain(); err != nil {
+	if err := runCommand(os.Args); err != nil {
 		glog.Error(err.Error())
 		os.Exit(2)
 	}
 }
 
-func runMain() error {
+// Run command, the controller
+func runMain(args []string) error {
 	defer glog.Flush()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	config, err := getConfig(os.Args)
+	config, err := getConfig(args, nil)
 	if err != nil {
 		return errors.Wrap(err, "getConfig failed")
 	}
+	if err = utilerrors.NewAggregate(validateConfig(config)); err != nil {
+		return errors.Wrap(err, "invalid config")
+	}
 	glog.Infof("Starting Version=%s Branch=%s RepoVersion=%s golang=%s\n", version, repoBranch, repoVersion, runtime.Version())
 
+	if config.RenewOnce {
+		return runRenewOnce(config)
+	}
+
 	glog.Infof("Starting listener on port %d\n", config.Port)
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", config.Port))
 	if err != nil {
@@ -72,21 +84,94 @@ func runMain() error {
 	informersFactory := informers.NewSharedInformerFactory(k8sClient.ClientSet, config.InformersResyncInterval)
 	nsInformer := informersFactory.Core().V1().Namespaces()
 
+	glog.Infoln("Newing up host namespace shared informer factory and secret informer")
+	hostInformersFactory := informers.NewFilteredSharedInformerFactory(k8sClient.ClientSet, config.InformersResyncInterval, config.HostNamespace, nil)
+	hostSecretInformer := hostInformersFactory.Core().V1().Secrets()
+
 	glog.Infoln("Getting prometheus registry and gatherer - defaults")
 	promRegistry := prometheus.DefaultRegisterer.(*prometheus.Registry)
 	promGatherer := prometheus.DefaultGatherer
 
 	glog.Infoln("Newing up controller")
-	controller, err := newController(config, k8sClient, nsInformer.Informer(), promRegistry, ecr)
+	ctrlInformers := controllerInformers{
+		Namespace:  nsInformer.Informer(),
+		HostSecret: hostSecretInformer.Informer(),
+	}
+	if config.PatchServiceAccounts {
+		glog.Infoln("Newing up service account informer")
+		ctrlInformers.ServiceAccount = informersFactory.Core().V1().ServiceAccounts().Informer()
+	}
+	if config.DiscoveryMode || config.ReactToImagePullFailures {
+		glog.Infoln("Newing up pod informer")
+		ctrlInformers.Pod = informersFactory.Core().V1().Pods().Informer()
+	}
+	if config.DiscoveryMode && config.DiscoveryIncludeWorkloads {
+		glog.Infoln("Newing up discovery workload informers")
+		ctrlInformers.Workloads = []cache.SharedInformer{
+			informersFactory.Apps().V1().Deployments().Informer(),
+			informersFactory.Apps().V1().StatefulSets().Informer(),
+			informersFactory.Batch().V1beta1().CronJobs().Informer(),
+		}
+	}
+	if config.ImagePullCredentials {
+		glog.Infoln("Newing up image pull credential informer")
+		ctrlInformers.ImagePullCredential = newImagePullCredentialInformer(k8sClient.ImagePullCredentials, config.InformersResyncInterval)
+	}
+	// Needs to be set before the controller's queue is created
+	workqueue.SetProvider(newWorkqueueMetricsProvider(promRegistry))
+	controller, err := newController(config, newInstrumentedK8SClient(k8sClient, promRegistry), ctrlInformers, promRegistry, ecr)
 	if err != nil {
 		return errors.Wrap(err, "newController failure")
 	}
+	controller.LoadConfig = newConfigLoader(args)
 
 	glog.Infoln("Newing up diagnostic HTTP server")
-	srv := newDiagnosticHTTPServer(promGatherer)
+	srv, err := newDiagnosticHTTPServer(promGatherer, controller)
+	if err != nil {
+		return errors.Wrap(err, "newDiagnosticHTTPServer failure")
+	}
+
+	if config.WebhookPort != 0 {
+		glog.Infof("Starting admission webhook listener on port %d\n", config.WebhookPort)
+		webhookListener, err := net.Listen("tcp", fmt.Sprintf(":%d", config.WebhookPort))
+		if err != nil {
+			return errors.Wrap(err, "admission webhook listener failed")
+		}
+
+		glog.Infoln("Newing up admission webhook HTTPS server")
+		webhookSrv, err := newAdmissionWebhookHTTPServer(controller, config.WebhookTLSCertFilePath, config.WebhookTLSKeyFilePath)
+		if err != nil {
+			return errors.Wrap(err, "newAdmissionWebhookHTTPServer failure")
+		}
+
+		glog.Infoln("Starting admission webhook HTTPS server go routine")
+		go func() {
+			// Certificates are already on the server's TLS config
+			if err := webhookSrv.ServeTLS(webhookListener, "", ""); err != http.ErrServerClosed {
+				glog.Errorf("Admission webhook HTTPS serve failed: %s\n", err)
+				return
+			}
+			glog.Infoln("Admission webhook HTTPS serve completed")
+		}()
+
+		glog.Infoln("Starting admission webhook HTTPS server graceful shutdown go routine")
+		go func() {
+			<-ctx.Done()
+			glog.Infoln("Shutting down admission webhook HTTPS server")
+			if err := webhookSrv.Shutdown(context.Background()); err != nil {
+				glog.Errorf("Admission webhook HTTPS server shutdown failed: %s\n", err)
+				return
+			}
+			glog.Infoln("Admission webhook HTTPS server shutdown completed")
+		}()
+	}
 
 	glog.Infoln("Starting informers factory")
 	informersFactory.Start(ctx.Done())
+	hostInformersFactory.Start(ctx.Done())
+	if ctrlInformers.ImagePullCredential != nil {
+		go ctrlInformers.ImagePullCredential.Run(ctx.Done())
+	}
 
 	glog.Infoln("Starting controller go routine")
 	go func() {
@@ -94,10 +179,21 @@ func runMain() error {
 		glog.Infoln("Controller run completed")
 	}()
 
+	if config.ConfigReloadInterval > 0 && (config.SettingsFilePath != "" || config.PolicyFilePath != "") {
+		glog.Infof("Starting config reload go routine, checking every %s\n", config.ConfigReloadInterval)
+		go controller.runConfigReloadLoop(config.ConfigReloadInterval, ctx.Done())
+	}
+
 	glog.Infoln("Starting diagnostic HTTP server go routine")
 	// PENDING: Can I use errgroup package, see https://godoc.org/golang.org/x/sync/errgroup
 	go func() error {
-		err := srv.Serve(listener)
+		var err error
+		if srv.TLSConfig != nil {
+			// Certificates are served by the server's TLS config
+			err = srv.ServeTLS(listener, "", "")
+		} else {
+			err = srv.Serve(listener)
+		}
 		if err != http.ErrServerClosed {
 			return errors.Wrap(err, "HTTP serve failed")
 		}
@@ -130,14 +226,46 @@ func runMain() error {
 	return nil
 }
 
-func newDiagnosticHTTPServer(promGatherer prometheus.Gatherer) *http.Server {
+// Single renewal with no informers, queue consumer or diagnostic HTTP server, the summary is written to stdout
+func runRenewOnce(config config) error {
+	controller, err := newCommandController(config)
+	if err != nil {
+		return err
+	}
+
+	return controller.renewOnce(os.Stdout)
+}
+
+// Metrics and health checks are always open, pprof and admin API routes require an authorised bearer token if diagnostic auth is configured
+func newDiagnosticHTTPServer(promGatherer prometheus.Gatherer, ctrl *controller) (*http.Server, error) {
+	protectedMux := http.NewServeMux()
+	if ctrl.Config.AdminAPI {
+		ctrl.addAdminRoutes(protectedMux)
+	}
+	protectedMux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	protectedMux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	protectedMux.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	protectedMux.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	protectedMux.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+	var protectedHandler http.Handler = protectedMux
+	if ctrl.Config.DiagnosticAuth {
+		protectedHandler = newDiagnosticAuthHandler(ctrl.K8S, protectedMux)
+	}
+
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.HandlerFor(promGatherer, promhttp.HandlerOpts{}))
-	mux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
-	mux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
-	mux.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
-	mux.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
-	mux.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+	mux.Handle("/healthz", newHealthCheckHandler(ctrl.checkLiveness))
+	mux.Handle("/readyz", newHealthCheckHandler(ctrl.checkReadiness))
+	mux.Handle("/", protectedHandler)
+
+	srv := &http.Server{Handler: mux}
+	if ctrl.Config.DiagnosticTLSCertFilePath != "" {
+		reloader, err := newCertificateReloader(ctrl.Config.DiagnosticTLSCertFilePath, ctrl.Config.DiagnosticTLSKeyFilePath)
+		if err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+	}
 
-	return &http.Server{Handler: mux}
+	return srv, nil
 }
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	defaultManifestsName = "eatr"
+)
+
+var (
+	// Flags that only make sense when running the binary locally, so are never rendered into the deployment args
+	manifestsExcludedConfigFlags = sets.NewString("config-file-path", "renew-once", settingsFilePathFlagName)
+)
+
+// Manifest options that are not part of the controller config
+type manifestOptions struct {
+	Image               string
+	Name                string // Name of the service account, RBAC objects, deployment, pod disruption budget and the prefix for the mounted config map and secrets
+	PodDisruptionBudget bool
+}
+
+// Manifests command, renders the manifests from the same flags the controller takes, so the RBAC matches the enabled features
+// The config is not validated as file paths are for the container, not the machine rendering the manifests
+func runManifestsCommand(args []string) error {
+	options := manifestOptions{}
+	config, err := getConfig(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&options.Image, "image", "pmcgrath/eatr:"+version, "Image for the deployment")
+		fs.StringVar(&options.Name, "name", defaultManifestsName, "Name for the service account, RBAC objects and deployment")
+		fs.BoolVar(&options.PodDisruptionBudget, "pod-disruption-budget", false, "Pod disruption budget - If set a pod disruption budget is also rendered")
+	})
+	if err != nil {
+		return errors.Wrap(err, "getConfig failed")
+	}
+
+	manifests, err := getManifests(config, options)
+	if err != nil {
+		return errors.Wrap(err, "get manifests failed")
+	}
+
+	return writeManifests(os.Stdout, manifests)
+}
+
+// Get the manifests, RBAC is derived from the permissions the enabled features need
+func getManifests(config config, options manifestOptions) ([]interface{}, error) {
+	labels := map[string]string{"name": options.Name}
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: options.Name, Namespace: config.HostNamespace}}
+
+	res := []interface{}{
+		&corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: metav1.ObjectMeta{Name: config.HostNamespace},
+		},
+		&corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: metav1.ObjectMeta{Name: options.Name, Namespace: config.HostNamespace},
+		},
+	}
+
+	clusterRules, namespacedRules := getPolicyRules(config)
+	res = append(res,
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: options.Name},
+			Rules:      clusterRules,
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: options.Name},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: options.Name},
+			Subjects:   subjects,
+		})
+	nsNames := []string{}
+	for nsName := range namespacedRules {
+		nsNames = append(nsNames, nsName)
+	}
+	sort.Strings(nsNames)
+	for _, nsName := range nsNames {
+		res = append(res,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Name: options.Name, Namespace: nsName},
+				Rules:      namespacedRules[nsName],
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: options.Name, Namespace: nsName},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: options.Name},
+				Subjects:   subjects,
+			})
+	}
+
+	deployment, err := getDeployment(config, options, labels)
+	if err != nil {
+		return nil, errors.Wrap(err, "get deployment failed")
+	}
+	res = append(res, deployment)
+	if options.PodDisruptionBudget {
+		// A single replica is run, so this allows node drains while making the disruption explicit
+		maxUnavailable := intstr.FromInt(1)
+		res = append(res, &policyv1beta1.PodDisruptionBudget{
+			TypeMeta:   metav1.TypeMeta{APIVersion: policyv1beta1.SchemeGroupVersion.String(), Kind: "PodDisruptionBudget"},
+			ObjectMeta: metav1.ObjectMeta{Name: options.Name, Namespace: config.HostNamespace, Labels: labels},
+			Spec: policyv1beta1.PodDisruptionBudgetSpec{
+				MaxUnavailable: &maxUnavailable,
+				Selector:       &metav1.LabelSelector{MatchLabels: labels},
+			},
+		})
+	}
+
+	return res, nil
+}
+
+// Get the cluster role rules and the role rules keyed by namespace
+// Namespaced resources are granted per namespace if the controller is scoped to namespaces, see getRequiredPermissions
+func getPolicyRules(config config) ([]rbacv1.PolicyRule, map[string][]rbacv1.PolicyRule) {
+	cluster := []rbacv1.PolicyRule{}
+	namespaced := map[string][]rbacv1.PolicyRule{}
+	for _, permission := range getRequiredPermissions(config) {
+		// Copy the verbs as merging appends to the rule's verbs
+		rule := rbacv1.PolicyRule{APIGroups: []string{permission.Group}, Resources: []string{permission.Resource}, Verbs: append([]string{}, permission.Verbs...)}
+		if permission.Namespace != "" {
+			namespaced[permission.Namespace] = mergePolicyRule(namespaced[permission.Namespace], rule)
+			continue
+		}
+		cluster = mergePolicyRule(cluster, rule)
+	}
+
+	return cluster, namespaced
+}
+
+// Add a rule, merging the verbs into an existing rule for the same group and resource
+func mergePolicyRule(rules []rbacv1.PolicyRule, rule rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	for i := range rules {
+		if rules[i].APIGroups[0] == rule.APIGroups[0] && rules[i].Resources[0] == rule.Resources[0] {
+			existing := sets.NewString(rules[i].Verbs...)
+			for _, verb := range rule.Verbs {
+				if !existing.Has(verb) {
+					rules[i].Verbs = append(rules[i].Verbs, verb)
+				}
+			}
+			return rules
+		}
+	}
+
+	return append(rules, rule)
+}
+
+// Get the deployment, the files the config references are mounted from a config map or secret named after the manifests name
+func getDeployment(config config, options manifestOptions, labels map[string]string) (*appsv1.Deployment, error) {
+	volumes, volumeMounts, err := getFileVolumes(config, options.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "get file volumes failed")
+	}
+
+	replicas := int32(1)
+	allowPrivilegeEscalation, privileged, readOnlyRootFilesystem, runAsNonRoot, runAsUser := false, false, true, true, int64(1000)
+	scheme := corev1.URISchemeHTTP
+	if config.DiagnosticTLSCertFilePath != "" {
+		scheme = corev1.URISchemeHTTPS
+	}
+	probe := func(path string) *corev1.Probe {
+		return &corev1.Probe{
+			Handler:       corev1.Handler{HTTPGet: &corev1.HTTPGetAction{Path: path, Port: intstr.FromInt(config.Port), Scheme: scheme}},
+			PeriodSeconds: 30,
+		}
+	}
+	ports := []corev1.ContainerPort{{Name: "diagnostics", ContainerPort: int32(config.Port)}}
+	if config.WebhookPort != 0 {
+		ports = append(ports, corev1.ContainerPort{Name: "webhook", ContainerPort: int32(config.WebhookPort)})
+	}
+
+	container := corev1.Container{
+		Name:            options.Name,
+		Image:           options.Image,
+		ImagePullPolicy: corev1.PullAlways,
+		Args:            getConfigArgs(config),
+		Ports:           ports,
+		LivenessProbe:   probe("/healthz"),
+		ReadinessProbe:  probe("/readyz"),
+		Resources: corev1.ResourceRequirements{
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("100Mi")},
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("50Mi")},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+			Privileged:               &privileged,
+			ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+			RunAsNonRoot:             &runAsNonRoot,
+			RunAsUser:                &runAsUser,
+		},
+		VolumeMounts: volumeMounts,
+	}
+	container.LivenessProbe.InitialDelaySeconds = 10
+
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: options.Name, Namespace: config.HostNamespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers:         []corev1.Container{container},
+					ServiceAccountName: options.Name,
+					Volumes:            volumes,
+				},
+			},
+		},
+	}, nil
+}
+
+// Get the volumes and mounts for the policy file and TLS cert and key files, each is mounted at its file's directory so updates to the config map or secret are seen
+// The policy config map key is the policy file name, the TLS secrets are kubernetes.io/tls secrets, so the tls.crt and tls.key keys are mapped to the file names
+func getFileVolumes(config config, name string) ([]corev1.Volume, []corev1.VolumeMount, error) {
+	volumes := []corev1.Volume{}
+	volumeMounts := []corev1.VolumeMount{}
+	mountFlagNames := map[string]string{} // Mount directory to the flag name that uses it
+
+	add := func(flagName, volumeName string, source corev1.VolumeSource, filePaths ...string) error {
+		dir := path.Dir(filePaths[0])
+		for _, filePath := range filePaths {
+			if !path.IsAbs(filePath) {
+				return errors.Errorf("%s [%s] must be an absolute path to be mounted", flagName, filePath)
+			}
+			if path.Dir(filePath) != dir {
+				return errors.Errorf("%s files must be in the same directory to be mounted", flagName)
+			}
+		}
+		if existing, ok := mountFlagNames[dir]; ok {
+			return errors.Errorf("%s directory [%s] is also used by %s, each needs its own directory to be mounted", flagName, dir, existing)
+		}
+		mountFlagNames[dir] = flagName
+		volumes = append(volumes, corev1.Volume{Name: volumeName, VolumeSource: source})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: volumeName, MountPath: dir, ReadOnly: true})
+		return nil
+	}
+	tlsSource := func(secretName, certFilePath, keyFilePath string) corev1.VolumeSource {
+		return corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{
+			SecretName: secretName,
+			Items: []corev1.KeyToPath{
+				{Key: corev1.TLSCertKey, Path: path.Base(certFilePath)},
+				{Key: corev1.TLSPrivateKeyKey, Path: path.Base(keyFilePath)},
+			},
+		}}
+	}
+
+	errs := []error{}
+	if config.PolicyFilePath != "" {
+		source := corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: name + "-policy"}}}
+		if err := add("policy-file-path", "policy", source, config.PolicyFilePath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if config.WebhookTLSCertFilePath != "" && config.WebhookTLSKeyFilePath != "" {
+		source := tlsSource(name+"-webhook-tls", config.WebhookTLSCertFilePath, config.WebhookTLSKeyFilePath)
+		if err := add("webhook-tls-cert-file-path", "webhook-tls", source, config.WebhookTLSCertFilePath, config.WebhookTLSKeyFilePath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if config.DiagnosticTLSCertFilePath != "" && config.DiagnosticTLSKeyFilePath != "" {
+		source := tlsSource(name+"-diagnostic-tls", config.DiagnosticTLSCertFilePath, config.DiagnosticTLSKeyFilePath)
+		if err := add("diagnostic-tls-cert-file-path", "diagnostic-tls", source, config.DiagnosticTLSCertFilePath, config.DiagnosticTLSKeyFilePath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return volumes, volumeMounts, utilerrors.NewAggregate(errs)
+}
+
+// Get the args for the config flags that differ from the defaults, so the deployment runs with the config the manifests were rendered from
+func getConfigArgs(config config) []string {
+	defaults := getDefaultConfig()
+	defaultsFS := flag.NewFlagSet("defaults", flag.ContinueOnError)
+	addConfigFlags(defaultsFS, &defaults)
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	addConfigFlags(fs, &config)
+
+	args := []string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if manifestsExcludedConfigFlags.Has(f.Name) || f.Value.String() == defaultsFS.Lookup(f.Name).Value.String() {
+			return
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
+	})
+
+	return args
+}
+
+// Write the manifests as YAML documents
+func writeManifests(w io.Writer, manifests []interface{}) error {
+	for i, manifest := range manifests {
+		data, err := yaml.Marshal(manifest)
+		if err != nil {
+			return errors.Wrap(err, "marshal manifest failed")
+		}
+		if i > 0 {
+			fmt.Fprintln(w, "---")
+		}
+		w.Write(data)
+	}
+
+	return nil
+}
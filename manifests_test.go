@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestPolicyRules(t *testing.T) {
+	for _, tc := range []struct {
+		Name                    string               // Test case name
+		Mutate                  func(config *config) // Change to make to the default config
+		ExpectedClusterRules    string               // Expected cluster role rules
+		ExpectedNamespacedRules map[string]string    // Expected role rules keyed by namespace
+	}{
+		{
+			Name:                    "Default config",
+			Mutate:                  func(config *config) {},
+			ExpectedClusterRules:    "/namespaces:get,list,watch /secrets:get,list,create,update,delete /events:create,patch,update",
+			ExpectedNamespacedRules: map[string]string{"ci-cd": "/secrets:watch /configmaps:get,create,update"},
+		},
+		{
+			Name: "Features enabled",
+			Mutate: func(config *config) {
+				config.DiagnosticAuth = true
+				config.PatchServiceAccounts = true
+				config.StatusConfigMapName = ""
+			},
+			ExpectedClusterRules:    "/namespaces:get,list,watch /secrets:get,list,create,update,delete /events:create,patch,update /serviceaccounts:list,watch,update authentication.k8s.io/tokenreviews:create authorization.k8s.io/subjectaccessreviews:create",
+			ExpectedNamespacedRules: map[string]string{"ci-cd": "/secrets:watch"},
+		},
+		{
+			Name:                 "Scoped to namespaces",
+			Mutate:               func(config *config) { config.Namespaces = ns1 },
+			ExpectedClusterRules: "/namespaces:get,list,watch",
+			ExpectedNamespacedRules: map[string]string{
+				"ci-cd": "/secrets:get,list,create,update,delete,watch /events:create,patch,update /configmaps:get,create,update",
+				ns1:     "/secrets:get,list,create,update,delete /events:create,patch,update",
+			},
+		},
+		{
+			Name: "Scoped to namespaces with informers",
+			Mutate: func(config *config) {
+				config.Namespaces = ns1
+				config.PatchServiceAccounts = true
+				config.ReactToImagePullFailures = true
+				config.DeleteImagePullFailurePods = true
+				config.StatusConfigMapName = ""
+			},
+			ExpectedClusterRules: "/namespaces:get,list,watch /serviceaccounts:list,watch /pods:list,watch",
+			ExpectedNamespacedRules: map[string]string{
+				"ci-cd": "/secrets:get,list,create,update,delete,watch /events:create,patch,update /serviceaccounts:update /pods:delete",
+				ns1:     "/secrets:get,list,create,update,delete /events:create,patch,update /serviceaccounts:update /pods:delete",
+			},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			config := getDefaultConfig()
+			tc.Mutate(&config)
+
+			cluster, namespaced := getPolicyRules(config)
+			assert.Equal(t, tc.ExpectedClusterRules, formatPolicyRules(cluster), "Cluster role rules")
+			assert.Equal(t, len(tc.ExpectedNamespacedRules), len(namespaced), "Roles")
+			for ns, expected := range tc.ExpectedNamespacedRules {
+				assert.Equal(t, expected, formatPolicyRules(namespaced[ns]), "Role rules for "+ns)
+			}
+		})
+	}
+}
+
+func TestConfigArgs(t *testing.T) {
+	config := getDefaultConfig()
+	assert.Empty(t, getConfigArgs(config), "Default config args")
+
+	config.KubeConfigFilePath = "/home/me/.kube/config"
+	config.MergedSecretName = "ecr"
+	config.PatchServiceAccounts = true
+	assert.Equal(t, []string{"-merged-secret-name=ecr", "-patch-service-accounts=true"}, getConfigArgs(config), "Changed config args")
+}
+
+func TestWriteManifests(t *testing.T) {
+	config := getDefaultConfig()
+	config.PolicyFilePath = "/etc/eatr/policy/policy.yaml"
+	config.WebhookPort = 8443
+	config.WebhookTLSCertFilePath = "/etc/eatr/webhook/cert.pem"
+	config.WebhookTLSKeyFilePath = "/etc/eatr/webhook/key.pem"
+	manifests, err := getManifests(config, manifestOptions{Image: "eatr:test", Name: "eatr", PodDisruptionBudget: true})
+	assert.Nil(t, err, "Get manifests error")
+	out := &bytes.Buffer{}
+	err = writeManifests(out, manifests)
+	assert.Nil(t, err, "Write manifests error")
+
+	docs := strings.Split(out.String(), "---\n")
+	assert.Equal(t, 8, len(docs), "Documents")
+	assert.Contains(t, out.String(), "apiVersion: rbac.authorization.k8s.io/v1\n", "RBAC API version")
+	assert.NotContains(t, out.String(), "v1beta1\nkind: ClusterRole", "Removed RBAC API version")
+	assert.Contains(t, out.String(), "-webhook-port=8443", "Deployment args")
+	assert.Contains(t, out.String(), "path: /readyz", "Readiness probe")
+	assert.Contains(t, out.String(), "kind: PodDisruptionBudget", "Pod disruption budget")
+	assert.Contains(t, out.String(), "mountPath: /etc/eatr/policy\n", "Policy volume mount")
+	assert.Contains(t, out.String(), "name: eatr-policy\n", "Policy config map volume")
+	assert.Contains(t, out.String(), "mountPath: /etc/eatr/webhook\n", "Webhook TLS volume mount")
+	assert.Contains(t, out.String(), "secretName: eatr-webhook-tls\n", "Webhook TLS secret volume")
+	assert.Contains(t, out.String(), "path: cert.pem\n", "Webhook TLS cert file name")
+}
+
+func TestFileVolumes(t *testing.T) {
+	for _, tc := range []struct {
+		Name          string               // Test case name
+		Mutate        func(config *config) // Change to make to the default config
+		ExpectedMount string               // Expected comma separated volume name:mount path pairs
+		ExpectedError string               // Expected error message fragment, empty if no error expected
+	}{
+		{
+			Name:   "No files",
+			Mutate: func(config *config) {},
+		},
+		{
+			Name: "Policy and diagnostic TLS",
+			Mutate: func(config *config) {
+				config.PolicyFilePath = "/etc/eatr/policy/policy.yaml"
+				config.DiagnosticTLSCertFilePath = "/etc/eatr/tls/tls.crt"
+				config.DiagnosticTLSKeyFilePath = "/etc/eatr/tls/tls.key"
+			},
+			ExpectedMount: "policy:/etc/eatr/policy,diagnostic-tls:/etc/eatr/tls",
+		},
+		{
+			Name: "Relative file path",
+			Mutate: func(config *config) {
+				config.PolicyFilePath = "policy.yaml"
+			},
+			ExpectedError: "policy-file-path [policy.yaml] must be an absolute path",
+		},
+		{
+			Name: "TLS cert and key in different directories",
+			Mutate: func(config *config) {
+				config.WebhookTLSCertFilePath = "/etc/eatr/cert/cert.pem"
+				config.WebhookTLSKeyFilePath = "/etc/eatr/key/key.pem"
+			},
+			ExpectedError: "webhook-tls-cert-file-path files must be in the same directory",
+		},
+		{
+			Name: "Shared directory",
+			Mutate: func(config *config) {
+				config.WebhookTLSCertFilePath = "/etc/eatr/tls/webhook.crt"
+				config.WebhookTLSKeyFilePath = "/etc/eatr/tls/webhook.key"
+				config.DiagnosticTLSCertFilePath = "/etc/eatr/tls/tls.crt"
+				config.DiagnosticTLSKeyFilePath = "/etc/eatr/tls/tls.key"
+			},
+			ExpectedError: "diagnostic-tls-cert-file-path directory [/etc/eatr/tls] is also used by webhook-tls-cert-file-path",
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			config := getDefaultConfig()
+			tc.Mutate(&config)
+
+			volumes, volumeMounts, err := getFileVolumes(config, "eatr")
+			if tc.ExpectedError != "" {
+				assert.NotNil(t, err, "Error")
+				assert.Contains(t, err.Error(), tc.ExpectedError, "Error message")
+				return
+			}
+			assert.Nil(t, err, "Error")
+			assert.Equal(t, len(volumes), len(volumeMounts), "Volumes and mounts")
+			mounts := []string{}
+			for _, volumeMount := range volumeMounts {
+				mounts = append(mounts, volumeMount.Name+":"+volumeMount.MountPath)
+			}
+			assert.Equal(t, tc.ExpectedMount, strings.Join(mounts, ","), "Mounts")
+		})
+	}
+}
+
+// Space separated [Group]/[Resource]:[Verbs] rules
+func formatPolicyRules(rules []rbacv1.PolicyRule) string {
+	res := []string{}
+	for _, rule := range rules {
+		res = append(res, rule.APIGroups[0]+"/"+rule.Resources[0]+":"+strings.Join(rule.Verbs, ","))
+	}
+
+	return strings.Join(res, " ")
+}
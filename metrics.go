@@ -0,0 +1,257 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Workqueue metrics provider, client-go only creates the queue metrics if a provider is set before the queue is created, see workqueue.SetProvider
+// Names and units match the kubernetes controller workqueue metrics, the queue name is the subsystem
+type workqueueMetricsProvider struct {
+	Registry prometheus.Registerer
+}
+
+func newWorkqueueMetricsProvider(registry prometheus.Registerer) *workqueueMetricsProvider {
+	return &workqueueMetricsProvider{Registry: registry}
+}
+
+func (p *workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{
+		Subsystem: name,
+		Name:      "depth",
+		Help:      "Current depth of workqueue: " + name,
+	})
+	p.Registry.MustRegister(depth)
+	return depth
+}
+
+func (p *workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	adds := prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: name,
+		Name:      "adds",
+		Help:      "Total number of adds handled by workqueue: " + name,
+	})
+	p.Registry.MustRegister(adds)
+	return adds
+}
+
+func (p *workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.SummaryMetric {
+	latency := prometheus.NewSummary(prometheus.SummaryOpts{
+		Subsystem: name,
+		Name:      "queue_latency",
+		Help:      "How long an item stays in workqueue " + name + " before being requested, in microseconds.",
+	})
+	p.Registry.MustRegister(latency)
+	return latency
+}
+
+func (p *workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.SummaryMetric {
+	workDuration := prometheus.NewSummary(prometheus.SummaryOpts{
+		Subsystem: name,
+		Name:      "work_duration",
+		Help:      "How long processing an item from workqueue " + name + " takes, in microseconds.",
+	})
+	p.Registry.MustRegister(workDuration)
+	return workDuration
+}
+
+func (p *workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	retries := prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: name,
+		Name:      "retries",
+		Help:      "Total number of retries handled by workqueue: " + name,
+	})
+	p.Registry.MustRegister(retries)
+	return retries
+}
+
+// K8S client decorator which counts API errors by operation and reason, not found is not counted as we expect it i.e. a secret that does not exist yet
+type instrumentedK8SClient struct {
+	K8S           k8sInterface
+	ErrorsCounter *prometheus.CounterVec
+}
+
+func newInstrumentedK8SClient(k8sClient k8sInterface, prometheusRegistry prometheus.Registerer) *instrumentedK8SClient {
+	errorsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubernetes_api_errors_total",
+		Help: "Number of kubernetes API errors.",
+	}, []string{"operation", "reason"})
+	prometheusRegistry.MustRegister(errorsCounter)
+
+	return &instrumentedK8SClient{K8S: k8sClient, ErrorsCounter: errorsCounter}
+}
+
+func (k *instrumentedK8SClient) countError(operation string, err error) {
+	if err == nil || k8serr.IsNotFound(err) {
+		return
+	}
+
+	reason := string(k8serr.ReasonForError(err))
+	if reason == "" {
+		reason = "Unknown"
+	}
+	k.ErrorsCounter.WithLabelValues(operation, reason).Inc()
+}
+
+func (k *instrumentedK8SClient) CreateConfigMap(ns string, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	res, err := k.K8S.CreateConfigMap(ns, cm)
+	k.countError("CreateConfigMap", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) CreateEvent(ns string, e *corev1.Event) (*corev1.Event, error) {
+	res, err := k.K8S.CreateEvent(ns, e)
+	k.countError("CreateEvent", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) CreateSecret(ns string, s *corev1.Secret) (*corev1.Secret, error) {
+	res, err := k.K8S.CreateSecret(ns, s)
+	k.countError("CreateSecret", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) CreateSelfSubjectAccessReview(ssar *authorizationv1.SelfSubjectAccessReview) (*authorizationv1.SelfSubjectAccessReview, error) {
+	res, err := k.K8S.CreateSelfSubjectAccessReview(ssar)
+	k.countError("CreateSelfSubjectAccessReview", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) CreateSubjectAccessReview(sar *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+	res, err := k.K8S.CreateSubjectAccessReview(sar)
+	k.countError("CreateSubjectAccessReview", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) CreateTokenReview(tr *authenticationv1.TokenReview) (*authenticationv1.TokenReview, error) {
+	res, err := k.K8S.CreateTokenReview(tr)
+	k.countError("CreateTokenReview", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) DeleteConfigMap(ns, name string) error {
+	err := k.K8S.DeleteConfigMap(ns, name)
+	k.countError("DeleteConfigMap", err)
+	return err
+}
+
+func (k *instrumentedK8SClient) DeletePod(ns, name string) error {
+	err := k.K8S.DeletePod(ns, name)
+	k.countError("DeletePod", err)
+	return err
+}
+
+func (k *instrumentedK8SClient) DeleteSecret(ns, name string) error {
+	err := k.K8S.DeleteSecret(ns, name)
+	k.countError("DeleteSecret", err)
+	return err
+}
+
+func (k *instrumentedK8SClient) GetConfigMap(ns, name string) (*corev1.ConfigMap, error) {
+	res, err := k.K8S.GetConfigMap(ns, name)
+	k.countError("GetConfigMap", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) GetCronJobs(ns string) (*batchv1beta1.CronJobList, error) {
+	res, err := k.K8S.GetCronJobs(ns)
+	k.countError("GetCronJobs", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) GetDeployments(ns string) (*appsv1.DeploymentList, error) {
+	res, err := k.K8S.GetDeployments(ns)
+	k.countError("GetDeployments", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) GetImagePullCredentials() (*imagePullCredentialList, error) {
+	res, err := k.K8S.GetImagePullCredentials()
+	k.countError("GetImagePullCredentials", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) GetNamespace(name string) (*corev1.Namespace, error) {
+	res, err := k.K8S.GetNamespace(name)
+	k.countError("GetNamespace", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) GetNamespaces() (*corev1.NamespaceList, error) {
+	res, err := k.K8S.GetNamespaces()
+	k.countError("GetNamespaces", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) GetPods(ns string) (*corev1.PodList, error) {
+	res, err := k.K8S.GetPods(ns)
+	k.countError("GetPods", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) GetSecret(ns, name string) (*corev1.Secret, error) {
+	res, err := k.K8S.GetSecret(ns, name)
+	k.countError("GetSecret", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) GetSecrets(ns string) (*corev1.SecretList, error) {
+	res, err := k.K8S.GetSecrets(ns)
+	k.countError("GetSecrets", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) GetServiceAccounts(ns string) (*corev1.ServiceAccountList, error) {
+	res, err := k.K8S.GetServiceAccounts(ns)
+	k.countError("GetServiceAccounts", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) GetStatefulSets(ns string) (*appsv1.StatefulSetList, error) {
+	res, err := k.K8S.GetStatefulSets(ns)
+	k.countError("GetStatefulSets", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) PatchEvent(ns, name string, data []byte) (*corev1.Event, error) {
+	res, err := k.K8S.PatchEvent(ns, name, data)
+	k.countError("PatchEvent", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) UpdateConfigMap(ns string, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	res, err := k.K8S.UpdateConfigMap(ns, cm)
+	k.countError("UpdateConfigMap", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) UpdateEvent(ns string, e *corev1.Event) (*corev1.Event, error) {
+	res, err := k.K8S.UpdateEvent(ns, e)
+	k.countError("UpdateEvent", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) UpdateNamespace(ns *corev1.Namespace) (*corev1.Namespace, error) {
+	res, err := k.K8S.UpdateNamespace(ns)
+	k.countError("UpdateNamespace", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) UpdateSecret(ns string, s *corev1.Secret) (*corev1.Secret, error) {
+	res, err := k.K8S.UpdateSecret(ns, s)
+	k.countError("UpdateSecret", err)
+	return res, err
+}
+
+func (k *instrumentedK8SClient) UpdateServiceAccount(ns string, sa *corev1.ServiceAccount) (*corev1.ServiceAccount, error) {
+	res, err := k.K8S.UpdateServiceAccount(ns, sa)
+	k.countError("UpdateServiceAccount", err)
+	return res, err
+}
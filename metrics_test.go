@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func getTestMetricFamilyNames(t *testing.T, prometheusRegistry *prometheus.Registry) map[string]bool {
+	mfs, err := prometheusRegistry.Gather()
+	assert.Nil(t, err, "Gather error")
+
+	res := map[string]bool{}
+	for _, mf := range mfs {
+		res[mf.GetName()] = true
+	}
+	return res
+}
+
+func TestMetrics(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", ecr3: "true"},
+		},
+	})
+	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
+	prometheusRegistry := prometheus.NewRegistry()
+	ecrClient := NewFakeECRClient()
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+	assert.Nil(t, err, "New controller error")
+
+	err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+	assert.Nil(t, err, "Renewal error")
+
+	metric := &dto.Metric{}
+	ctrl.SecretTokenExpiryGauge.WithLabelValues(ns1, ecr1).Write(metric)
+	assert.True(t, metric.GetGauge().GetValue() > float64(time.Now().Unix()), "Secret token expiry")
+
+	metric = &dto.Metric{}
+	ctrl.RegistryLastRenewalGauge.WithLabelValues(ecr1).Write(metric)
+	assert.True(t, metric.GetGauge().GetValue() >= float64(time.Now().Add(-time.Minute).Unix()), "Registry last successful renewal")
+
+	metric = &dto.Metric{}
+	ctrl.ECRErrorsCounter.WithLabelValues(ecr3, credentialsMissingEventReason).Write(metric)
+	assert.Equal(t, float64(1), metric.GetCounter().GetValue(), "ECR errors count")
+
+	metric = &dto.Metric{}
+	ctrl.ECRRequestDurationHistogram.WithLabelValues(ecr1).Write(metric)
+	assert.Equal(t, uint64(1), metric.GetHistogram().GetSampleCount(), "ECR request duration sample count")
+
+	metric = &dto.Metric{}
+	ctrl.RenewalDurationHistogram.Write(metric)
+	assert.Equal(t, uint64(1), metric.GetHistogram().GetSampleCount(), "Renewal duration sample count")
+
+	// Expiry is no longer reported once the secret is deleted
+	ns, _ := k8sClient.GetNamespace(ns1)
+	ns.Labels = map[string]string{}
+	k8sClient.UpdateNamespaceRecord(ns)
+	err = ctrl.renewECRImagePullSecrets(ns1)
+	assert.Nil(t, err, "Renewal error")
+	assert.False(t, getTestMetricFamilyNames(t, prometheusRegistry)["secret_token_expiry_timestamp_seconds"], "Secret token expiry metric exists")
+}
+
+func TestWorkqueueMetricsProvider(t *testing.T) {
+	prometheusRegistry := prometheus.NewRegistry()
+	provider := newWorkqueueMetricsProvider(prometheusRegistry)
+
+	provider.NewDepthMetric(queueName).Inc()
+	provider.NewAddsMetric(queueName).Inc()
+	provider.NewLatencyMetric(queueName).Observe(1)
+	provider.NewWorkDurationMetric(queueName).Observe(1)
+	provider.NewRetriesMetric(queueName).Inc()
+
+	names := getTestMetricFamilyNames(t, prometheusRegistry)
+	for _, name := range []string{"eatr_depth", "eatr_adds", "eatr_queue_latency", "eatr_work_duration", "eatr_retries"} {
+		assert.True(t, names[name], "Workqueue metric %s exists", name)
+	}
+}
+
+func TestInstrumentedK8SClient(t *testing.T) {
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{{Name: ns1, IsActive: true}})
+	k8sClient.CreateSecretFn = func(ns string, s *corev1.Secret) (*corev1.Secret, error) {
+		return nil, k8serr.NewForbidden(corev1.Resource("secrets"), s.Name, errors.New("no access"))
+	}
+	prometheusRegistry := prometheus.NewRegistry()
+	instrumentedClient := newInstrumentedK8SClient(k8sClient, prometheusRegistry)
+
+	_, err := instrumentedClient.GetSecret(ns1, "does-not-exist")
+	assert.NotNil(t, err, "Get secret error")
+	_, err = instrumentedClient.CreateSecret(ns1, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "sec-1"}})
+	assert.NotNil(t, err, "Create secret error")
+
+	metric := &dto.Metric{}
+	instrumentedClient.ErrorsCounter.WithLabelValues("GetSecret", string(metav1.StatusReasonNotFound)).Write(metric)
+	assert.Equal(t, float64(0), metric.GetCounter().GetValue(), "Not found errors count")
+	metric = &dto.Metric{}
+	instrumentedClient.ErrorsCounter.WithLabelValues("CreateSecret", string(metav1.StatusReasonForbidden)).Write(metric)
+	assert.Equal(t, float64(1), metric.GetCounter().GetValue(), "Forbidden errors count")
+}
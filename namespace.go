@@ -0,0 +1,311 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	namespaceSecretPollInterval     = 2 * time.Second
+	registryLabelInvalidEventReason = "RegistryLabelInvalid"
+)
+
+var (
+	// Label keys that look like they were meant to be an ECR registry host, used to warn about typos that would otherwise silently never match
+	suspectECRHostRegEx = regexp.MustCompile(`(?i)(\.dkr\.|\.ecr\.|amazonaws\.com)`)
+)
+
+func getNamespaceCommands() []command {
+	return []command{
+		{Name: "disable", Description: "Remove a namespace's registry label, the controller then removes the namespace secret", Run: runDisableNamespaceCommand},
+		{Name: "enable", Description: "Label a namespace for a registry, after checking the AWS credentials secret the namespace needs exists, optionally waiting for the namespace secret", Run: runEnableNamespaceCommand},
+		{Name: "list", Description: "List the namespaces requesting registries, including labels that look like mistyped registry hosts", Run: runListNamespacesCommand},
+	}
+}
+
+// Namespace command, replaces hand typed kubectl label commands
+func runNamespaceCommand(args []string) error {
+	return dispatchCommand(args, getNamespaceCommands(), "")
+}
+
+// Enable command
+func runEnableNamespaceCommand(args []string) error {
+	var nsName, registry string
+	var force bool
+	var wait time.Duration
+	config, err := getConfig(args, func(fs *flag.FlagSet) {
+		addNamespaceRegistryFlags(fs, &nsName, &registry)
+		fs.BoolVar(&force, "force", false, "Force - If set the namespace is labelled even if the AWS credentials secret the namespace needs is missing or incomplete")
+		fs.DurationVar(&wait, "wait", 0, "Wait - How long to wait for the controller to write the namespace secret, does not wait if not set")
+	})
+	if err != nil {
+		return errors.Wrap(err, "getConfig failed")
+	}
+
+	ctrl, err := newCommandController(config)
+	if err != nil {
+		return err
+	}
+
+	if err = ctrl.enableNamespaceRegistry(nsName, registry, force); err != nil {
+		return err
+	}
+	if ctrl.DryRun != nil {
+		writePlan(os.Stdout, ctrl.DryRun.plan())
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "Labelled namespace [%s] for registry [%s]\n", nsName, registry)
+	if wait == 0 {
+		return nil
+	}
+
+	secretName, err := ctrl.waitForNamespaceSecret(nsName, registry, wait)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Namespace [%s] secret [%s] has been written\n", nsName, secretName)
+	return nil
+}
+
+// Disable command
+func runDisableNamespaceCommand(args []string) error {
+	var nsName, registry string
+	config, err := getConfig(args, func(fs *flag.FlagSet) {
+		addNamespaceRegistryFlags(fs, &nsName, &registry)
+	})
+	if err != nil {
+		return errors.Wrap(err, "getConfig failed")
+	}
+
+	ctrl, err := newCommandController(config)
+	if err != nil {
+		return err
+	}
+
+	if err = ctrl.disableNamespaceRegistry(nsName, registry); err != nil {
+		return err
+	}
+	if ctrl.DryRun != nil {
+		writePlan(os.Stdout, ctrl.DryRun.plan())
+		return nil
+	}
+
+	fmt.Fprintf(os.Stdout, "Removed namespace [%s] registry [%s] label\n", nsName, registry)
+	return nil
+}
+
+// List command
+func runListNamespacesCommand(args []string) error {
+	config, err := getConfig(args, nil)
+	if err != nil {
+		return errors.Wrap(err, "getConfig failed")
+	}
+
+	ctrl, err := newCommandController(config)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.writeNamespaceRegistries(os.Stdout)
+}
+
+func addNamespaceRegistryFlags(fs *flag.FlagSet, nsName, registry *string) {
+	fs.StringVar(nsName, "namespace", "", "Namespace to label")
+	fs.StringVar(registry, "registry", "", "Registry, i.e. 123456789012.dkr.ecr.eu-west-1.amazonaws.com")
+}
+
+// Label a namespace for a registry, the registry must be an ECR host and the AWS credentials secret for the namespace's credential set must exist with the expected keys unless forced
+func (c *controller) enableNamespaceRegistry(nsName, registry string, force bool) error {
+	if _, _, err := parseECRRegistry(registry); err != nil {
+		return err
+	}
+
+	ns, err := c.K8S.GetNamespace(nsName)
+	if err != nil {
+		return errors.Wrapf(err, "get namespace [%s] failed", nsName)
+	}
+	if !force {
+		request := credentialRequest{CredentialSet: getNamespaceCredentialSet(*ns), SecretName: registry}
+		if _, err := c.checkCredentialRequest(request); err != nil {
+			return errors.Wrap(err, "AWS credentials check failed, use force to label anyway")
+		}
+	}
+	if ns.Labels[registry] == "true" {
+		return nil
+	}
+
+	if ns.Labels == nil {
+		ns.Labels = map[string]string{}
+	}
+	ns.Labels[registry] = "true"
+	if _, err := c.K8S.UpdateNamespace(ns); err != nil {
+		return errors.Wrapf(err, "update namespace [%s] failed", nsName)
+	}
+
+	return nil
+}
+
+// Remove a namespace's registry label, the controller removes the namespace secret on its next renewal of the namespace
+func (c *controller) disableNamespaceRegistry(nsName, registry string) error {
+	ns, err := c.K8S.GetNamespace(nsName)
+	if err != nil {
+		return errors.Wrapf(err, "get namespace [%s] failed", nsName)
+	}
+	if _, ok := ns.Labels[registry]; !ok {
+		return errors.Errorf("namespace [%s] is not labelled for registry [%s]", nsName, registry)
+	}
+
+	delete(ns.Labels, registry)
+	if _, err := c.K8S.UpdateNamespace(ns); err != nil {
+		return errors.Wrapf(err, "update namespace [%s] failed", nsName)
+	}
+
+	return nil
+}
+
+// Wait for the controller to write the namespace secret for a registry, this is the merged secret if configured, returns the secret name
+func (c *controller) waitForNamespaceSecret(nsName, registry string, wait time.Duration) (string, error) {
+	secretName := registry
+	if c.Config.MergedSecretName != "" {
+		secretName = c.Config.MergedSecretName
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		written, err := c.isNamespaceSecretWritten(nsName, secretName, registry)
+		if err != nil {
+			return "", err
+		}
+		if written {
+			return secretName, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return "", errors.Errorf("namespace [%s] secret [%s] was not written within %s, check the namespace events", nsName, secretName, wait)
+		}
+		if remaining > namespaceSecretPollInterval {
+			remaining = namespaceSecretPollInterval
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// The secret must be managed by us or a pre-upgrade secret we will adopt, a merged or pre-upgrade secret must also have an entry for the registry
+func (c *controller) isNamespaceSecretWritten(nsName, secretName, registry string) (bool, error) {
+	sec, err := c.K8S.GetSecret(nsName, secretName)
+	if err != nil {
+		if k8serr.IsNotFound(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "get namespace [%s] secret [%s] failed", nsName, secretName)
+	}
+	managed := sec.Labels[managedByLabelKey] == managedByLabelValue
+	if !managed && !isPreUpgradeSecret(sec) {
+		return false, nil
+	}
+	if managed && secretName == registry {
+		return true, nil
+	}
+
+	merged := newDockerConfigJSON()
+	if err := merged.mergeSecretData(sec.Data[corev1.DockerConfigJsonKey]); err != nil {
+		return false, nil
+	}
+	for endpoint := range merged.Auths {
+		if strings.Contains(endpoint, registry) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Write the namespaces with registry labels, and those with labels that look like mistyped registry hosts
+func (c *controller) writeNamespaceRegistries(w io.Writer) error {
+	nss, err := c.K8S.GetNamespaces()
+	if err != nil {
+		return errors.Wrap(err, "get namespaces failed")
+	}
+	sort.Slice(nss.Items, func(i, j int) bool { return nss.Items[i].Name < nss.Items[j].Name })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tCREDENTIAL SET\tREGISTRIES\tPROBLEMS")
+	for _, ns := range nss.Items {
+		registries := []string{}
+		for k, v := range ns.Labels {
+			if v == "true" && namespaceSecretLabelKeyRegEx.MatchString(k) {
+				registries = append(registries, k)
+			}
+		}
+		problems := getRegistryLabelProblems(ns)
+		if len(registries) == 0 && len(problems) == 0 {
+			continue
+		}
+		sort.Strings(registries)
+
+		credentialSet := getNamespaceCredentialSet(ns)
+		if credentialSet == "" {
+			credentialSet = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", ns.Name, credentialSet, strings.Join(registries, ","), strings.Join(problems, "; "))
+	}
+	tw.Flush()
+
+	return nil
+}
+
+// Get the namespace's registry label problems, labels that look like a registry host but do not match, or match with a value other than true, both are otherwise silently ignored
+func getRegistryLabelProblems(ns corev1.Namespace) []string {
+	keys := []string{}
+	for k := range ns.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	problems := []string{}
+	for _, k := range keys {
+		v := ns.Labels[k]
+		if namespaceSecretLabelKeyRegEx.MatchString(k) {
+			if v != "true" {
+				problems = append(problems, fmt.Sprintf("label [%s] value [%s] is ignored, must be true", k, v))
+			}
+			continue
+		}
+		if suspectECRHostRegEx.MatchString(k) {
+			problems = append(problems, fmt.Sprintf("label [%s] looks like a mistyped ECR registry host, hosts take the form [AccountId].dkr.ecr.[Region].amazonaws.com", k))
+		}
+	}
+
+	return problems
+}
+
+// Warn about a namespace's registry label problems, only problems not in the previous version of the namespace are reported so relabelling does not repeat them
+func (c *controller) warnRegistryLabelProblems(previous *corev1.Namespace, ns corev1.Namespace) {
+	reported := sets.NewString()
+	if previous != nil {
+		reported.Insert(getRegistryLabelProblems(*previous)...)
+	}
+
+	for _, problem := range getRegistryLabelProblems(ns) {
+		if reported.Has(problem) {
+			continue
+		}
+		glog.Warningf("Namespace [%s] %s\n", ns.Name, problem)
+		c.Recorder.namespaceEvent(ns, corev1.EventTypeWarning, registryLabelInvalidEventReason, problem)
+	}
+}
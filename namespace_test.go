@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRegistryLabelProblems(t *testing.T) {
+	for _, tc := range []struct {
+		Name             string            // Test case name
+		Labels           map[string]string // Namespace labels
+		ExpectedProblems int               // Expected number of problems
+	}{
+		{
+			Name:   "Valid labels",
+			Labels: map[string]string{ecr1: "true", credentialSetLabelKey: "team-a", "team": "a"},
+		},
+		{
+			Name:             "Value not true",
+			Labels:           map[string]string{ecr1: "True"},
+			ExpectedProblems: 1,
+		},
+		{
+			Name:             "Mistyped hosts",
+			Labels:           map[string]string{"12345678901.dkr.ecr.eu-west-1.amazonaws.com": "true", "123456789012.dkr.ecr.eu-west-1.amazonaws": "true", "123456789012.dkr.ecr.eu-west-1.amazonaws.com.cn": "true"},
+			ExpectedProblems: 3,
+		},
+		{
+			Name:   "Look alike not an ECR host",
+			Labels: map[string]string{"secret-rotation": "true"},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns1, Labels: tc.Labels}}
+			assert.Equal(t, tc.ExpectedProblems, len(getRegistryLabelProblems(ns)), "Problems")
+		})
+	}
+}
+
+func TestWarnRegistryLabelProblems(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{{Name: ns1, IsActive: true}})
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+
+	previous := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns1, Labels: map[string]string{ecr1: "yes"}}}
+	ctrl.warnRegistryLabelProblems(nil, previous)
+	assert.Equal(t, "Warning:"+registryLabelInvalidEventReason, k8sClient.WaitForEventReasons(ns1, "Warning:"+registryLabelInvalidEventReason), "Added namespace events")
+
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns1, Labels: map[string]string{ecr1: "yes", "team": "a"}}}
+	ctrl.warnRegistryLabelProblems(&previous, ns)
+	assert.Equal(t, "Warning:"+registryLabelInvalidEventReason, k8sClient.EventReasons(ns1), "Unchanged problem events")
+}
+
+func TestNamespaceRegistry(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{"12345678901.dkr.ecr.eu-west-1.amazonaws.com": "true"},
+		},
+	})
+	k8sClient.UpdateSecret(config.HostNamespace, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: config.AWSCredentialsSecretPrefix + "-" + ecr1},
+		Data:       map[string][]byte{"aws_access_key_id": []byte("id"), "aws_region": []byte("eu-west-1"), "aws_secret_access_key": []byte("secret")},
+	})
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+
+	assert.NotNil(t, ctrl.enableNamespaceRegistry(ns1, "registry.example.com", false), "Non ECR registry error")
+	assert.NotNil(t, ctrl.enableNamespaceRegistry(ns1, ecr2, false), "Missing credentials error")
+	assert.NotNil(t, ctrl.enableNamespaceRegistry(ns4, ecr1, false), "Missing namespace error")
+	assert.Nil(t, ctrl.enableNamespaceRegistry(ns1, ecr1, false), "Enable error")
+	ns, _ := k8sClient.GetNamespace(ns1)
+	assert.Equal(t, "true", ns.Labels[ecr1], "Registry label")
+
+	_, err = ctrl.waitForNamespaceSecret(ns1, ecr1, time.Millisecond)
+	assert.NotNil(t, err, "Wait for unwritten secret error")
+	err = ctrl.renewECRImagePullSecrets(ns1)
+	assert.Nil(t, err, "Renewal error")
+	secretName, err := ctrl.waitForNamespaceSecret(ns1, ecr1, time.Millisecond)
+	assert.Nil(t, err, "Wait for written secret error")
+	assert.Equal(t, ecr1, secretName, "Written secret name")
+
+	out := &bytes.Buffer{}
+	err = ctrl.writeNamespaceRegistries(out)
+	assert.Nil(t, err, "List error")
+	assert.Contains(t, out.String(), ecr1, "Namespace registries")
+	assert.Contains(t, out.String(), "looks like a mistyped ECR registry host", "Namespace problems")
+
+	assert.Nil(t, ctrl.disableNamespaceRegistry(ns1, ecr1), "Disable error")
+	ns, _ = k8sClient.GetNamespace(ns1)
+	_, ok := ns.Labels[ecr1]
+	assert.False(t, ok, "Registry label removed")
+	assert.NotNil(t, ctrl.disableNamespaceRegistry(ns1, ecr1), "Disable unlabelled error")
+}
+
+func TestWaitForPreUpgradeNamespaceSecret(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+		},
+		{
+			Name:              ns1,
+			IsActive:          true,
+			Labels:            map[string]string{ecr1: "true", ecr2: "true"},
+			PreUpgradeSecrets: []string{ecr1},
+		},
+	})
+	// Unlabelled secret with no entry for the registry
+	k8sClient.CreateSecret(ns1, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: ecr2},
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{ "auths": {} }`)},
+		Type:       corev1.SecretTypeDockerConfigJson,
+	})
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+
+	secretName, err := ctrl.waitForNamespaceSecret(ns1, ecr1, time.Millisecond)
+	assert.Nil(t, err, "Wait for pre-upgrade secret error")
+	assert.Equal(t, ecr1, secretName, "Pre-upgrade secret name")
+	_, err = ctrl.waitForNamespaceSecret(ns1, ecr2, time.Millisecond)
+	assert.NotNil(t, err, "Wait for pre-upgrade secret with no registry entry error")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	policyDeniedEventReason = "RegistryDenied"
+)
+
+// Policy controlling which namespaces may request which registries and use which credential sets, loaded from a YAML or JSON file which can be a mounted config map
+// Registries and credential sets that no rule matches are allowed unless default deny is set
+type policy struct {
+	DefaultDeny    bool                `json:"defaultDeny"`
+	Rules          []policyRule        `json:"rules"`
+	CredentialSets []credentialSetRule `json:"credentialSets"`
+}
+
+// A registry can be a pattern, see path.Match, a namespace is allowed if its name matches one of the namespace patterns or its labels match the selector
+type policyRule struct {
+	Registry          string                `json:"registry"`
+	Namespaces        []string              `json:"namespaces"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
+
+	selector labels.Selector
+}
+
+// A credential set can be a pattern, see path.Match, a namespace may use the credential set if its name matches one of the namespace patterns or its labels match the selector
+// The credential set label can be set by anyone who can label a namespace, so without these rules a namespace could use another team's AWS credentials
+type credentialSetRule struct {
+	CredentialSet     string                `json:"credentialSet"`
+	Namespaces        []string              `json:"namespaces"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
+
+	selector labels.Selector
+}
+
+// Load the policy file, a nil policy which allows everything is returned if no file path is configured
+// Unknown keys are rejected, as a mistyped key would otherwise be ignored and the policy would allow more than intended
+func loadPolicy(filePath string) (*policy, error) {
+	if filePath == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read policy file [%s] failed", filePath)
+	}
+	// YAML is converted to JSON so the json tags are used, JSON is valid YAML so is unchanged
+	if data, err = yaml.YAMLToJSON(data); err != nil {
+		return nil, errors.Wrapf(err, "decode policy file [%s] failed", filePath)
+	}
+
+	res := &policy{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(res); err != nil {
+		return nil, errors.Wrapf(err, "decode policy file [%s] failed", filePath)
+	}
+
+	for i := range res.Rules {
+		rule := &res.Rules[i]
+		if rule.Registry == "" {
+			return nil, errors.Errorf("policy file [%s] rule [%d] has no registry", filePath, i)
+		}
+		if _, err = path.Match(rule.Registry, ""); err != nil {
+			return nil, errors.Wrapf(err, "policy file [%s] rule [%d] registry [%s] is not a valid pattern", filePath, i, rule.Registry)
+		}
+		if rule.NamespaceSelector != nil {
+			if rule.selector, err = metav1.LabelSelectorAsSelector(rule.NamespaceSelector); err != nil {
+				return nil, errors.Wrapf(err, "policy file [%s] rule [%d] namespace selector is invalid", filePath, i)
+			}
+		}
+	}
+	for i := range res.CredentialSets {
+		rule := &res.CredentialSets[i]
+		if rule.CredentialSet == "" {
+			return nil, errors.Errorf("policy file [%s] credential set rule [%d] has no credential set", filePath, i)
+		}
+		if _, err = path.Match(rule.CredentialSet, ""); err != nil {
+			return nil, errors.Wrapf(err, "policy file [%s] credential set rule [%d] credential set [%s] is not a valid pattern", filePath, i, rule.CredentialSet)
+		}
+		if rule.NamespaceSelector != nil {
+			if rule.selector, err = metav1.LabelSelectorAsSelector(rule.NamespaceSelector); err != nil {
+				return nil, errors.Wrapf(err, "policy file [%s] credential set rule [%d] namespace selector is invalid", filePath, i)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// Is the namespace allowed to request the registry, a nil policy allows everything
+// Registry is the requested secret name, so can also be a replicated secret name
+func (p *policy) allows(registry string, ns corev1.Namespace) bool {
+	if p == nil {
+		return true
+	}
+
+	matchedRule := false
+	for _, rule := range p.Rules {
+		if matched, _ := path.Match(rule.Registry, registry); !matched {
+			continue
+		}
+		matchedRule = true
+		if rule.allows(ns) {
+			return true
+		}
+	}
+
+	return !matchedRule && !p.DefaultDeny
+}
+
+func (r *policyRule) allows(ns corev1.Namespace) bool {
+	return matchesNamespace(r.Namespaces, r.selector, ns)
+}
+
+// Is the namespace allowed to use the credential set, the default credentials (an empty credential set) and a nil policy are always allowed
+func (p *policy) allowsCredentialSet(credentialSet string, ns corev1.Namespace) bool {
+	if p == nil || credentialSet == "" {
+		return true
+	}
+
+	matchedRule := false
+	for _, rule := range p.CredentialSets {
+		if matched, _ := path.Match(rule.CredentialSet, credentialSet); !matched {
+			continue
+		}
+		matchedRule = true
+		if matchesNamespace(rule.Namespaces, rule.selector, ns) {
+			return true
+		}
+	}
+
+	return !matchedRule && !p.DefaultDeny
+}
+
+// Does the namespace name match one of the patterns or its labels match the selector, a nil selector matches nothing
+func matchesNamespace(patterns []string, selector labels.Selector, ns corev1.Namespace) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, ns.Name); matched {
+			return true
+		}
+	}
+
+	return selector != nil && selector.Matches(labels.Set(ns.Labels))
+}
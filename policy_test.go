@@ -0,0 +1,355 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testPolicy = `
+rules:
+- registry: ` + ecr1 + `
+  namespaces: ["prod-*", "ci-cd"]
+  namespaceSelector:
+    matchLabels:
+      environment: production
+- registry: "*.dkr.ecr.us-east-1.amazonaws.com"
+  namespaces: ["team-a"]
+credentialSets:
+- credentialSet: team-a
+  namespaces: ["team-a", "team-a-*"]
+  namespaceSelector:
+    matchLabels:
+      team: a
+- credentialSet: "shared-*"
+  namespaces: ["*"]
+`
+
+func writeTestPolicyFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "eatr-policy")
+	assert.Nil(t, err, "Create policy file error")
+	defer file.Close()
+	_, err = file.WriteString(content)
+	assert.Nil(t, err, "Write policy file error")
+
+	return file.Name()
+}
+
+func TestLoadPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		Name                       string // Test case name
+		Content                    string // Policy file content
+		ExpectedError              string // Expected error message fragment, empty if no error expected
+		ExpectedRules              int    // Expected number of rules
+		ExpectedCredentialSetRules int    // Expected number of credential set rules
+	}{
+		{
+			Name:                       "YAML policy",
+			Content:                    testPolicy,
+			ExpectedRules:              2,
+			ExpectedCredentialSetRules: 2,
+		},
+		{
+			Name:          "JSON policy",
+			Content:       `{ "defaultDeny": true, "rules": [ { "registry": "` + ecr1 + `", "namespaces": [ "ns-1" ] } ] }`,
+			ExpectedRules: 1,
+		},
+		{
+			Name:          "Rule with no registry",
+			Content:       "rules:\n- namespaces: [\"ns-1\"]\n",
+			ExpectedError: "rule [0] has no registry",
+		},
+		{
+			Name:          "Rule with an invalid namespace selector",
+			Content:       "rules:\n- registry: " + ecr1 + "\n  namespaceSelector:\n    matchExpressions:\n    - key: environment\n      operator: Bogus\n",
+			ExpectedError: "rule [0] namespace selector is invalid",
+		},
+		{
+			Name:          "Credential set rule with no credential set",
+			Content:       "credentialSets:\n- namespaces: [\"team-a\"]\n",
+			ExpectedError: "credential set rule [0] has no credential set",
+		},
+		{
+			Name:          "Credential set rule with an invalid pattern",
+			Content:       "credentialSets:\n- credentialSet: \"team-[\"\n  namespaces: [\"team-a\"]\n",
+			ExpectedError: "credential set rule [0] credential set [team-[] is not a valid pattern",
+		},
+		{
+			Name:          "Credential set rule with an invalid namespace selector",
+			Content:       "credentialSets:\n- credentialSet: team-a\n  namespaceSelector:\n    matchExpressions:\n    - key: team\n      operator: Bogus\n",
+			ExpectedError: "credential set rule [0] namespace selector is invalid",
+		},
+		{
+			Name:          "Unknown key",
+			Content:       "rules:\n- registry: " + ecr1 + "\n  namespace: [\"prod-*\"]\n",
+			ExpectedError: `unknown field "namespace"`,
+		},
+		{
+			Name:          "Unknown top level key",
+			Content:       "default-deny: true\nrules: []\n",
+			ExpectedError: `unknown field "default-deny"`,
+		},
+		{
+			Name:          "Unknown namespace selector key",
+			Content:       "rules:\n- registry: " + ecr1 + "\n  namespaceSelector:\n    matchLabel:\n      environment: production\n",
+			ExpectedError: `unknown field "matchLabel"`,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			filePath := writeTestPolicyFile(t, tc.Content)
+			defer os.Remove(filePath)
+
+			p, err := loadPolicy(filePath)
+			if tc.ExpectedError != "" {
+				assert.NotNil(t, err, "Load policy error")
+				assert.Contains(t, err.Error(), tc.ExpectedError, "Load policy error message")
+				return
+			}
+			assert.Nil(t, err, "Load policy error")
+			assert.Equal(t, tc.ExpectedRules, len(p.Rules), "Rules count")
+			assert.Equal(t, tc.ExpectedCredentialSetRules, len(p.CredentialSets), "Credential set rules count")
+		})
+	}
+}
+
+func TestPolicyAllows(t *testing.T) {
+	filePath := writeTestPolicyFile(t, testPolicy)
+	defer os.Remove(filePath)
+	p, err := loadPolicy(filePath)
+	assert.Nil(t, err, "Load policy error")
+
+	for _, tc := range []struct {
+		Name          string            // Test case name
+		DefaultDeny   bool              // Policy default deny
+		Registry      string            // Requested registry
+		NSName        string            // Requesting namespace name
+		NSLabels      map[string]string // Requesting namespace labels
+		ExpectedAllow bool              // Expect the request to be allowed
+	}{
+		{
+			Name:          "Namespace name matches pattern",
+			Registry:      ecr1,
+			NSName:        "prod-payments",
+			ExpectedAllow: true,
+		},
+		{
+			Name:          "Namespace labels match selector",
+			Registry:      ecr1,
+			NSName:        "payments",
+			NSLabels:      map[string]string{"environment": "production"},
+			ExpectedAllow: true,
+		},
+		{
+			Name:          "Namespace matches neither",
+			Registry:      ecr1,
+			NSName:        "team-a",
+			NSLabels:      map[string]string{"environment": "development"},
+			ExpectedAllow: false,
+		},
+		{
+			Name:          "Registry pattern rule",
+			Registry:      ecr2,
+			NSName:        "team-a",
+			ExpectedAllow: true,
+		},
+		{
+			Name:          "Registry with no rule",
+			Registry:      ecr3,
+			NSName:        "team-a",
+			ExpectedAllow: true,
+		},
+		{
+			Name:          "Registry with no rule and default deny",
+			DefaultDeny:   true,
+			Registry:      ecr3,
+			NSName:        "team-a",
+			ExpectedAllow: false,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			p.DefaultDeny = tc.DefaultDeny
+			ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: tc.NSName, Labels: tc.NSLabels}}
+			assert.Equal(t, tc.ExpectedAllow, p.allows(tc.Registry, ns), "Allowed")
+		})
+	}
+
+	var nilPolicy *policy
+	assert.True(t, nilPolicy.allows(ecr1, corev1.Namespace{}), "Nil policy allows")
+}
+
+func TestPolicyAllowsCredentialSet(t *testing.T) {
+	filePath := writeTestPolicyFile(t, testPolicy)
+	defer os.Remove(filePath)
+	p, err := loadPolicy(filePath)
+	assert.Nil(t, err, "Load policy error")
+
+	for _, tc := range []struct {
+		Name          string            // Test case name
+		DefaultDeny   bool              // Policy default deny
+		CredentialSet string            // Namespace credential set
+		NSName        string            // Requesting namespace name
+		NSLabels      map[string]string // Requesting namespace labels
+		ExpectedAllow bool              // Expect the credential set to be allowed
+	}{
+		{
+			Name:          "Namespace name matches pattern",
+			CredentialSet: "team-a",
+			NSName:        "team-a-ci",
+			ExpectedAllow: true,
+		},
+		{
+			Name:          "Namespace labels match selector",
+			CredentialSet: "team-a",
+			NSName:        "payments",
+			NSLabels:      map[string]string{"team": "a"},
+			ExpectedAllow: true,
+		},
+		{
+			Name:          "Namespace matches neither",
+			CredentialSet: "team-a",
+			NSName:        "team-b",
+			NSLabels:      map[string]string{"team": "b"},
+			ExpectedAllow: false,
+		},
+		{
+			Name:          "Credential set pattern rule",
+			CredentialSet: "shared-build",
+			NSName:        "team-b",
+			ExpectedAllow: true,
+		},
+		{
+			Name:          "Credential set with no rule",
+			CredentialSet: "team-c",
+			NSName:        "team-b",
+			ExpectedAllow: true,
+		},
+		{
+			Name:          "Credential set with no rule and default deny",
+			DefaultDeny:   true,
+			CredentialSet: "team-c",
+			NSName:        "team-b",
+			ExpectedAllow: false,
+		},
+		{
+			Name:          "Default credentials with default deny",
+			DefaultDeny:   true,
+			NSName:        "team-b",
+			ExpectedAllow: true,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			p.DefaultDeny = tc.DefaultDeny
+			ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: tc.NSName, Labels: tc.NSLabels}}
+			assert.Equal(t, tc.ExpectedAllow, p.allowsCredentialSet(tc.CredentialSet, ns), "Allowed")
+		})
+	}
+
+	var nilPolicy *policy
+	assert.True(t, nilPolicy.allowsCredentialSet("team-a", corev1.Namespace{}), "Nil policy allows")
+}
+
+func TestPolicyDenial(t *testing.T) {
+	filePath := writeTestPolicyFile(t, testPolicy)
+	defer os.Remove(filePath)
+
+	config := getDefaultConfig()
+	config.PolicyFilePath = filePath
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1, config.AWSCredentialsSecretPrefix + "-" + ecr3},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", ecr3: "true"},
+		},
+		{
+			Name:     "prod-1",
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true"},
+		},
+	})
+	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
+	prometheusRegistry := prometheus.NewRegistry()
+	ecrClient := NewFakeECRClient()
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+	assert.Nil(t, err, "New controller error")
+
+	err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+	assert.Nil(t, err, "Renewal error")
+
+	assert.False(t, k8sClient.SecretExists(ns1, ecr1), "Denied secret exists")
+	assert.True(t, k8sClient.SecretExists(ns1, ecr3), "Secret with no rule exists")
+	assert.True(t, k8sClient.SecretExists("prod-1", ecr1), "Allowed secret exists")
+	expectedEvents := corev1.EventTypeWarning + ":" + policyDeniedEventReason + "," + corev1.EventTypeNormal + ":" + secretCreatedEventReason
+	assert.Equal(t, expectedEvents, k8sClient.WaitForEventReasons(ns1, expectedEvents), "Namespace events")
+	expectedEvents = corev1.EventTypeNormal + ":" + secretCreatedEventReason
+	assert.Equal(t, expectedEvents, k8sClient.WaitForEventReasons("prod-1", expectedEvents), "Allowed namespace events")
+	denials := &dto.Metric{}
+	ctrl.PolicyDenialsCounter.WithLabelValues(ns1, ecr1).Write(denials)
+	assert.Equal(t, float64(1), denials.GetCounter().GetValue(), "Denials count")
+}
+
+func TestPolicyCredentialSetDenial(t *testing.T) {
+	filePath := writeTestPolicyFile(t, testPolicy)
+	defer os.Remove(filePath)
+
+	config := getDefaultConfig()
+	config.PolicyFilePath = filePath
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1, config.AWSCredentialsSecretPrefix + "-team-a-" + ecr1},
+		},
+		{
+			Name:     "team-a",
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", credentialSetLabelKey: "team-a", "environment": "production"},
+		},
+		{
+			Name:     "team-b",
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", credentialSetLabelKey: "team-a", "environment": "production"},
+		},
+	})
+	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
+	prometheusRegistry := prometheus.NewRegistry()
+	ecrClient := NewFakeECRClient()
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+	assert.Nil(t, err, "New controller error")
+
+	err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+	assert.Nil(t, err, "Renewal error")
+
+	assert.True(t, k8sClient.SecretExists("team-a", ecr1), "Allowed credential set secret exists")
+	assert.False(t, k8sClient.SecretExists("team-b", ecr1), "Denied credential set secret exists")
+	expectedEvents := corev1.EventTypeWarning + ":" + policyDeniedEventReason
+	assert.Equal(t, expectedEvents, k8sClient.WaitForEventReasons("team-b", expectedEvents), "Denied namespace events")
+	expectedEvents = corev1.EventTypeNormal + ":" + secretCreatedEventReason
+	assert.Equal(t, expectedEvents, k8sClient.WaitForEventReasons("team-a", expectedEvents), "Allowed namespace events")
+	denials := &dto.Metric{}
+	ctrl.PolicyDenialsCounter.WithLabelValues("team-b", ecr1).Write(denials)
+	assert.Equal(t, float64(1), denials.GetCounter().GetValue(), "Denials count")
+}
+
+func TestPolicyFileNotFound(t *testing.T) {
+	config := getDefaultConfig()
+	config.PolicyFilePath = "/does/not/exist.yaml"
+
+	_, err := newController(config, NewFakeK8SClient(nil), controllerInformers{Namespace: NewFakeSharedInformer(), HostSecret: NewFakeSharedInformer()}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.NotNil(t, err, "New controller error")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Rate limiting queue decorator which tracks the keys, the workqueue only surfaces its length
+// Scheduled keys are those added with a delay, they are only removed when got as the workqueue adds them itself once the delay has passed, rate limited adds are not tracked as we do not retry
+type trackingQueue struct {
+	workqueue.RateLimitingInterface
+	mutex      sync.Mutex
+	pending    sets.String
+	processing sets.String
+	scheduled  map[string]time.Time
+}
+
+// Queue keys snapshot
+type queueSnapshot struct {
+	Pending    []string       `json:"pending"`
+	Processing []string       `json:"processing"`
+	Scheduled  []scheduledKey `json:"scheduled"`
+}
+
+type scheduledKey struct {
+	Key     string    `json:"key"`
+	ReadyAt time.Time `json:"readyAt"`
+}
+
+func newTrackingQueue(queue workqueue.RateLimitingInterface) *trackingQueue {
+	return &trackingQueue{
+		RateLimitingInterface: queue,
+		pending:               sets.NewString(),
+		processing:            sets.NewString(),
+		scheduled:             map[string]time.Time{},
+	}
+}
+
+func (q *trackingQueue) Add(item interface{}) {
+	q.mutex.Lock()
+	q.pending.Insert(fmt.Sprint(item))
+	q.mutex.Unlock()
+
+	q.RateLimitingInterface.Add(item)
+}
+
+func (q *trackingQueue) AddAfter(item interface{}, duration time.Duration) {
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+
+	q.mutex.Lock()
+	q.scheduled[fmt.Sprint(item)] = time.Now().Add(duration)
+	q.mutex.Unlock()
+
+	q.RateLimitingInterface.AddAfter(item, duration)
+}
+
+func (q *trackingQueue) Get() (interface{}, bool) {
+	item, shutdown := q.RateLimitingInterface.Get()
+	if !shutdown {
+		key := fmt.Sprint(item)
+		q.mutex.Lock()
+		q.pending.Delete(key)
+		delete(q.scheduled, key)
+		q.processing.Insert(key)
+		q.mutex.Unlock()
+	}
+
+	return item, shutdown
+}
+
+func (q *trackingQueue) Done(item interface{}) {
+	q.mutex.Lock()
+	q.processing.Delete(fmt.Sprint(item))
+	q.mutex.Unlock()
+
+	q.RateLimitingInterface.Done(item)
+}
+
+// Get a snapshot of the queue keys, scheduled keys are sorted by ready at
+func (q *trackingQueue) snapshot() queueSnapshot {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	res := queueSnapshot{Pending: q.pending.List(), Processing: q.processing.List(), Scheduled: []scheduledKey{}}
+	for key, readyAt := range q.scheduled {
+		res.Scheduled = append(res.Scheduled, scheduledKey{Key: key, ReadyAt: readyAt})
+	}
+	sort.Slice(res.Scheduled, func(i, j int) bool { return res.Scheduled[i].ReadyAt.Before(res.Scheduled[j].ReadyAt) })
+
+	return res
+}
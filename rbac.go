@@ -0,0 +1,77 @@
+package main
+
+// A permission the controller needs, namespace is empty for cluster wide permissions
+// Used by the doctor command to check the permissions are granted and the manifests command to render the RBAC
+type requiredPermission struct {
+	Group         string
+	Resource      string
+	Verbs         []string
+	Namespace     string
+	Reason        string
+	ClusterScoped bool // Resource is not namespaced so can only be granted with a cluster role
+	Informer      bool // Listed and watched by a cluster wide informer so can only be granted with a cluster role
+}
+
+// Get the permissions the controller needs for the features enabled in the config
+// Secrets are written in any namespace so need cluster wide permissions, only the host namespace secrets are watched
+// If the controller is scoped to namespaces the namespaced permissions are needed in each of those namespaces instead
+func getRequiredPermissions(config config) []requiredPermission {
+	res := []requiredPermission{
+		{Resource: "namespaces", Verbs: []string{"get", "list", "watch"}, Reason: "examine the namespace labels", ClusterScoped: true},
+		{Resource: "secrets", Verbs: []string{"get", "list", "create", "update", "delete"}, Reason: "write the namespace image pull secrets and read the AWS credentials secrets"},
+		{Resource: "secrets", Verbs: []string{"watch"}, Namespace: config.HostNamespace, Reason: "react to replicated secret changes"},
+		{Resource: "events", Verbs: []string{"create", "patch", "update"}, Reason: "report secret writes and failures, repeated events are aggregated with a patch or update"},
+	}
+	if config.StatusConfigMapName != "" {
+		res = append(res, requiredPermission{Resource: "configmaps", Verbs: []string{"get", "create", "update"}, Namespace: config.HostNamespace, Reason: "publish the renewal status"})
+	}
+	if config.PatchServiceAccounts {
+		res = append(res,
+			requiredPermission{Resource: "serviceaccounts", Verbs: []string{"list", "watch"}, Reason: "watch service accounts", Informer: true},
+			requiredPermission{Resource: "serviceaccounts", Verbs: []string{"update"}, Reason: "patch service accounts"})
+	}
+	if config.DiscoveryMode || config.ReactToImagePullFailures {
+		res = append(res, requiredPermission{Resource: "pods", Verbs: []string{"list", "watch"}, Reason: "discovery mode or reacting to image pull failures", Informer: true})
+		if config.DeleteImagePullFailurePods {
+			res = append(res, requiredPermission{Resource: "pods", Verbs: []string{"delete"}, Reason: "delete image pull failure pods"})
+		}
+	}
+	if config.DiscoveryMode && config.DiscoveryIncludeWorkloads {
+		res = append(res,
+			requiredPermission{Group: "apps", Resource: "deployments", Verbs: []string{"list", "watch"}, Reason: "discovery mode workloads", Informer: true},
+			requiredPermission{Group: "apps", Resource: "statefulsets", Verbs: []string{"list", "watch"}, Reason: "discovery mode workloads", Informer: true},
+			requiredPermission{Group: "batch", Resource: "cronjobs", Verbs: []string{"list", "watch"}, Reason: "discovery mode workloads", Informer: true})
+	}
+	if config.ImagePullCredentials {
+		res = append(res, requiredPermission{Group: imagePullCredentialGroupVersion.Group, Resource: imagePullCredentialResource, Verbs: []string{"get", "list", "watch"}, Reason: "image pull credentials", ClusterScoped: true})
+	}
+	if config.DiagnosticAuth {
+		res = append(res,
+			requiredPermission{Group: "authentication.k8s.io", Resource: "tokenreviews", Verbs: []string{"create"}, Reason: "diagnostic auth", ClusterScoped: true},
+			requiredPermission{Group: "authorization.k8s.io", Resource: "subjectaccessreviews", Verbs: []string{"create"}, Reason: "diagnostic auth", ClusterScoped: true})
+	}
+
+	return scopeRequiredPermissions(res, getScopedNamespaces(config))
+}
+
+// Replace each cluster wide permission for a namespaced resource with a permission per namespace, unless the resource is watched by a cluster wide informer
+func scopeRequiredPermissions(permissions []requiredPermission, namespaces []string) []requiredPermission {
+	if len(namespaces) == 0 {
+		return permissions
+	}
+
+	res := []requiredPermission{}
+	for _, permission := range permissions {
+		if permission.Namespace != "" || permission.ClusterScoped || permission.Informer {
+			res = append(res, permission)
+			continue
+		}
+		for _, nsName := range namespaces {
+			scoped := permission
+			scoped.Namespace = nsName
+			res = append(res, scoped)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	configReloadKey = "**config-reload**" // Is not a valid namespace name so cannot clash with an existing namespace
+)
+
+var (
+	// Settings that can be changed without a restart, the others are used to create the informers, listeners and HTTP servers
+	configReloadableFlags = sets.NewString(
+		"auth-token-renewal-interval",
+		"aws-credentials-secret-prefix",
+		"delete-image-pull-failure-pods",
+		"discovery-namespaces",
+		"logging-verbosity-level",
+		"merged-secret-name",
+		"policy-file-path",
+		"renewal-staleness-window",
+		"service-account-names",
+		"status-config-map-name")
+	// Settings that only change how the renewals are scheduled or reported, so do not need the namespaces to be renewed
+	configNonRenewalFlags = sets.NewString("auth-token-renewal-interval", "logging-verbosity-level", "renewal-staleness-window")
+)
+
+// Get a func that loads the config from the same args and environment variables as the controller was started with, so only the settings and policy files can change
+func newConfigLoader(args []string) func() (config, error) {
+	return func() (config, error) {
+		return parseConfig(args, nil, os.LookupEnv)
+	}
+}
+
+// Poll the settings and policy files, a mounted config map is updated in place, enqueues a reload when either file's content changes
+// The reload is made by the queue consumer so it is never applied part way through a renewal
+func (c *controller) runConfigReloadLoop(interval time.Duration, stop <-chan struct{}) {
+	last := c.getConfigSources()
+	tick := time.Tick(interval)
+	for {
+		select {
+		case <-tick:
+			if current := c.getConfigSources(); current != last {
+				glog.Infoln("Config sources changed, adding config reload queue key")
+				c.Queue.Add(configReloadKey)
+				last = current
+			}
+		case <-stop:
+			glog.Infoln("Received stop signal, exiting config reload loop")
+			return
+		}
+	}
+}
+
+// Content of the settings and policy files, read errors are included so a file being removed or restored is also seen as a change
+func (c *controller) getConfigSources() string {
+	c.ConfigMutex.RLock()
+	filePaths := []string{c.Config.SettingsFilePath, c.Config.PolicyFilePath}
+	c.ConfigMutex.RUnlock()
+
+	res := ""
+	for _, filePath := range filePaths {
+		if filePath == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			res += fmt.Sprintf("%s:%s\n", filePath, err)
+			continue
+		}
+		res += fmt.Sprintf("%s:%d:%s\n", filePath, len(data), data)
+	}
+
+	return res
+}
+
+// Copy of the config and policy for go routines other than the queue consumer, so the read lock is not held across API calls which would block a reload
+// A reload replaces the policy rather than changing it, so the copied policy can still be used after the lock is released
+func (c *controller) getConfigSnapshot() (config, *policy) {
+	c.ConfigMutex.RLock()
+	defer c.ConfigMutex.RUnlock()
+
+	return c.Config, c.Policy
+}
+
+// Reload the config, an invalid config or one that changes settings needing a restart is rejected and the last good config is kept
+// Resets the renewal schedule if the interval changed and renews all namespaces if a setting that affects the secrets changed
+func (c *controller) reloadConfig() error {
+	if c.LoadConfig == nil {
+		return errors.New("config reload is not supported")
+	}
+
+	newConfig, err := c.LoadConfig()
+	if err == nil {
+		err = utilerrors.NewAggregate(validateConfig(newConfig))
+	}
+	if err != nil {
+		c.ConfigReloadsCounter.WithLabelValues("failed").Inc()
+		return errors.Wrap(err, "config reload rejected, keeping the last good config")
+	}
+
+	changes, restartRequired := getConfigChanges(c.Config, newConfig)
+	if len(restartRequired) > 0 {
+		c.ConfigReloadsCounter.WithLabelValues("failed").Inc()
+		return errors.Errorf("config reload rejected, keeping the last good config, changing [%s] needs a restart", strings.Join(restartRequired, ","))
+	}
+	newPolicy, err := loadPolicy(newConfig.PolicyFilePath)
+	if err != nil {
+		c.ConfigReloadsCounter.WithLabelValues("failed").Inc()
+		return errors.Wrap(err, "config reload rejected, keeping the last good config")
+	}
+	policyChanged := getPolicyFingerprint(c.Policy) != getPolicyFingerprint(newPolicy)
+	if len(changes) == 0 && !policyChanged {
+		glog.Infoln("Config reload found no changes")
+		return nil
+	}
+
+	previous := c.Config
+	c.ConfigMutex.Lock()
+	c.Config, c.Policy = newConfig, newPolicy
+	c.ConfigGeneration++
+	c.ConfigMutex.Unlock()
+	c.ConfigGenerationGauge.Set(float64(c.ConfigGeneration))
+	c.ConfigReloadsCounter.WithLabelValues("success").Inc()
+
+	glog.Infof("Config reloaded, generation %d\n", c.ConfigGeneration)
+	renew := policyChanged
+	for _, change := range changes {
+		glog.Infof("Config changed %s\n", change.String())
+		if !configNonRenewalFlags.Has(change.Name) {
+			renew = true
+		}
+	}
+	if policyChanged {
+		glog.Infof("Config changed policy [%s] reloaded\n", newConfig.PolicyFilePath)
+	}
+
+	if newConfig.LoggingVerbosityLevel != previous.LoggingVerbosityLevel {
+		flag.Set("v", strconv.Itoa(newConfig.LoggingVerbosityLevel))
+	}
+	if newConfig.AuthenticationTokenRenewalInterval != previous.AuthenticationTokenRenewalInterval {
+		// Buffered, so a pending reset is not lost and the consumer never blocks on the run loop
+		select {
+		case c.RenewalScheduleReset <- struct{}{}:
+		default:
+		}
+	}
+	if renew {
+		glog.Infoln("Adding queue key to renew for all namespaces after config reload")
+		c.Queue.Add(allNamespacesKey)
+	}
+
+	return nil
+}
+
+// A changed setting, named by its flag
+type configChange struct {
+	Name     string
+	Previous string
+	Current  string
+}
+
+func (c configChange) String() string {
+	return fmt.Sprintf("[%s] [%s] -> [%s]", c.Name, c.Previous, c.Current)
+}
+
+// Get the changed settings in flag name order, and the names of any changed settings that need a restart
+func getConfigChanges(previous, current config) ([]configChange, []string) {
+	previousFS := flag.NewFlagSet("previous", flag.ContinueOnError)
+	addConfigFlags(previousFS, &previous)
+	currentFS := flag.NewFlagSet("current", flag.ContinueOnError)
+	addConfigFlags(currentFS, &current)
+
+	changes := []configChange{}
+	restartRequired := []string{}
+	currentFS.VisitAll(func(f *flag.Flag) {
+		previousValue := previousFS.Lookup(f.Name).Value.String()
+		if f.Value.String() == previousValue {
+			return
+		}
+		changes = append(changes, configChange{Name: f.Name, Previous: previousValue, Current: f.Value.String()})
+		if !configReloadableFlags.Has(f.Name) {
+			restartRequired = append(restartRequired, f.Name)
+		}
+	})
+
+	return changes, restartRequired
+}
+
+// Policy as JSON so a reloaded policy can be compared with the current one, a nil policy is null
+func getPolicyFingerprint(p *policy) string {
+	data, _ := json.Marshal(p)
+
+	return string(data)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadConfig(t *testing.T) {
+	policyFilePath := writeTestPolicyFile(t, testPolicy)
+
+	for _, tc := range []struct {
+		Name                  string               // Test case name
+		Mutate                func(config *config) // Change to make to the current config to get the reloaded config
+		LoadError             error                // Error loading the reloaded config
+		ExpectedError         string               // Expected error message fragment, empty if no error expected
+		ExpectedGeneration    int                  // Expected config generation after the reload
+		ExpectedRenewal       bool                 // Expect all namespaces to be enqueued for renewal
+		ExpectedScheduleReset bool                 // Expect the renewal schedule to be reset
+	}{
+		{
+			Name:               "No changes",
+			Mutate:             func(config *config) {},
+			ExpectedGeneration: 1,
+		},
+		{
+			Name:                  "Renewal interval changed",
+			Mutate:                func(config *config) { config.AuthenticationTokenRenewalInterval = 4 * time.Hour },
+			ExpectedGeneration:    2,
+			ExpectedScheduleReset: true,
+		},
+		{
+			Name:               "Merged secret name changed",
+			Mutate:             func(config *config) { config.MergedSecretName = "ecr" },
+			ExpectedGeneration: 2,
+			ExpectedRenewal:    true,
+		},
+		{
+			Name:               "Policy added",
+			Mutate:             func(config *config) { config.PolicyFilePath = policyFilePath },
+			ExpectedGeneration: 2,
+			ExpectedRenewal:    true,
+		},
+		{
+			Name:               "Invalid config",
+			Mutate:             func(config *config) { config.AuthenticationTokenRenewalInterval = 13 * time.Hour },
+			ExpectedError:      "auth-token-renewal-interval [13h0m0s] must be greater than 0 and less than 12h0m0s",
+			ExpectedGeneration: 1,
+		},
+		{
+			Name:               "Restart required",
+			Mutate:             func(config *config) { config.Port = 6000; config.MergedSecretName = "ecr" },
+			ExpectedError:      "changing [port] needs a restart",
+			ExpectedGeneration: 1,
+		},
+		{
+			Name:               "Load failure",
+			Mutate:             func(config *config) {},
+			LoadError:          errors.New("settings file is invalid"),
+			ExpectedError:      "settings file is invalid",
+			ExpectedGeneration: 1,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			current := getDefaultConfig()
+			k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{{Name: current.HostNamespace, IsActive: true}})
+			ctrl, err := newController(current, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+			assert.Nil(t, err, "New controller error")
+
+			reloaded := current
+			tc.Mutate(&reloaded)
+			ctrl.LoadConfig = func() (config, error) { return reloaded, tc.LoadError }
+
+			err = ctrl.reloadConfig()
+			if tc.ExpectedError != "" {
+				assert.NotNil(t, err, "Reload error")
+				assert.Contains(t, err.Error(), tc.ExpectedError, "Reload error message")
+				assert.Equal(t, current, ctrl.Config, "Last good config kept")
+			} else {
+				assert.Nil(t, err, "Reload error")
+				assert.Equal(t, reloaded, ctrl.Config, "Reloaded config")
+			}
+			assert.Equal(t, tc.ExpectedGeneration, ctrl.ConfigGeneration, "Config generation")
+
+			metric := &dto.Metric{}
+			ctrl.ConfigGenerationGauge.Write(metric)
+			assert.Equal(t, float64(tc.ExpectedGeneration), metric.GetGauge().GetValue(), "Config generation metric")
+
+			assert.Equal(t, tc.ExpectedRenewal, ctrl.Queue.Len() == 1, "All namespaces renewal enqueued")
+			assert.Equal(t, tc.ExpectedScheduleReset, len(ctrl.RenewalScheduleReset) == 1, "Renewal schedule reset")
+		})
+	}
+}
+
+func TestConfigChanges(t *testing.T) {
+	previous := getDefaultConfig()
+	current := previous
+	current.MergedSecretName = "ecr"
+	current.HostNamespace = "eatr"
+
+	changes, restartRequired := getConfigChanges(previous, current)
+	assert.Equal(t, 2, len(changes), "Changes")
+	assert.Equal(t, "[host-namespace] [ci-cd] -> [eatr]", changes[0].String(), "Host namespace change")
+	assert.Equal(t, "[merged-secret-name] [] -> [ecr]", changes[1].String(), "Merged secret name change")
+	assert.Equal(t, []string{"host-namespace"}, restartRequired, "Restart required")
+}
+
+func TestConfigSources(t *testing.T) {
+	config := getDefaultConfig()
+	config.SettingsFilePath = writeTestSettingsFile(t, "merged-secret-name: ecr\n")
+	defer os.Remove(config.SettingsFilePath)
+	ctrl, err := newController(config, NewFakeK8SClient(nil), controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+
+	sources := ctrl.getConfigSources()
+	assert.Equal(t, sources, ctrl.getConfigSources(), "Unchanged sources")
+
+	assert.Nil(t, ioutil.WriteFile(config.SettingsFilePath, []byte("merged-secret-name: ecr-merged\n"), 0600), "Write settings file error")
+	assert.NotEqual(t, sources, ctrl.getConfigSources(), "Changed sources")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// Renew once, a single all namespaces renewal with a summary written to w, for running as a cron job rather than a long lived deployment
+func (c *controller) renewOnce(w io.Writer) error {
+	return c.renewKeys(w, []string{allNamespacesKey})
+}
+
+// Renew the queue keys with a summary written to w, used by renew once and the renew command
+// Returns an error if a renewal failed or any registry or namespace secret failed, so the job or command is marked as failed
+func (c *controller) renewKeys(w io.Writer, keys []string) error {
+	errs := []error{}
+	for _, key := range keys {
+		if err := c.renewECRImagePullSecrets(key); err != nil {
+			errs = append(errs, errors.Wrapf(err, "renewal failed for [%s]", key))
+		}
+	}
+
+	status, secrets := c.Status.snapshot(), c.Status.secretsSnapshot()
+	failedRegistries, failedSecrets := 0, 0
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REGISTRY\tNAMESPACES\tTOKEN EXPIRY\tERROR")
+	for _, r := range status.Registries {
+		if r.LastFetchError != "" || len(r.FailedNamespaces) > 0 {
+			failedRegistries++
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", r.Registry, r.NamespacesServed, formatSummaryTime(r.TokenExpiry), r.LastFetchError)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "NAMESPACE\tSECRET\tEXPIRY\tERROR")
+	for _, s := range secrets {
+		if s.LastError != "" {
+			failedSecrets++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Namespace, s.Name, formatSummaryTime(s.Expiry), s.LastError)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d registries, %d failed, %d secrets, %d failed\n", len(status.Registries), failedRegistries, len(secrets), failedSecrets)
+	if c.DryRun != nil {
+		fmt.Fprintln(w)
+		writePlan(w, c.DryRun.plan())
+	}
+
+	if failedRegistries > 0 || failedSecrets > 0 {
+		errs = append(errs, errors.Errorf("%d registries and %d secrets failed", failedRegistries, failedSecrets))
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+func formatSummaryTime(t *metav1.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
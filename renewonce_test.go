@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenewOnce(t *testing.T) {
+	for _, tc := range []struct {
+		Name                 string   // Test case name
+		FailECR2             bool     // Fail ECR authorization token requests for ecr2
+		ExpectedErr          bool     // Expect an error so a non zero exit
+		ExpectedSecrets      []string // Expected ns1 secrets
+		ExpectedSummaryTotal string   // Expected summary totals line
+	}{
+		{
+			Name:                 "All renewed",
+			ExpectedSecrets:      []string{ecr1, ecr2},
+			ExpectedSummaryTotal: "2 registries, 0 failed, 3 secrets, 0 failed",
+		},
+		{
+			Name:                 "Registry failure",
+			FailECR2:             true,
+			ExpectedErr:          true,
+			ExpectedSecrets:      []string{ecr1},
+			ExpectedSummaryTotal: "2 registries, 1 failed, 3 secrets, 1 failed",
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			config := getDefaultConfig()
+			config.RenewOnce = true
+			k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+				{
+					Name:     config.HostNamespace,
+					IsActive: true,
+					Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1, config.AWSCredentialsSecretPrefix + "-" + ecr2},
+				},
+				{
+					Name:     ns1,
+					IsActive: true,
+					Labels:   map[string]string{ecr1: "true", ecr2: "true"},
+				},
+				{
+					Name:     ns2,
+					IsActive: true,
+					Labels:   map[string]string{ecr1: "true"},
+				},
+			})
+			// Region is how the fake ECR client decides to fail
+			k8sClient.UpdateSecret(config.HostNamespace, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: config.AWSCredentialsSecretPrefix + "-" + ecr2},
+				Data:       map[string][]byte{"aws_region": []byte("us-east-1")},
+			})
+			ecrClient := NewFakeECRClient()
+			getAuthTokenFn := ecrClient.GetAuthTokenFn
+			ecrClient.GetAuthTokenFn = func(ctx context.Context, region, id, secret string) (*ecr.AuthorizationData, error) {
+				if tc.FailECR2 && region == "us-east-1" {
+					return nil, errors.New("ECR is unavailable")
+				}
+				return getAuthTokenFn(ctx, region, id, secret)
+			}
+
+			ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), ecrClient)
+			assert.Nil(t, err, "New controller error")
+			assert.Empty(t, ctrl.InformersSynced, "Informers synced")
+
+			summary := &bytes.Buffer{}
+			err = ctrl.renewOnce(summary)
+			assert.Equal(t, tc.ExpectedErr, err != nil, "Renew once error")
+			for _, secretName := range tc.ExpectedSecrets {
+				assert.True(t, k8sClient.SecretExists(ns1, secretName), "Secret exists")
+			}
+			assert.Contains(t, summary.String(), tc.ExpectedSummaryTotal, "Summary totals")
+			assert.Contains(t, summary.String(), ns2, "Summary namespace")
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	serviceAccountImagePullSecretsAnnotationKey = "eatr.io/image-pull-secrets" // Service account annotation we use to track the imagePullSecrets we added, so we only ever remove our own
+	serviceAccountsAnnotationKey                = "eatr.io/service-accounts"   // Namespace annotation that overrides the configured service account names to patch
+)
+
+// Patch the namespace service accounts imagePullSecrets with the managed secret names
+// Target service accounts get all the wanted secret names, any other service account we previously patched has our secret names removed
+func (c *controller) patchNamespaceServiceAccounts(ns corev1.Namespace, wantedSecretNames sets.String) error {
+	targetNames := sets.NewString(splitNames(c.Config.ServiceAccountNames)...)
+	if value, ok := ns.Annotations[serviceAccountsAnnotationKey]; ok {
+		targetNames = sets.NewString(splitNames(value)...)
+	}
+
+	glog.V(detailiedGLogLevel).Infof("Getting namespace [%s] service accounts\n", ns.Name)
+	list, err := c.K8S.GetServiceAccounts(ns.Name)
+	if err != nil {
+		return errors.Wrapf(err, "get namespace [%s] service accounts failed", ns.Name)
+	}
+
+	for i := range list.Items {
+		sa := &list.Items[i]
+		_, previouslyPatched := sa.Annotations[serviceAccountImagePullSecretsAnnotationKey]
+		if !targetNames.Has(sa.Name) && !previouslyPatched {
+			continue
+		}
+
+		desired := sets.NewString()
+		if targetNames.Has(sa.Name) {
+			desired = wantedSecretNames
+		}
+		if !setServiceAccountImagePullSecrets(sa, desired) {
+			continue
+		}
+
+		glog.V(detailiedGLogLevel).Infof("Updating namespace [%s] service account [%s]\n", ns.Name, sa.Name)
+		if _, err = c.K8S.UpdateServiceAccount(ns.Name, sa); err != nil {
+			return errors.Wrapf(err, "update of namespace [%s] service account [%s] failed", ns.Name, sa.Name)
+		}
+		c.ServiceAccountsPatchedCounter.WithLabelValues(ns.Name, sa.Name).Inc()
+		glog.Infof("Patched namespace [%s] service account [%s] imagePullSecrets\n", ns.Name, sa.Name)
+	}
+
+	return nil
+}
+
+// Set the service account imagePullSecrets we manage to the desired names, leaving any others alone, returns true if the service account was altered
+func setServiceAccountImagePullSecrets(sa *corev1.ServiceAccount, desired sets.String) bool {
+	previous := sets.NewString(splitNames(sa.Annotations[serviceAccountImagePullSecretsAnnotationKey])...)
+	remove := previous.Difference(desired)
+
+	existing := sets.NewString()
+	refs := []corev1.LocalObjectReference{}
+	for _, ref := range sa.ImagePullSecrets {
+		if remove.Has(ref.Name) {
+			continue
+		}
+		existing.Insert(ref.Name)
+		refs = append(refs, ref)
+	}
+	for _, name := range desired.Difference(existing).List() {
+		refs = append(refs, corev1.LocalObjectReference{Name: name})
+	}
+
+	changed := len(refs) != len(sa.ImagePullSecrets) || !previous.Equal(desired)
+	if !changed {
+		return false
+	}
+
+	sa.ImagePullSecrets = refs
+	if sa.Annotations == nil {
+		sa.Annotations = map[string]string{}
+	}
+	if desired.Len() == 0 {
+		delete(sa.Annotations, serviceAccountImagePullSecretsAnnotationKey)
+	} else {
+		sa.Annotations[serviceAccountImagePullSecretsAnnotationKey] = strings.Join(desired.List(), ",")
+	}
+
+	return true
+}
+
+// Split a comma separated list of names, ignoring empty entries and surrounding whitespace
+func splitNames(value string) []string {
+	names := []string{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	statusConfigMapDataKey = "status.json"
+)
+
+// Renewal status per registry, kept in memory and published to the status config map after each renewal
+type renewalStatus struct {
+	mutex      sync.Mutex
+	registries map[string]*registryRenewalStatus
+	secrets    map[string]*secretStatus // Namespace and secret name key, see getSecretKey
+	published  string                   // Config map name and registries last published, so an unchanged status is not republished
+}
+
+type registryRenewalStatus struct {
+	lastSuccessfulFetch time.Time
+	tokenExpiry         time.Time
+	lastFetchError      string
+	namespaces          map[string]string // Namespace name to last error, empty if the namespace's secret was written
+}
+
+// Published registry status
+type registryStatus struct {
+	Registry            string            `json:"registry"`
+	LastSuccessfulFetch *metav1.Time      `json:"lastSuccessfulFetch,omitempty"`
+	TokenExpiry         *metav1.Time      `json:"tokenExpiry,omitempty"`
+	LastFetchError      string            `json:"lastFetchError,omitempty"`
+	NamespacesServed    int               `json:"namespacesServed"`
+	FailedNamespaces    []namespaceStatus `json:"failedNamespaces,omitempty"`
+}
+
+type namespaceStatus struct {
+	Namespace string `json:"namespace"`
+	Error     string `json:"error"`
+}
+
+// Managed namespace secret status, registries is empty for replicated secrets and has all the registries for a merged secret
+// Issued at and expiry are for the last successful write, last error is for the last write
+type secretStatus struct {
+	Namespace  string       `json:"namespace"`
+	Name       string       `json:"name"`
+	Registries []string     `json:"registries,omitempty"`
+	IssuedAt   *metav1.Time `json:"issuedAt,omitempty"`
+	Expiry     *metav1.Time `json:"expiry,omitempty"`
+	LastError  string       `json:"lastError,omitempty"`
+}
+
+// Published status
+type controllerStatus struct {
+	UpdatedAt  metav1.Time      `json:"updatedAt"`
+	Registries []registryStatus `json:"registries"`
+}
+
+func newRenewalStatus() *renewalStatus {
+	return &renewalStatus{registries: map[string]*registryRenewalStatus{}, secrets: map[string]*secretStatus{}}
+}
+
+func getSecretKey(nsName, secretName string) string {
+	return nsName + "/" + secretName
+}
+
+func (s *renewalStatus) getRegistry(registry string) *registryRenewalStatus {
+	if _, ok := s.registries[registry]; !ok {
+		s.registries[registry] = &registryRenewalStatus{namespaces: map[string]string{}}
+	}
+
+	return s.registries[registry]
+}
+
+// Record a successful token fetch for a registry
+func (s *renewalStatus) recordFetch(registry string, fetchedAt, expiresAt time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.getRegistry(registry)
+	status.lastSuccessfulFetch = fetchedAt
+	status.tokenExpiry = expiresAt
+	status.lastFetchError = ""
+}
+
+// Record a failed token fetch for a registry
+func (s *renewalStatus) recordFetchFailure(registry string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.getRegistry(registry).lastFetchError = err.Error()
+}
+
+// Record the result of writing a namespace secret for a registry, a nil error means the namespace is served
+func (s *renewalStatus) recordNamespace(registry, nsName string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	s.getRegistry(registry).namespaces[nsName] = msg
+}
+
+// Record the result of writing a namespace secret, a zero expires at means the secret has no expiry i.e. a replicated secret
+func (s *renewalStatus) recordSecret(nsName, secretName string, registries []string, expiresAt time.Time, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := getSecretKey(nsName, secretName)
+	if _, ok := s.secrets[key]; !ok {
+		s.secrets[key] = &secretStatus{Namespace: nsName, Name: secretName}
+	}
+	status := s.secrets[key]
+	status.Registries = registries
+	if err != nil {
+		status.LastError = err.Error()
+		return
+	}
+	status.IssuedAt = &metav1.Time{Time: time.Now()}
+	status.Expiry = nil
+	if !expiresAt.IsZero() {
+		status.Expiry = &metav1.Time{Time: expiresAt}
+	}
+	status.LastError = ""
+}
+
+// Forget a namespace secret, used when the secret is deleted
+func (s *renewalStatus) forgetSecret(nsName, secretName string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.secrets, getSecretKey(nsName, secretName))
+}
+
+// Forget a namespace for all registries except those it still requests
+func (s *renewalStatus) retainNamespaceRegistries(nsName string, registries sets.String) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for registry, status := range s.registries {
+		if !registries.Has(registry) {
+			delete(status.namespaces, nsName)
+		}
+	}
+}
+
+// Forget namespaces that are no longer requesting any secrets, used after an all namespaces renewal
+func (s *renewalStatus) retainNamespaces(nsNames sets.String) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, status := range s.registries {
+		for nsName := range status.namespaces {
+			if !nsNames.Has(nsName) {
+				delete(status.namespaces, nsName)
+			}
+		}
+	}
+	for key, status := range s.secrets {
+		if !nsNames.Has(status.Namespace) {
+			delete(s.secrets, key)
+		}
+	}
+}
+
+// Get the registries that renewed before but have been failing since, sorted by name, registries that have never renewed are not included
+func (s *renewalStatus) getStuckRegistries(since time.Time) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	res := []string{}
+	for registry, status := range s.registries {
+		if status.lastFetchError != "" && !status.lastSuccessfulFetch.IsZero() && status.lastSuccessfulFetch.Before(since) {
+			res = append(res, registry)
+		}
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// Get a snapshot of the status, registries are sorted by name as are failed namespaces
+func (s *renewalStatus) snapshot() controllerStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	res := controllerStatus{UpdatedAt: metav1.Now(), Registries: []registryStatus{}}
+	for registry, status := range s.registries {
+		rs := registryStatus{Registry: registry, LastFetchError: status.lastFetchError}
+		if !status.lastSuccessfulFetch.IsZero() {
+			rs.LastSuccessfulFetch = &metav1.Time{Time: status.lastSuccessfulFetch}
+			rs.TokenExpiry = &metav1.Time{Time: status.tokenExpiry}
+		}
+		for nsName, msg := range status.namespaces {
+			if msg == "" {
+				rs.NamespacesServed++
+				continue
+			}
+			rs.FailedNamespaces = append(rs.FailedNamespaces, namespaceStatus{Namespace: nsName, Error: msg})
+		}
+		sort.Slice(rs.FailedNamespaces, func(i, j int) bool { return rs.FailedNamespaces[i].Namespace < rs.FailedNamespaces[j].Namespace })
+		res.Registries = append(res.Registries, rs)
+	}
+	sort.Slice(res.Registries, func(i, j int) bool { return res.Registries[i].Registry < res.Registries[j].Registry })
+
+	return res
+}
+
+// Get a snapshot of the managed namespace secrets status, sorted by namespace and name
+func (s *renewalStatus) secretsSnapshot() []secretStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	res := []secretStatus{}
+	for _, status := range s.secrets {
+		res = append(res, *status)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return getSecretKey(res[i].Namespace, res[i].Name) < getSecretKey(res[j].Namespace, res[j].Name)
+	})
+
+	return res
+}
+
+// Has the status been published, published is the config map name and registries, updated at is excluded as it always changes
+func (s *renewalStatus) isPublished(published string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.published == published
+}
+
+// Record the status that was published, so it is not republished until it changes
+func (s *renewalStatus) recordPublished(published string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.published = published
+}
+
+// Publish the status to the host namespace status config map, nothing is published if no status config map name is configured
+// The config map is only written if the registries status has changed since it was last published, updated at is when it last changed
+func (c *controller) publishStatus() error {
+	if c.Config.StatusConfigMapName == "" {
+		return nil
+	}
+
+	status := c.Status.snapshot()
+	registries, err := json.Marshal(status.Registries)
+	if err != nil {
+		return errors.Wrap(err, "marshal status failed")
+	}
+	published := c.Config.StatusConfigMapName + "/" + string(registries)
+	if c.Status.isPublished(published) {
+		glog.V(detailiedGLogLevel).Infof("Namespace [%s] status config map [%s] is unchanged, will not update\n", c.Config.HostNamespace, c.Config.StatusConfigMapName)
+		return nil
+	}
+
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal status failed")
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{managedByLabelKey: managedByLabelValue},
+			Name:   c.Config.StatusConfigMapName,
+		},
+		Data: map[string]string{statusConfigMapDataKey: string(data)},
+	}
+
+	_, err = c.K8S.GetConfigMap(c.Config.HostNamespace, cm.Name)
+	if err != nil {
+		if !k8serr.IsNotFound(err) {
+			return errors.Wrapf(err, "get namespace [%s] config map [%s] failed", c.Config.HostNamespace, cm.Name)
+		}
+		glog.V(detailiedGLogLevel).Infof("Creating namespace [%s] status config map [%s]\n", c.Config.HostNamespace, cm.Name)
+		_, err = c.K8S.CreateConfigMap(c.Config.HostNamespace, cm)
+	} else {
+		glog.V(detailiedGLogLevel).Infof("Updating namespace [%s] status config map [%s]\n", c.Config.HostNamespace, cm.Name)
+		_, err = c.K8S.UpdateConfigMap(c.Config.HostNamespace, cm)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "create or update of namespace [%s] config map [%s] failed", c.Config.HostNamespace, cm.Name)
+	}
+	c.Status.recordPublished(published)
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestStatus(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1, config.AWSCredentialsSecretPrefix + "-" + ecr2},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", ecr2: "true", ecr3: "true"},
+		},
+		{
+			Name:     ns2,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true"},
+		},
+	})
+	// Region is how the fake ECR client decides to fail
+	k8sClient.UpdateSecret(config.HostNamespace, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: config.AWSCredentialsSecretPrefix + "-" + ecr2},
+		Data:       map[string][]byte{"aws_region": []byte("us-east-1")},
+	})
+	nsInformer := NewFakeSharedInformer()
+	secretInformer := NewFakeSharedInformer()
+	prometheusRegistry := prometheus.NewRegistry()
+	ecrClient := NewFakeECRClient()
+	getAuthTokenFn := ecrClient.GetAuthTokenFn
+	ecrClient.GetAuthTokenFn = func(ctx context.Context, region, id, secret string) (*ecr.AuthorizationData, error) {
+		if region == "us-east-1" {
+			return nil, errors.New("ECR is unavailable")
+		}
+		return getAuthTokenFn(ctx, region, id, secret)
+	}
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+	assert.Nil(t, err, "New controller error")
+
+	err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+	assert.NotNil(t, err, "Renewal error")
+	// ECR failure for one registry does not stop the other registries being renewed
+	assert.True(t, k8sClient.SecretExists(ns1, ecr1), "Secret exists")
+	assert.True(t, k8sClient.SecretExists(ns2, ecr1), "Secret exists")
+	assert.False(t, k8sClient.SecretExists(ns1, ecr2), "Failed secret exists")
+
+	status := controllerStatus{}
+	err = json.Unmarshal([]byte(k8sClient.ConfigMapData(config.HostNamespace, config.StatusConfigMapName, statusConfigMapDataKey)), &status)
+	assert.Nil(t, err, "Status unmarshal error")
+	if assert.Equal(t, 3, len(status.Registries), "Registries count") {
+		for _, tc := range []struct {
+			Status                   registryStatus // Registry status
+			ExpectedRegistry         string         // Expected registry
+			ExpectedFetched          bool           // Expected a successful fetch
+			ExpectedFetchError       bool           // Expected a fetch error
+			ExpectedNamespacesServed int            // Expected namespaces served
+			ExpectedFailedNamespaces int            // Expected failed namespaces count
+		}{
+			{
+				Status:                   status.Registries[0],
+				ExpectedRegistry:         ecr1,
+				ExpectedFetched:          true,
+				ExpectedNamespacesServed: 2,
+			},
+			{
+				Status:                   status.Registries[1],
+				ExpectedRegistry:         ecr2,
+				ExpectedFetchError:       true,
+				ExpectedFailedNamespaces: 1,
+			},
+			{
+				Status:                   status.Registries[2],
+				ExpectedRegistry:         ecr3,
+				ExpectedFetchError:       true,
+				ExpectedFailedNamespaces: 1,
+			},
+		} {
+			t.Run(tc.ExpectedRegistry, func(t *testing.T) {
+				assert.Equal(t, tc.ExpectedRegistry, tc.Status.Registry, "Registry")
+				assert.Equal(t, tc.ExpectedFetched, tc.Status.LastSuccessfulFetch != nil, "Last successful fetch")
+				assert.Equal(t, tc.ExpectedFetched, tc.Status.TokenExpiry != nil, "Token expiry")
+				assert.Equal(t, tc.ExpectedFetchError, tc.Status.LastFetchError != "", "Last fetch error")
+				assert.Equal(t, tc.ExpectedNamespacesServed, tc.Status.NamespacesServed, "Namespaces served")
+				if assert.Equal(t, tc.ExpectedFailedNamespaces, len(tc.Status.FailedNamespaces), "Failed namespaces count") && tc.ExpectedFailedNamespaces > 0 {
+					assert.Equal(t, ns1, tc.Status.FailedNamespaces[0].Namespace, "Failed namespace")
+				}
+			})
+		}
+	}
+
+	// Namespaces no longer requesting a registry are forgotten
+	ctrl.Status.retainNamespaceRegistries(ns1, sets.NewString(ecr1))
+	ctrl.Status.retainNamespaces(sets.NewString(ns1))
+	status = ctrl.Status.snapshot()
+	if assert.Equal(t, 3, len(status.Registries), "Registries count") {
+		assert.Equal(t, 1, status.Registries[0].NamespacesServed, "Namespaces served")
+		assert.Equal(t, 0, len(status.Registries[1].FailedNamespaces), "Failed namespaces count")
+		assert.Equal(t, 0, len(status.Registries[2].FailedNamespaces), "Failed namespaces count")
+	}
+}
+
+func TestStatusPublishedOnlyWhenChanged(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{{Name: config.HostNamespace, IsActive: true}})
+	updates := 0
+	updateConfigMapFn := k8sClient.UpdateConfigMapFn
+	k8sClient.UpdateConfigMapFn = func(ns string, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+		updates++
+		return updateConfigMapFn(ns, cm)
+	}
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+
+	ctrl.Status.recordNamespace(ecr1, ns1, nil)
+	assert.Nil(t, ctrl.publishStatus(), "Publish status error")
+	assert.NotEmpty(t, k8sClient.ConfigMapData(config.HostNamespace, config.StatusConfigMapName, statusConfigMapDataKey), "Status config map data")
+
+	// Only the updated at timestamp would change, so the config map is not updated
+	assert.Nil(t, ctrl.publishStatus(), "Publish status error")
+	assert.Equal(t, 0, updates, "Config map updates for an unchanged status")
+
+	ctrl.Status.recordNamespace(ecr1, ns2, errors.New("denied by policy"))
+	assert.Nil(t, ctrl.publishStatus(), "Publish status error")
+	assert.Equal(t, 1, updates, "Config map updates for a changed status")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+)
+
+// Subset so we can test, we only need to check AWS credentials belong to the expected account
+type stsInterface interface {
+	GetCallerIdentity(ctx context.Context, region, id, secret string) (string, error)
+}
+
+// STS client used by the credentials commands, a new session is created per call as each call can use different AWS credentials
+type stsClient int
+
+func newSTSClient() stsClient {
+	return stsClient(0)
+}
+
+// Get the AWS account Id the credentials belong to, fails if the credentials are not valid
+func (s stsClient) GetCallerIdentity(ctx context.Context, region, id, secret string) (string, error) {
+	creds := credentials.NewStaticCredentials(id, secret, "")
+	config := aws.NewConfig().WithCredentials(creds).WithRegion(region)
+	sess, _ := session.NewSession(config)
+	svc := sts.New(sess)
+
+	inp := &sts.GetCallerIdentityInput{}
+	out, err := svc.GetCallerIdentityWithContext(ctx, inp)
+	if err != nil {
+		return "", errors.Wrap(err, "get STS caller identity failed")
+	}
+
+	return aws.StringValue(out.Account), nil
+}
+
+// Get the AWS credentials for a shared config profile, temporary credentials are rejected as the controller cannot refresh them
+func getAWSProfileCredentials(profile string) (awsCredentials, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{Profile: profile, SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return awsCredentials{}, errors.Wrapf(err, "new AWS session for profile [%s] failed", profile)
+	}
+	value, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return awsCredentials{}, errors.Wrapf(err, "get AWS profile [%s] credentials failed", profile)
+	}
+	if value.SessionToken != "" {
+		return awsCredentials{}, errors.Errorf("AWS profile [%s] has temporary credentials, an IAM user access key is needed", profile)
+	}
+
+	return awsCredentials{AccessKeyID: value.AccessKeyID, SecretAccessKey: value.SecretAccessKey}, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// What the uninstall did, each entry is [Namespace]/[Name] with any detail
+type uninstallReport struct {
+	DeletedSecrets         []string
+	PatchedServiceAccounts []string
+	DeletedConfigMaps      []string
+	StrippedNamespaces     []string
+}
+
+// Uninstall command, removes the state the controller created, the AWS credentials secrets are left alone
+// The controller should be stopped first, otherwise it recreates the secrets on its next renewal
+func runUninstallCommand(args []string) error {
+	var stripLabels, yes bool
+	config, err := getConfig(args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&stripLabels, "strip-labels", false, "Strip labels - If set the registry, replicated secret and credential set labels, and the service accounts annotation, are also removed from the namespaces")
+		fs.BoolVar(&yes, "yes", false, "Yes - If set the confirmation prompt is skipped")
+	})
+	if err != nil {
+		return errors.Wrap(err, "getConfig failed")
+	}
+
+	if !config.DryRun && !yes {
+		prompt := "This deletes every eatr managed secret and removes them from service accounts in all namespaces, stop the controller first or it will recreate them"
+		if stripLabels {
+			prompt += ", namespace labels are also removed"
+		}
+		if !confirm(os.Stdin, os.Stdout, prompt) {
+			return errors.New("uninstall cancelled")
+		}
+	}
+
+	ctrl, err := newCommandController(config)
+	if err != nil {
+		return err
+	}
+
+	report, err := ctrl.uninstall(stripLabels)
+	if ctrl.DryRun != nil {
+		writePlan(os.Stdout, ctrl.DryRun.plan())
+	} else {
+		writeUninstallReport(os.Stdout, report)
+	}
+
+	return err
+}
+
+// Prompt for a yes or no answer, anything other than y or yes is a no
+func confirm(r io.Reader, w io.Writer, prompt string) bool {
+	fmt.Fprintf(w, "%s\nContinue? [y/N] ", prompt)
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// Remove our secret names from the service accounts we patched, delete the managed secrets and the status config map, and optionally strip the namespace labels
+// Service accounts are done before the secrets so new pods never reference a deleted secret, failures are collected so one namespace does not stop the others
+func (c *controller) uninstall(stripLabels bool) (uninstallReport, error) {
+	report := uninstallReport{}
+	nss, err := c.K8S.GetNamespaces()
+	if err != nil {
+		return report, errors.Wrap(err, "get namespaces failed")
+	}
+	sort.Slice(nss.Items, func(i, j int) bool { return nss.Items[i].Name < nss.Items[j].Name })
+
+	replicatedSecretNames := sets.NewString()
+	if stripLabels {
+		secrets, err := c.K8S.GetSecrets(c.Config.HostNamespace)
+		if err != nil {
+			return report, errors.Wrapf(err, "get namespace [%s] secrets failed", c.Config.HostNamespace)
+		}
+		for i := range secrets.Items {
+			if isReplicatedSecret(&secrets.Items[i]) {
+				replicatedSecretNames.Insert(secrets.Items[i].Name)
+			}
+		}
+	}
+
+	errs := []error{}
+	for _, ns := range nss.Items {
+		if err := c.uninstallNamespace(ns, stripLabels, replicatedSecretNames, &report); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.Config.StatusConfigMapName != "" {
+		err := c.K8S.DeleteConfigMap(c.Config.HostNamespace, c.Config.StatusConfigMapName)
+		if err == nil {
+			report.DeletedConfigMaps = append(report.DeletedConfigMaps, c.Config.HostNamespace+"/"+c.Config.StatusConfigMapName)
+		} else if !k8serr.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "delete namespace [%s] status config map [%s] failed", c.Config.HostNamespace, c.Config.StatusConfigMapName))
+		}
+	}
+
+	return report, utilerrors.NewAggregate(errs)
+}
+
+func (c *controller) uninstallNamespace(ns corev1.Namespace, stripLabels bool, replicatedSecretNames sets.String, report *uninstallReport) error {
+	glog.V(detailiedGLogLevel).Infof("Uninstalling namespace [%s]\n", ns.Name)
+	sas, err := c.K8S.GetServiceAccounts(ns.Name)
+	if err != nil {
+		return errors.Wrapf(err, "get namespace [%s] service accounts failed", ns.Name)
+	}
+	for i := range sas.Items {
+		sa := &sas.Items[i]
+		previous := sa.Annotations[serviceAccountImagePullSecretsAnnotationKey]
+		if !setServiceAccountImagePullSecrets(sa, sets.NewString()) {
+			continue
+		}
+		if _, err := c.K8S.UpdateServiceAccount(ns.Name, sa); err != nil {
+			return errors.Wrapf(err, "update of namespace [%s] service account [%s] failed", ns.Name, sa.Name)
+		}
+		report.PatchedServiceAccounts = append(report.PatchedServiceAccounts, fmt.Sprintf("%s/%s removed [%s]", ns.Name, sa.Name, previous))
+	}
+
+	secrets, err := c.K8S.GetSecrets(ns.Name)
+	if err != nil {
+		return errors.Wrapf(err, "get namespace [%s] secrets failed", ns.Name)
+	}
+	for i := range secrets.Items {
+		sec := &secrets.Items[i]
+		// Secrets written before secrets were labelled are ours too
+		if sec.Labels[managedByLabelKey] != managedByLabelValue && !isPreUpgradeSecret(sec) {
+			continue
+		}
+		if err := c.K8S.DeleteSecret(ns.Name, sec.Name); err != nil && !k8serr.IsNotFound(err) {
+			return errors.Wrapf(err, "delete namespace [%s] secret [%s] failed", ns.Name, sec.Name)
+		}
+		report.DeletedSecrets = append(report.DeletedSecrets, ns.Name+"/"+sec.Name)
+	}
+
+	if !stripLabels {
+		return nil
+	}
+	stripped := []string{}
+	for k := range ns.Labels {
+		if namespaceSecretLabelKeyRegEx.MatchString(k) || replicatedSecretNames.Has(k) || k == credentialSetLabelKey {
+			stripped = append(stripped, k)
+		}
+	}
+	_, annotated := ns.Annotations[serviceAccountsAnnotationKey]
+	if len(stripped) == 0 && !annotated {
+		return nil
+	}
+	sort.Strings(stripped)
+	for _, k := range stripped {
+		delete(ns.Labels, k)
+	}
+	delete(ns.Annotations, serviceAccountsAnnotationKey)
+	if _, err := c.K8S.UpdateNamespace(&ns); err != nil {
+		return errors.Wrapf(err, "update namespace [%s] failed", ns.Name)
+	}
+	report.StrippedNamespaces = append(report.StrippedNamespaces, fmt.Sprintf("%s removed [%s]", ns.Name, strings.Join(stripped, ",")))
+
+	return nil
+}
+
+func writeUninstallReport(w io.Writer, report uninstallReport) {
+	for _, s := range report.PatchedServiceAccounts {
+		fmt.Fprintf(w, "Patched service account %s\n", s)
+	}
+	for _, s := range report.DeletedSecrets {
+		fmt.Fprintf(w, "Deleted secret %s\n", s)
+	}
+	for _, s := range report.DeletedConfigMaps {
+		fmt.Fprintf(w, "Deleted config map %s\n", s)
+	}
+	for _, s := range report.StrippedNamespaces {
+		fmt.Fprintf(w, "Stripped namespace %s\n", s)
+	}
+	fmt.Fprintf(w, "\n%d secrets deleted, %d service accounts patched, %d config maps deleted, %d namespaces stripped\n", len(report.DeletedSecrets), len(report.PatchedServiceAccounts), len(report.DeletedConfigMaps), len(report.StrippedNamespaces))
+}
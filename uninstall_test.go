@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUninstall(t *testing.T) {
+	for _, tc := range []struct {
+		Name                   string // Test case name
+		DryRun                 bool   // Dry run mode
+		StripLabels            bool   // Strip the namespace labels
+		ExpectedNS1Labels      string // Expected comma separated namespace 1 label keys after the uninstall
+		ExpectedDeletedSecrets int    // Expected number of deleted secrets in the report
+	}{
+		{
+			Name:                   "Uninstall",
+			ExpectedNS1Labels:      ecr1 + "," + credentialSetLabelKey + ",team",
+			ExpectedDeletedSecrets: 3,
+		},
+		{
+			Name:                   "Uninstall stripping labels",
+			StripLabels:            true,
+			ExpectedNS1Labels:      "team",
+			ExpectedDeletedSecrets: 3,
+		},
+		{
+			Name:                   "Dry run",
+			DryRun:                 true,
+			StripLabels:            true,
+			ExpectedNS1Labels:      ecr1 + "," + credentialSetLabelKey + ",team",
+			ExpectedDeletedSecrets: 3,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			config := getDefaultConfig()
+			config.PatchServiceAccounts = true
+			k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+				{
+					Name:     config.HostNamespace,
+					IsActive: true,
+					Secrets:  []string{config.AWSCredentialsSecretPrefix + "-team-a-" + ecr1, config.AWSCredentialsSecretPrefix + "-" + ecr1},
+				},
+				{
+					Name:            ns1,
+					IsActive:        true,
+					Labels:          map[string]string{ecr1: "true", credentialSetLabelKey: "team-a", "team": "a"},
+					Secrets:         []string{"unmanaged"},
+					ServiceAccounts: []string{"default"},
+				},
+				{
+					Name:            ns2,
+					IsActive:        true,
+					Labels:          map[string]string{ecr1: "true"},
+					ServiceAccounts: []string{"default"},
+				},
+				{
+					Name:              ns3,
+					IsActive:          true,
+					PreUpgradeSecrets: []string{ecr2},
+				},
+			})
+			// Unlabelled docker config json secret that is not named after an ECR registry
+			k8sClient.CreateSecret(ns3, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "dockerhub"}, Type: corev1.SecretTypeDockerConfigJson})
+
+			ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+			assert.Nil(t, err, "New controller error")
+			err = ctrl.renewECRImagePullSecrets(allNamespacesKey)
+			assert.Nil(t, err, "Renewal error")
+			assert.Equal(t, ecr1, k8sClient.ServiceAccountImagePullSecretNames(ns1, "default"), "Patched service account")
+
+			if tc.DryRun {
+				config.DryRun = true
+				ctrl, err = newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+				assert.Nil(t, err, "New dry run controller error")
+			}
+
+			report, err := ctrl.uninstall(tc.StripLabels)
+			assert.Nil(t, err, "Uninstall error")
+			assert.Equal(t, tc.ExpectedDeletedSecrets, len(report.DeletedSecrets), "Deleted secrets")
+			assert.Equal(t, 2, len(report.PatchedServiceAccounts), "Patched service accounts")
+
+			ns, _ := k8sClient.GetNamespace(ns1)
+			keys := []string{}
+			for k := range ns.Labels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			assert.Equal(t, tc.ExpectedNS1Labels, strings.Join(keys, ","), "Namespace 1 labels")
+			assert.True(t, k8sClient.SecretExists(ns1, "unmanaged"), "Unmanaged secret kept")
+			assert.True(t, k8sClient.SecretExists(config.HostNamespace, config.AWSCredentialsSecretPrefix+"-"+ecr1), "AWS credentials secret kept")
+
+			if tc.DryRun {
+				assert.True(t, k8sClient.SecretExists(ns1, ecr1), "Dry run managed secret kept")
+				assert.Equal(t, ecr1, k8sClient.ServiceAccountImagePullSecretNames(ns1, "default"), "Dry run service account kept")
+				out := &bytes.Buffer{}
+				writePlan(out, ctrl.DryRun.plan())
+				assert.Contains(t, out.String(), "- delete secret "+ns1+"/"+ecr1, "Dry run plan secret")
+				assert.Contains(t, out.String(), "- delete secret "+ns3+"/"+ecr2, "Dry run plan pre-upgrade secret")
+				assert.Contains(t, out.String(), "label ["+ecr1+"] removed", "Dry run plan label")
+				return
+			}
+			assert.False(t, k8sClient.SecretExists(ns1, ecr1), "Managed secret deleted")
+			assert.False(t, k8sClient.SecretExists(ns2, ecr1), "Managed secret deleted")
+			assert.False(t, k8sClient.SecretExists(ns3, ecr2), "Pre-upgrade secret deleted")
+			assert.True(t, k8sClient.SecretExists(ns3, "dockerhub"), "Unlabelled non registry secret kept")
+			assert.Equal(t, "", k8sClient.ServiceAccountImagePullSecretNames(ns1, "default"), "Service account unpatched")
+			assert.Equal(t, "", k8sClient.ConfigMapData(config.HostNamespace, config.StatusConfigMapName, statusConfigMapDataKey), "Status config map deleted")
+
+			out := &bytes.Buffer{}
+			writeUninstallReport(out, report)
+			assert.Contains(t, out.String(), "3 secrets deleted, 2 service accounts patched, 1 config maps deleted", "Report totals")
+		})
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	out := &bytes.Buffer{}
+	assert.True(t, confirm(strings.NewReader("y\n"), out, "Prompt"), "Yes")
+	assert.True(t, confirm(strings.NewReader("YES\n"), out, "Prompt"), "Upper case yes")
+	assert.False(t, confirm(strings.NewReader("\n"), out, "Prompt"), "Default")
+	assert.False(t, confirm(strings.NewReader(""), out, "Prompt"), "No input")
+	assert.Contains(t, out.String(), "Continue? [y/N]", "Prompt")
+}
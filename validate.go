@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	maxAuthenticationTokenRenewalInterval = 12 * time.Hour // ECR authorization tokens are valid for 12 hours
+)
+
+var (
+	awsCredentialsSecretDataKeys = []string{"aws_access_key_id", "aws_region", "aws_secret_access_key"}
+)
+
+// Validate the config, returns all the failures rather than just the first
+func validateConfig(config config) []error {
+	errs := []error{}
+	if config.AuthenticationTokenRenewalInterval <= 0 || config.AuthenticationTokenRenewalInterval >= maxAuthenticationTokenRenewalInterval {
+		errs = append(errs, errors.Errorf("auth-token-renewal-interval [%s] must be greater than 0 and less than %s", config.AuthenticationTokenRenewalInterval, maxAuthenticationTokenRenewalInterval))
+	}
+	if config.AWSCredentialsSecretPrefix == "" {
+		errs = append(errs, errors.New("aws-credentials-secret-prefix must be set"))
+	}
+	if config.HostNamespace == "" {
+		errs = append(errs, errors.New("host-namespace must be set"))
+	} else if msgs := validation.IsDNS1123Label(config.HostNamespace); len(msgs) > 0 {
+		errs = append(errs, errors.Errorf("host-namespace [%s] is not a valid namespace name, %s", config.HostNamespace, strings.Join(msgs, ", ")))
+	}
+	if config.MergedSecretName != "" {
+		if msgs := validation.IsDNS1123Subdomain(config.MergedSecretName); len(msgs) > 0 {
+			errs = append(errs, errors.Errorf("merged-secret-name [%s] is not a valid secret name, %s", config.MergedSecretName, strings.Join(msgs, ", ")))
+		}
+	}
+	for _, nsName := range splitNames(config.Namespaces) {
+		if msgs := validation.IsDNS1123Label(nsName); len(msgs) > 0 {
+			errs = append(errs, errors.Errorf("namespaces [%s] is not a valid namespace name, %s", nsName, strings.Join(msgs, ", ")))
+		}
+	}
+	if config.ConfigReloadInterval < 0 {
+		errs = append(errs, errors.Errorf("config-reload-interval [%s] must not be negative", config.ConfigReloadInterval))
+	}
+	if config.InformersResyncInterval < 0 {
+		errs = append(errs, errors.Errorf("informers-resync-interval [%s] must not be negative", config.InformersResyncInterval))
+	}
+	if config.RenewalStalenessWindow < 0 {
+		errs = append(errs, errors.Errorf("renewal-staleness-window [%s] must not be negative", config.RenewalStalenessWindow))
+	}
+	if config.ShutdownGracePeriod < 0 {
+		errs = append(errs, errors.Errorf("shutdown-grace-period [%s] must not be negative", config.ShutdownGracePeriod))
+	}
+	if config.Port < 1 || config.Port > 65535 {
+		errs = append(errs, errors.Errorf("port [%d] must be between 1 and 65535", config.Port))
+	}
+	if config.WebhookPort != 0 {
+		if config.WebhookPort < 1 || config.WebhookPort > 65535 || config.WebhookPort == config.Port {
+			errs = append(errs, errors.Errorf("webhook-port [%d] must be between 1 and 65535 and differ from port", config.WebhookPort))
+		}
+		if config.WebhookTLSCertFilePath == "" || config.WebhookTLSKeyFilePath == "" {
+			errs = append(errs, errors.New("webhook-port needs webhook-tls-cert-file-path and webhook-tls-key-file-path"))
+		}
+	}
+	if (config.DiagnosticTLSCertFilePath == "") != (config.DiagnosticTLSKeyFilePath == "") {
+		errs = append(errs, errors.New("diagnostic-tls-cert-file-path and diagnostic-tls-key-file-path must both be set"))
+	}
+	if config.DeleteImagePullFailurePods && !config.ReactToImagePullFailures {
+		errs = append(errs, errors.New("delete-image-pull-failure-pods needs react-to-image-pull-failures"))
+	}
+	if config.DiscoveryIncludeWorkloads && !config.DiscoveryMode {
+		errs = append(errs, errors.New("discovery-include-workloads needs discovery-mode"))
+	}
+	if _, err := loadPolicy(config.PolicyFilePath); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// Result of a validate or doctor check
+type checkResult struct {
+	Category string
+	Name     string
+	Err      error
+}
+
+// Write a pass or fail line for each check result, returns the number of failures
+func writeCheckResults(w io.Writer, results []checkResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL  %s  %s  %s\n", result.Category, result.Name, result.Err)
+			continue
+		}
+		fmt.Fprintf(w, "PASS  %s  %s\n", result.Category, result.Name)
+	}
+
+	return failed
+}
+
+// Get a check result per config failure, or a single passing result if the config is valid
+func getConfigChecks(config config) []checkResult {
+	errs := validateConfig(config)
+	if len(errs) == 0 {
+		return []checkResult{{Category: "config", Name: "config is valid"}}
+	}
+
+	res := []checkResult{}
+	for _, err := range errs {
+		res = append(res, checkResult{Category: "config", Name: "config is valid", Err: err})
+	}
+
+	return res
+}
+
+// Check the AWS credentials secret for a credential request exists and has the expected keys, does not check the credentials with AWS
+func (c *controller) checkCredentialRequest(request credentialRequest) (*corev1.Secret, error) {
+	if !request.isValid() {
+		return nil, errors.Errorf("credential set [%s] is not a valid name", request.CredentialSet)
+	}
+
+	awsCredentialsSecretNamespace := request.getAWSCredentialsSecretNamespace(c.Config.HostNamespace)
+	awsCredentialsSecretName := request.getAWSCredentialsSecretName(c.Config.AWSCredentialsSecretPrefix)
+	sec, err := c.K8S.GetSecret(awsCredentialsSecretNamespace, awsCredentialsSecretName)
+	if err != nil {
+		if k8serr.IsNotFound(err) {
+			return nil, errors.Errorf("namespace [%s] AWS credentials secret [%s] was not found", awsCredentialsSecretNamespace, awsCredentialsSecretName)
+		}
+		return nil, errors.Wrapf(err, "get namespace [%s] AWS credentials secret [%s] failed", awsCredentialsSecretNamespace, awsCredentialsSecretName)
+	}
+
+	missing := []string{}
+	for _, key := range awsCredentialsSecretDataKeys {
+		if len(sec.Data[key]) == 0 {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, errors.Errorf("namespace [%s] AWS credentials secret [%s] is missing keys [%s]", awsCredentialsSecretNamespace, awsCredentialsSecretName, strings.Join(missing, ","))
+	}
+
+	return sec, nil
+}
+
+// Get a check result for each credential request the labelled namespaces need, if fetch tokens is set an ECR authorization token is also requested with each complete credentials secret
+func (c *controller) getCredentialChecks(fetchTokens bool) ([]checkResult, error) {
+	inputs, err := c.getRenewalInputs(allNamespacesKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "get renewal inputs failed")
+	}
+	nss, err := c.getNamespacesToProcess(allNamespacesKey, inputs)
+	if err != nil {
+		return nil, errors.Wrap(err, "get namespaces to process failed")
+	}
+
+	res := []checkResult{}
+	for _, request := range c.getDistinctCredentialRequests(nss, inputs) {
+		sec, err := c.checkCredentialRequest(request)
+		res = append(res, checkResult{Category: "credentials", Name: request.String(), Err: err})
+		if err != nil || !fetchTokens {
+			continue
+		}
+
+		_, err = c.ECR.GetAuthToken(context.Background(), string(sec.Data["aws_region"]), string(sec.Data["aws_access_key_id"]), string(sec.Data["aws_secret_access_key"]))
+		if err != nil {
+			err = errors.Wrapf(err, "get ECR authorization token failed for [%s]", request.getRegistry())
+		}
+		res = append(res, checkResult{Category: "ecr", Name: request.String(), Err: err})
+	}
+
+	return res, nil
+}
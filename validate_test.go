@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateConfig(t *testing.T) {
+	for _, tc := range []struct {
+		Name           string               // Test case name
+		Mutate         func(config *config) // Change to make to the default config
+		ExpectedErrors int                  // Expected number of errors
+	}{
+		{
+			Name:   "Default config",
+			Mutate: func(config *config) {},
+		},
+		{
+			Name:           "Renewal interval too long",
+			Mutate:         func(config *config) { config.AuthenticationTokenRenewalInterval = 12 * time.Hour },
+			ExpectedErrors: 1,
+		},
+		{
+			Name:           "Invalid host namespace",
+			Mutate:         func(config *config) { config.HostNamespace = "" },
+			ExpectedErrors: 1,
+		},
+		{
+			Name:           "Invalid namespaces",
+			Mutate:         func(config *config) { config.Namespaces = "ns-1, Team_A" },
+			ExpectedErrors: 1,
+		},
+		{
+			Name: "Negative durations",
+			Mutate: func(config *config) {
+				config.InformersResyncInterval = -time.Minute
+				config.ShutdownGracePeriod = -time.Second
+			},
+			ExpectedErrors: 2,
+		},
+		{
+			Name: "Webhook port with no TLS on the diagnostic port",
+			Mutate: func(config *config) {
+				config.WebhookPort = config.Port
+			},
+			ExpectedErrors: 2,
+		},
+		{
+			Name: "Dependent options not set",
+			Mutate: func(config *config) {
+				config.DeleteImagePullFailurePods = true
+				config.DiscoveryIncludeWorkloads = true
+				config.DiagnosticTLSCertFilePath = "/etc/eatr/tls/tls.crt"
+			},
+			ExpectedErrors: 3,
+		},
+		{
+			Name:           "Policy file missing",
+			Mutate:         func(config *config) { config.PolicyFilePath = "/does/not/exist.yaml" },
+			ExpectedErrors: 1,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			config := getDefaultConfig()
+			tc.Mutate(&config)
+
+			errs := validateConfig(config)
+			assert.Equal(t, tc.ExpectedErrors, len(errs), "Errors")
+		})
+	}
+}
+
+func TestCredentialSecretChecks(t *testing.T) {
+	config := getDefaultConfig()
+	k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+		{
+			Name:     config.HostNamespace,
+			IsActive: true,
+			Secrets:  []string{config.AWSCredentialsSecretPrefix + "-" + ecr1, config.AWSCredentialsSecretPrefix + "-" + ecr2},
+		},
+		{
+			Name:     ns1,
+			IsActive: true,
+			Labels:   map[string]string{ecr1: "true", ecr2: "true", ecr3: "true"},
+		},
+	})
+	k8sClient.UpdateSecret(config.HostNamespace, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: config.AWSCredentialsSecretPrefix + "-" + ecr1},
+		Data:       map[string][]byte{"aws_access_key_id": []byte("id"), "aws_region": []byte("eu-west-1"), "aws_secret_access_key": []byte("secret")},
+	})
+	k8sClient.UpdateSecret(config.HostNamespace, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: config.AWSCredentialsSecretPrefix + "-" + ecr2},
+		Data:       map[string][]byte{"aws_access_key_id": []byte("id"), "aws_region": []byte("eu-west-1")},
+	})
+
+	ctrl, err := newController(config, k8sClient, controllerInformers{}, prometheus.NewRegistry(), NewFakeECRClient())
+	assert.Nil(t, err, "New controller error")
+
+	results, err := ctrl.getCredentialChecks(false)
+	assert.Nil(t, err, "Checks error")
+	out := &bytes.Buffer{}
+	assert.Equal(t, 2, writeCheckResults(out, results), "Failed checks")
+	assert.Contains(t, out.String(), "PASS  credentials  "+ecr1, "Complete credentials")
+	assert.Contains(t, out.String(), "FAIL  credentials  "+ecr2, "Incomplete credentials")
+	assert.Contains(t, out.String(), "missing keys [aws_secret_access_key]", "Incomplete credentials missing keys")
+	assert.Contains(t, out.String(), "FAIL  credentials  "+ecr3, "Missing credentials")
+	assert.Contains(t, out.String(), "was not found", "Missing credentials")
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	defaultImageRegistryHost = "docker.io" // Images with no registry host in the reference, i.e. nginx:1.13, are pulled from docker hub
+)
+
+// JSON patch operation, see http://jsonpatch.com/
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Mutating admission webhook that injects the managed image pull secrets into pods, see https://kubernetes.io/docs/admin/extensible-admission-controllers/
+// Will never deny a pod, if we fail we log and allow the pod as is
+func newAdmissionWebhookHTTPServer(c *controller, certFilePath, keyFilePath string) (*http.Server, error) {
+	cert, err := tls.LoadX509KeyPair(certFilePath, keyFilePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "load TLS certificate failed")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mutate", http.HandlerFunc(c.handleAdmissionReview))
+
+	return &http.Server{Handler: mux, TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}}, nil
+}
+
+func (c *controller) handleAdmissionReview(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "read body failed", http.StatusBadRequest)
+		return
+	}
+
+	review := admissionv1beta1.AdmissionReview{}
+	if err = json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = c.mutatePod(review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+
+	res, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, "marshal admission review failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+func (c *controller) mutatePod(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	res := &admissionv1beta1.AdmissionResponse{Allowed: true}
+	if req.Kind.Kind != "Pod" {
+		return res
+	}
+
+	pod := corev1.Pod{}
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		glog.Warningf("Admission review pod unmarshal failed for namespace [%s]: %s\n", req.Namespace, err)
+		return res
+	}
+
+	secretNames, err := c.getPodImagePullSecretNames(req.Namespace, &pod)
+	if err != nil {
+		glog.Warningf("Admission review get image pull secret names failed for namespace [%s]: %s\n", req.Namespace, err)
+		return res
+	}
+	if len(secretNames) == 0 {
+		return res
+	}
+	// The secrets are not written in dry run mode, so referencing them would break the pod's image pulls
+	if c.DryRun != nil {
+		glog.Infof("Dry run, would inject namespace [%s] pod [%s%s] image pull secrets %v\n", req.Namespace, pod.Name, pod.GenerateName, secretNames)
+		return res
+	}
+
+	patch := []jsonPatchOperation{}
+	if len(pod.Spec.ImagePullSecrets) == 0 {
+		refs := []corev1.LocalObjectReference{}
+		for _, name := range secretNames {
+			refs = append(refs, corev1.LocalObjectReference{Name: name})
+		}
+		patch = append(patch, jsonPatchOperation{Op: "add", Path: "/spec/imagePullSecrets", Value: refs})
+	} else {
+		for _, name := range secretNames {
+			patch = append(patch, jsonPatchOperation{Op: "add", Path: "/spec/imagePullSecrets/-", Value: corev1.LocalObjectReference{Name: name}})
+		}
+	}
+
+	// Cannot fail, only contains strings and references
+	res.Patch, _ = json.Marshal(patch)
+	patchType := admissionv1beta1.PatchTypeJSONPatch
+	res.PatchType = &patchType
+
+	glog.V(detailiedGLogLevel).Infof("Admission review injecting namespace [%s] pod [%s%s] image pull secrets %v\n", req.Namespace, pod.Name, pod.GenerateName, secretNames)
+	return res
+}
+
+// Get the managed secret names the pod needs, based on the registries the namespace is labelled for and the pod's image registry hosts, excludes names the pod already references
+// Pods in namespaces the controller is not scoped to get no secrets
+func (c *controller) getPodImagePullSecretNames(nsName string, pod *corev1.Pod) ([]string, error) {
+	// Served on the webhook's go routine, so uses a copy of the config and policy in case they are reloaded part way through
+	config, registryPolicy := c.getConfigSnapshot()
+	if !isNamespaceInScope(config, nsName) {
+		return nil, nil
+	}
+	ns, err := c.K8S.GetNamespace(nsName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get namespace [%s] failed", nsName)
+	}
+
+	replicatedSecrets, err := c.getReplicatedSecrets(config.HostNamespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "get replicated secrets failed")
+	}
+
+	imagePullCredentials, err := c.getImagePullCredentials(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "get image pull credentials failed")
+	}
+
+	// In discovery mode the pod's own ECR registries are requested, the secret will follow once the pod is seen by the discovery informer
+	inputs := &renewalInputs{ImagePullCredentials: imagePullCredentials, ReplicatedSecrets: replicatedSecrets}
+	if config.DiscoveryMode && isDiscoveryNamespace(config, nsName) {
+		inputs.DiscoveredRegistries = map[string]sets.String{nsName: sets.NewString(getPodSpecECRRegistryHosts(&pod.Spec)...)}
+	}
+
+	// Registry host to secret name, registries denied by the policy will have no secret
+	hostSecretNames := map[string]string{}
+	allowedSecretNames, _ := getAllowedNamespaceSecretNames(registryPolicy, *ns, inputs)
+	for _, k := range allowedSecretNames {
+		secretName := k
+		if config.MergedSecretName != "" {
+			secretName = config.MergedSecretName
+		}
+
+		if sec, ok := replicatedSecrets[k]; ok {
+			for _, host := range getDockerConfigJSONHosts(sec.Data[corev1.DockerConfigJsonKey]) {
+				hostSecretNames[host] = secretName
+			}
+			continue
+		}
+		hostSecretNames[inputs.getRegistry(k)] = secretName
+	}
+
+	existing := sets.NewString()
+	for _, ref := range pod.Spec.ImagePullSecrets {
+		existing.Insert(ref.Name)
+	}
+
+	names := sets.NewString()
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		if secretName, ok := hostSecretNames[getImageRegistryHost(container.Image)]; ok && !existing.Has(secretName) {
+			names.Insert(secretName)
+		}
+	}
+
+	return names.List(), nil
+}
+
+// Get the registry host from an image reference, see https://github.com/docker/distribution/blob/master/reference/normalize.go
+func getImageRegistryHost(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 || !(strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return defaultImageRegistryHost
+	}
+
+	return normaliseRegistryHost(parts[0])
+}
+
+// Get the registry hosts from docker config json secret data, invalid content results in no hosts
+func getDockerConfigJSONHosts(data []byte) []string {
+	config := newDockerConfigJSON()
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil
+	}
+
+	hosts := []string{}
+	for k := range config.Auths {
+		hosts = append(hosts, normaliseRegistryHost(k))
+	}
+
+	return hosts
+}
+
+// Normalise a registry host, docker config json keys can be URLs, i.e. https://index.docker.io/v1/
+func normaliseRegistryHost(host string) string {
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+	if host == "index.docker.io" || host == "registry-1.docker.io" {
+		return defaultImageRegistryHost
+	}
+
+	return host
+}
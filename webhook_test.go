@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+)
+
+func TestHandleAdmissionReview(t *testing.T) {
+	for _, tc := range []struct {
+		Name             string            // Test case name
+		MergedSecretName string            // Merged secret name config
+		NS1Labels        map[string]string // Namespace 1 labels
+		Review           string            // Admission review json
+		ExpectedStatus   int               // Expected HTTP status code
+		ExpectedPatch    string            // Expected json patch, empty if no patch expected
+	}{
+		{
+			Name:           "Invalid admission review",
+			NS1Labels:      map[string]string{ecr1: "true"},
+			Review:         `{ "kind": "AdmissionReview" `,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Name:      "Pod with no images from labelled registries",
+			NS1Labels: map[string]string{ecr1: "true"},
+			Review: `{ "kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1", "request": { "uid": "1", "kind": { "kind": "Pod", "version": "v1" }, "namespace": "ns-1", "operation": "CREATE",
+				"object": { "metadata": { "name": "p1" }, "spec": { "containers": [ { "name": "c1", "image": "nginx:1.13" } ] } } } }`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:      "Pod with image from labelled registry",
+			NS1Labels: map[string]string{ecr1: "true", ecr2: "true"},
+			Review: `{ "kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1", "request": { "uid": "2", "kind": { "kind": "Pod", "version": "v1" }, "namespace": "ns-1", "operation": "CREATE",
+				"object": { "metadata": { "name": "p1" }, "spec": { "containers": [ { "name": "c1", "image": "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app:1.0" }, { "name": "c2", "image": "nginx:1.13" } ] } } } }`,
+			ExpectedStatus: http.StatusOK,
+			ExpectedPatch:  `[{"op":"add","path":"/spec/imagePullSecrets","value":[{"name":"123456789012.dkr.ecr.eu-west-1.amazonaws.com"}]}]`,
+		},
+		{
+			Name:      "Pod with init container image from labelled registry and existing image pull secret",
+			NS1Labels: map[string]string{ecr2: "true"},
+			Review: `{ "kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1", "request": { "uid": "3", "kind": { "kind": "Pod", "version": "v1" }, "namespace": "ns-1", "operation": "CREATE",
+				"object": { "metadata": { "name": "p1" }, "spec": { "imagePullSecrets": [ { "name": "other" } ], "initContainers": [ { "name": "i1", "image": "444456781111.dkr.ecr.us-east-1.amazonaws.com/init" } ], "containers": [ { "name": "c1", "image": "nginx:1.13" } ] } } } }`,
+			ExpectedStatus: http.StatusOK,
+			ExpectedPatch:  `[{"op":"add","path":"/spec/imagePullSecrets/-","value":{"name":"444456781111.dkr.ecr.us-east-1.amazonaws.com"}}]`,
+		},
+		{
+			Name:      "Pod already references the secret",
+			NS1Labels: map[string]string{ecr1: "true"},
+			Review: `{ "kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1", "request": { "uid": "4", "kind": { "kind": "Pod", "version": "v1" }, "namespace": "ns-1", "operation": "CREATE",
+				"object": { "metadata": { "name": "p1" }, "spec": { "imagePullSecrets": [ { "name": "123456789012.dkr.ecr.eu-west-1.amazonaws.com" } ], "containers": [ { "name": "c1", "image": "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app" } ] } } } }`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:             "Merged secret with replicated docker hub secret",
+			MergedSecretName: "eatr-registries",
+			NS1Labels:        map[string]string{ecr1: "true", "dockerhub": "true"},
+			Review: `{ "kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1", "request": { "uid": "5", "kind": { "kind": "Pod", "version": "v1" }, "namespace": "ns-1", "operation": "CREATE",
+				"object": { "metadata": { "name": "p1" }, "spec": { "containers": [ { "name": "c1", "image": "nginx:1.13" }, { "name": "c2", "image": "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app" } ] } } } }`,
+			ExpectedStatus: http.StatusOK,
+			ExpectedPatch:  `[{"op":"add","path":"/spec/imagePullSecrets","value":[{"name":"eatr-registries"}]}]`,
+		},
+		{
+			Name:      "Not a pod",
+			NS1Labels: map[string]string{ecr1: "true"},
+			Review: `{ "kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1", "request": { "uid": "6", "kind": { "kind": "Service", "version": "v1" }, "namespace": "ns-1", "operation": "CREATE",
+				"object": { "metadata": { "name": "s1" } } } }`,
+			ExpectedStatus: http.StatusOK,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			config := getDefaultConfig()
+			config.MergedSecretName = tc.MergedSecretName
+			k8sClient := NewFakeK8SClient([]FakeK8SClientSeedNamespace{
+				{
+					Name:              config.HostNamespace,
+					IsActive:          true,
+					ReplicatedSecrets: []string{"dockerhub"},
+				},
+				{
+					Name:     ns1,
+					IsActive: true,
+					Labels:   tc.NS1Labels,
+				},
+			})
+			nsInformer := NewFakeSharedInformer()
+			secretInformer := NewFakeSharedInformer()
+			prometheusRegistry := prometheus.NewRegistry()
+			ecrClient := NewFakeECRClient()
+
+			ctrl, err := newController(config, k8sClient, controllerInformers{Namespace: nsInformer, HostSecret: secretInformer}, prometheusRegistry, ecrClient)
+			assert.Nil(t, err, "New controller error")
+
+			req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewBufferString(tc.Review))
+			rec := httptest.NewRecorder()
+			ctrl.handleAdmissionReview(rec, req)
+
+			assert.Equal(t, tc.ExpectedStatus, rec.Code, "Status code")
+			if tc.ExpectedStatus != http.StatusOK {
+				return
+			}
+
+			review := admissionv1beta1.AdmissionReview{}
+			err = json.Unmarshal(rec.Body.Bytes(), &review)
+			assert.Nil(t, err, "Response unmarshal error")
+			if assert.NotNil(t, review.Response, "Response") {
+				assert.True(t, review.Response.Allowed, "Allowed")
+				assert.Equal(t, tc.ExpectedPatch, string(review.Response.Patch), "Patch")
+			}
+		})
+	}
+}
+
+func TestGetImageRegistryHost(t *testing.T) {
+	for _, tc := range []struct {
+		Image    string
+		Expected string
+	}{
+		{Image: "nginx", Expected: "docker.io"},
+		{Image: "library/nginx:1.13", Expected: "docker.io"},
+		{Image: "docker.io/library/nginx", Expected: "docker.io"},
+		{Image: "localhost/app", Expected: "localhost"},
+		{Image: "localhost:5000/app", Expected: "localhost:5000"},
+		{Image: "123456789012.dkr.ecr.eu-west-1.amazonaws.com/team/app@sha256:abc", Expected: "123456789012.dkr.ecr.eu-west-1.amazonaws.com"},
+	} {
+		t.Run(tc.Image, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, getImageRegistryHost(tc.Image), "Registry host")
+		})
+	}
+}